@@ -0,0 +1,479 @@
+package rubymarshal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Ruby stores integers outside of this range as bignums, Fixnum is 31-bit
+// on 32-bit builds of Ruby such as the one used by Game.exe
+const (
+	minFixnum = -1 << 30
+	maxFixnum = 1<<30 - 1
+)
+
+// Encoder writes Go values in the Ruby Marshal 4.8 format
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+	// symbols are the indexes of symbols already written in this document
+	symbols map[string]int
+	// objects are the indexes of pointers and classes already written in
+	// this document
+	objects     map[interface{}]int
+	objectCount int
+	err         error
+
+	userDefinedDumpMap map[reflect.Type]userDefinedDump
+}
+
+type userDefinedDump struct {
+	className string
+	dump      func(v reflect.Value) []byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	e := &Encoder{}
+	e.w = w
+	e.userDefinedDumpMap = make(map[reflect.Type]userDefinedDump)
+	return e
+}
+
+// AddUserDefinedDump will use the given callback to dump values of the Go type
+// as the class, this is the counterpart to Decoder.AddUserDefinedLoad.
+func (e *Encoder) AddUserDefinedDump(className string, goType reflect.Type, callback func(v reflect.Value) []byte) {
+	if _, ok := e.userDefinedDumpMap[goType]; ok {
+		panic("cannot add same user defined type more than once: " + goType.String())
+	}
+	e.userDefinedDumpMap[goType] = userDefinedDump{
+		className: className,
+		dump:      callback,
+	}
+}
+
+// Encode writes v as a Marshal document. Calling it multiple times writes
+// the documents one after the other like multiple calls to Marshal.dump.
+//
+// Go strings are written as UTF-8 strings, []byte as binary strings and
+// maps with their keys sorted. Structs can only be written if they're a
+// user-defined type or one of the types in this package.
+func (e *Encoder) Encode(v interface{}) error {
+	e.buf = append(e.buf[:0], supportedMajorVersion, supportedMinorVersion)
+	e.symbols = make(map[string]int)
+	e.objects = make(map[interface{}]int)
+	e.objectCount = 0
+	e.err = nil
+	e.encodeValue(reflect.ValueOf(v))
+	if e.err != nil {
+		return e.err
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+func (e *Encoder) saveError(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *Encoder) encodeValue(val reflect.Value) {
+	if !val.IsValid() {
+		e.buf = append(e.buf, typeNull)
+		return
+	}
+	if userDefined, ok := e.userDefinedDumpMap[val.Type()]; ok {
+		e.registerObject()
+		e.buf = append(e.buf, typeUserDefined)
+		e.writeSymbol(userDefined.className)
+		e.writeBytes(userDefined.dump(val))
+		return
+	}
+	switch val.Kind() {
+	case reflect.Interface:
+		if val.IsNil() {
+			e.buf = append(e.buf, typeNull)
+			return
+		}
+		e.encodeValue(val.Elem())
+	case reflect.Ptr:
+		if val.IsNil() {
+			e.buf = append(e.buf, typeNull)
+			return
+		}
+		e.encodePointer(val)
+	case reflect.Bool:
+		if val.Bool() {
+			e.buf = append(e.buf, typeTrue)
+		} else {
+			e.buf = append(e.buf, typeFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(big.NewInt(val.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeInt(new(big.Int).SetUint64(val.Uint()))
+	case reflect.Float32, reflect.Float64:
+		e.registerObject()
+		e.buf = append(e.buf, typeFloat)
+		e.writeBytes([]byte(formatFloat(val.Float())))
+	case reflect.String:
+		switch val.Type() {
+		case reflect.TypeOf(Symbol("")):
+			e.writeSymbol(val.String())
+		default:
+			e.writeString([]byte(val.String()), EncodingUTF8)
+		}
+	case reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeString(val.Bytes(), EncodingBinary)
+			return
+		}
+		if val.Type().Elem() == keyValueType {
+			e.registerObject()
+			e.buf = append(e.buf, typeHash)
+			e.writeLong(val.Len())
+			for i := 0; i < val.Len(); i++ {
+				pair := val.Index(i).Interface().(KeyValue)
+				e.encodeValue(reflect.ValueOf(pair.Key))
+				e.encodeValue(reflect.ValueOf(pair.Value))
+			}
+			return
+		}
+		e.writeArray(val)
+	case reflect.Array:
+		e.writeArray(val)
+	case reflect.Map:
+		e.registerObject()
+		e.buf = append(e.buf, typeHash)
+		e.writeLong(val.Len())
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return lessMapKey(keys[i], keys[j])
+		})
+		for _, key := range keys {
+			e.encodeValue(key)
+			e.encodeValue(val.MapIndex(key))
+		}
+	case reflect.Struct:
+		e.encodeStruct(val)
+	default:
+		e.saveError(errors.New("rubymarshal: unsupported type: " + val.Type().String()))
+	}
+}
+
+// encodePointer writes the value the pointer points to, pointers to the types
+// in value.go are written as object links if they were already written
+func (e *Encoder) encodePointer(val reflect.Value) {
+	ptr := val.Interface()
+	switch ptr.(type) {
+	case *Object, *Array, *Hash, *RawString, *Regexp, *UserDefined, *UserMarshal, *big.Int:
+		if index, ok := e.objects[ptr]; ok {
+			e.buf = append(e.buf, typeObjectLink)
+			e.writeLong(index)
+			return
+		}
+		e.objects[ptr] = e.objectCount
+	default:
+		e.encodeValue(val.Elem())
+		return
+	}
+	e.objectCount++
+	switch value := ptr.(type) {
+	case *Object:
+		e.buf = append(e.buf, typeObject)
+		e.writeSymbol(value.Class)
+		e.writeLong(len(value.Fields))
+		for _, field := range value.Fields {
+			e.writeSymbol(field.Name)
+			e.encodeValue(reflect.ValueOf(field.Value))
+		}
+	case *Array:
+		e.buf = append(e.buf, typeArray)
+		e.writeLong(len(value.Elements))
+		for _, element := range value.Elements {
+			e.encodeValue(reflect.ValueOf(element))
+		}
+	case *Hash:
+		if value.HasDefault {
+			e.buf = append(e.buf, typeHashDefault)
+		} else {
+			e.buf = append(e.buf, typeHash)
+		}
+		e.writeLong(len(value.Pairs))
+		for _, pair := range value.Pairs {
+			e.encodeValue(reflect.ValueOf(pair.Key))
+			e.encodeValue(reflect.ValueOf(pair.Value))
+		}
+		if value.HasDefault {
+			e.encodeValue(reflect.ValueOf(value.Default))
+		}
+	case *RawString:
+		e.writeStringData(value.Data, value.Encoding)
+	case *Regexp:
+		e.writeRegexpData(*value)
+	case *UserDefined:
+		e.buf = append(e.buf, typeUserDefined)
+		e.writeSymbol(value.Class)
+		e.writeBytes(value.Data)
+	case *UserMarshal:
+		e.buf = append(e.buf, typeUserMarshal)
+		e.writeSymbol(value.Class)
+		e.encodeValue(reflect.ValueOf(value.Data))
+	case *big.Int:
+		e.writeBignumData(value)
+	}
+}
+
+func (e *Encoder) encodeStruct(val reflect.Value) {
+	switch value := val.Interface().(type) {
+	case String:
+		encoding := value.Encoding
+		switch encoding {
+		case EncodingUTF8, EncodingASCII, EncodingBinary:
+		default:
+			// the value has already been converted to UTF-8
+			encoding = EncodingUTF8
+		}
+		e.writeString([]byte(value.Value), encoding)
+	case RawString:
+		e.writeString(value.Data, value.Encoding)
+	case Regexp:
+		e.registerObject()
+		e.writeRegexpData(value)
+	case ClassRef:
+		// a class is the same object wherever it's referenced, so Ruby
+		// writes it once and links to it after that
+		if index, ok := e.objects[value]; ok {
+			e.buf = append(e.buf, typeObjectLink)
+			e.writeLong(index)
+			return
+		}
+		e.objects[value] = e.objectCount
+		e.registerObject()
+		if value.IsModule {
+			e.buf = append(e.buf, typeModule)
+		} else {
+			e.buf = append(e.buf, typeClass)
+		}
+		e.writeBytes([]byte(value.Name))
+	case big.Int:
+		e.writeInt(&value)
+	case KeyValue:
+		e.saveError(errors.New("rubymarshal: KeyValue must be in a slice to be encoded as a hash"))
+	default:
+		e.saveError(errors.New("rubymarshal: no Ruby class for struct: " + val.Type().String()))
+	}
+}
+
+// registerObject counts an object that isn't written with encodePointer so
+// that the indexes of object links match what Ruby expects
+func (e *Encoder) registerObject() {
+	e.objectCount++
+}
+
+func (e *Encoder) writeArray(val reflect.Value) {
+	e.registerObject()
+	e.buf = append(e.buf, typeArray)
+	e.writeLong(val.Len())
+	for i := 0; i < val.Len(); i++ {
+		e.encodeValue(val.Index(i))
+	}
+}
+
+func (e *Encoder) writeInt(v *big.Int) {
+	if v.IsInt64() && v.Int64() >= minFixnum && v.Int64() <= maxFixnum {
+		e.buf = append(e.buf, typeFixNum)
+		e.writeLong(int(v.Int64()))
+		return
+	}
+	e.registerObject()
+	e.writeBignumData(v)
+}
+
+func (e *Encoder) writeBignumData(v *big.Int) {
+	e.buf = append(e.buf, typeBignum)
+	if v.Sign() < 0 {
+		e.buf = append(e.buf, '-')
+	} else {
+		e.buf = append(e.buf, '+')
+	}
+	// little-endian bytes padded to a multiple of 16-bits
+	data := new(big.Int).Abs(v).Bytes()
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+	e.writeLong(len(data) / 2)
+	e.buf = append(e.buf, data...)
+}
+
+// writeString writes a string that isn't shared with any other object
+func (e *Encoder) writeString(data []byte, encoding string) {
+	e.registerObject()
+	e.writeStringData(data, encoding)
+}
+
+func (e *Encoder) writeStringData(data []byte, encoding string) {
+	if encoding == EncodingBinary || encoding == "" {
+		e.buf = append(e.buf, typeString)
+		e.writeBytes(data)
+		return
+	}
+	e.buf = append(e.buf, typeIVar, typeString)
+	e.writeBytes(data)
+	e.writeEncoding(encoding)
+}
+
+func (e *Encoder) writeRegexpData(value Regexp) {
+	if value.Encoding != EncodingBinary && value.Encoding != "" {
+		e.buf = append(e.buf, typeIVar)
+	}
+	e.buf = append(e.buf, typeRegexp)
+	e.writeBytes([]byte(value.Source))
+	e.buf = append(e.buf, byte(value.Options))
+	if value.Encoding != EncodingBinary && value.Encoding != "" {
+		e.writeEncoding(value.Encoding)
+	}
+}
+
+// writeEncoding writes the instance variables for a string's encoding
+func (e *Encoder) writeEncoding(encoding string) {
+	e.writeLong(1)
+	switch encoding {
+	case EncodingUTF8:
+		e.writeSymbol("E")
+		e.buf = append(e.buf, typeTrue)
+	case EncodingASCII:
+		e.writeSymbol("E")
+		e.buf = append(e.buf, typeFalse)
+	default:
+		e.writeSymbol("encoding")
+		e.writeString([]byte(encoding), EncodingBinary)
+	}
+}
+
+func (e *Encoder) writeSymbol(symbol string) {
+	if index, ok := e.symbols[symbol]; ok {
+		e.buf = append(e.buf, typeSymbolLink)
+		e.writeLong(index)
+		return
+	}
+	e.symbols[symbol] = len(e.symbols)
+	if isASCII(symbol) {
+		e.buf = append(e.buf, typeSymbol)
+		e.writeBytes([]byte(symbol))
+		return
+	}
+	e.buf = append(e.buf, typeIVar, typeSymbol)
+	e.writeBytes([]byte(symbol))
+	e.writeEncoding(EncodingUTF8)
+}
+
+func (e *Encoder) writeBytes(data []byte) {
+	e.writeLong(len(data))
+	e.buf = append(e.buf, data...)
+}
+
+// writeLong is a port of w_long from marshal.c
+func (e *Encoder) writeLong(v int) {
+	switch {
+	case v == 0:
+		e.buf = append(e.buf, 0)
+		return
+	case v > 0 && v < 123:
+		e.buf = append(e.buf, byte(v+5))
+		return
+	case v < 0 && v > -124:
+		e.buf = append(e.buf, byte(v-5))
+		return
+	}
+	var data [4]byte
+	for i := 1; i <= len(data); i++ {
+		data[i-1] = byte(v)
+		v >>= 8
+		if v == 0 {
+			e.buf = append(e.buf, byte(i))
+			e.buf = append(e.buf, data[:i]...)
+			return
+		}
+		if v == -1 {
+			e.buf = append(e.buf, byte(-i))
+			e.buf = append(e.buf, data[:i]...)
+			return
+		}
+	}
+	e.saveError(errors.New("rubymarshal: length too large: " + strconv.Itoa(v)))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// formatFloat formats a float like w_float in Ruby 1.9.2's marshal.c, the
+// version used by RPG Maker VX Ace. The float is written with "%.17g"
+// followed by the low bits of the mantissa, see parseFloat.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	case f == 0:
+		if math.Signbit(f) {
+			return "-0"
+		}
+		return "0"
+	}
+	return fmt.Sprintf("%.17g", f) + saveMantissa(f)
+}
+
+// saveMantissa is a port of save_mantissa from Ruby 1.9.2's marshal.c
+func saveMantissa(d float64) string {
+	frac, _ := math.Frexp(math.Abs(d))
+	_, d = math.Modf(math.Ldexp(frac, decimalMantissa))
+	if d <= 0 {
+		return ""
+	}
+	buf := []byte{0}
+	for d > 0 {
+		var n float64
+		n, d = math.Modf(math.Ldexp(d, mantissaBits))
+		m := uint32(n)
+		buf = append(buf, byte(m>>24), byte(m>>16), byte(m>>8), byte(m))
+	}
+	for buf[len(buf)-1] == 0 {
+		buf = buf[:len(buf)-1]
+	}
+	return string(buf)
+}
+
+// lessMapKey orders map keys so that hashes are always written the same way
+func lessMapKey(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package rubymarshal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Encoding names as written by Ruby
+const (
+	EncodingUTF8   = "UTF-8"
+	EncodingASCII  = "US-ASCII"
+	EncodingBinary = "ASCII-8BIT"
+)
+
+// String is a Ruby string converted to UTF-8 along with the encoding it
+// was stored in
+//
+// Use this as a field type instead of string to find out the original encoding.
+type String struct {
+	Value    string
+	Encoding string
+}
+
+// RawString is a Ruby string that hasn't been converted to UTF-8
+//
+// Use this as a field type for binary data, such as the zlib compressed
+// scripts in "Scripts.rvdata2". Decoding into []byte also keeps the data
+// as-is but doesn't keep the encoding.
+type RawString struct {
+	Data     []byte
+	Encoding string
+}
+
+// String returns the data converted to a Go string without any transcoding
+func (str RawString) String() string {
+	return string(str.Data)
+}
+
+// Regexp is a Ruby regular expression
+type Regexp struct {
+	Source string
+	// Options is a bitmask of Regexp::IGNORECASE (1), Regexp::EXTENDED (2)
+	// and Regexp::MULTILINE (4)
+	Options  int
+	Encoding string
+}
+
+// Transcoder converts string data stored in the given Ruby encoding to UTF-8
+type Transcoder func(encoding string, data []byte) (string, error)
+
+// SetTranscoder sets the function used to convert strings to UTF-8.
+//
+// It is called for every string that isn't UTF-8, US-ASCII or ASCII-8BIT
+// (binary). Without a transcoder only ISO-8859-1 and Windows-1252 are
+// converted and other encodings, such as Shift_JIS, are left as the raw bytes.
+func (d *Decoder) SetTranscoder(transcoder Transcoder) {
+	d.transcoder = transcoder
+}
+
+// transcode converts the string data to UTF-8
+func (d *Decoder) transcode(encoding string, data []byte) string {
+	switch encoding {
+	case EncodingUTF8, EncodingASCII, EncodingBinary:
+		return string(data)
+	}
+	if d.transcoder != nil {
+		str, err := d.transcoder(encoding, data)
+		if err != nil {
+			d.saveError(err)
+			return string(data)
+		}
+		return str
+	}
+	switch strings.ToUpper(encoding) {
+	case "ISO-8859-1":
+		return decodeSingleByte(data, nil)
+	case "WINDOWS-1252", "CP1252":
+		return decodeSingleByte(data, &windows1252)
+	}
+	return string(data)
+}
+
+// decodeSingleByte converts a single byte encoding where the bytes below 0x80
+// are ASCII and bytes 0x80 to 0x9F are looked up in the table (if given).
+// Other bytes are the same as their Unicode code point.
+func decodeSingleByte(data []byte, table *[32]rune) string {
+	var b strings.Builder
+	b.Grow(len(data))
+	for _, c := range data {
+		switch {
+		case c < utf8.RuneSelf:
+			b.WriteByte(c)
+		case table != nil && c < 0xA0:
+			b.WriteRune(table[c-0x80])
+		default:
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String()
+}
+
+// windows1252 is bytes 0x80 to 0x9F of Windows-1252, unused bytes map to
+// the control character with the same code point
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 // This is the version RPG Maker VX Ace programs seemingly use for the latest
@@ -31,38 +34,68 @@ const (
 	typeUserDefined = 'u'
 	typeHash        = '{'
 	typeFloat       = 'f'
-	// note(jae): 2021-06-13
-	// unimplemented
-	// typeFixNum      = 'i'
-	// typeBignum = 'l'
-	// typeClass = 'c'
-	// typeModule = 'm'
-)
-
-const (
-	// note(jae): 2021-06-09
-	// enable when developing only.
-	// should not be on for releases
-	devMode = true
+	typeRegexp      = '/'
+	typeBignum      = 'l'
+	typeObjectLink  = '@'
+	typeUserMarshal = 'U'
+	typeHashDefault = '}'
+	typeClass       = 'c'
+	typeModule      = 'm'
 )
 
 type Decoder struct {
 	r                  *bytes.Buffer
+	data               []byte
 	symbols            []string
+	objects            []objectEntry
 	userDefinedLoadMap map[string]func(data []byte, v reflect.Value)
+	userMarshalLoadMap map[string]func(data interface{}, v reflect.Value)
 	// topValue is stored so we can print it and debug the structure
 	// while parsing
 	topValue   interface{}
 	savedError error
+
+	allowUnknownFields bool
+	preserveObjects    bool
+	transcoder         Transcoder
+
+	// replaying is non-zero while decoding an object link, objects and
+	// symbols aren't registered again while replaying
+	replaying int
+	// ivarOffset is the offset of an instance variable wrapper, so the
+	// wrapped object is replayed along with its instance variables
+	ivarOffset int
+	// objectIndex is the index of the object being decoded or -1
+	objectIndex int
+}
+
+// objectEntry is an object that can be referenced by an object link
+type objectEntry struct {
+	// offset is where the object starts in the data
+	offset int
+	// parsed is false while the object is being decoded
+	parsed bool
+	// value is the decoded value when preserving objects, so that links
+	// decode to the same pointer
+	value interface{}
 }
 
 func NewDecoder(byteData []byte) *Decoder {
 	s := &Decoder{}
 	s.r = bytes.NewBuffer(byteData)
+	s.data = byteData
+	s.ivarOffset = -1
 	s.userDefinedLoadMap = make(map[string]func(data []byte, v reflect.Value))
+	s.userMarshalLoadMap = make(map[string]func(data interface{}, v reflect.Value))
 	return s
 }
 
+// Decode decodes the next Marshal document into v.
+//
+// Data written with multiple calls to Marshal.dump, such as save files,
+// is decoded by calling Decode once for each document. Decode returns io.EOF
+// if there are no more documents. After an error the position in the data
+// is undefined and the remaining documents can't be decoded.
 func (d *Decoder) Decode(v interface{}) (err error) {
 	val := reflect.ValueOf(v)
 	if val.Kind() != reflect.Ptr {
@@ -76,11 +109,8 @@ func (d *Decoder) Decode(v interface{}) (err error) {
 			return errors.New("unable to call Set on given type")
 		}
 	}
-	if val.Type().Elem().Kind() == reflect.Ptr {
-		// todo(Jae): 2021-06-13
-		// remove this if we touch-up code to work with pointer-to-pointer
-		// ie. add an indirect() function so we can support "*Struct" not just "Struct"
-		return errors.New("pointer-to-pointer indirection not implemented")
+	if !d.More() {
+		return io.EOF
 	}
 	major, err := d.r.ReadByte()
 	if err != nil {
@@ -93,6 +123,13 @@ func (d *Decoder) Decode(v interface{}) (err error) {
 	if major != supportedMajorVersion || minor > supportedMinorVersion {
 		return errors.New("unsupported marshal version")
 	}
+	// symbols, object links and errors only refer to the current document
+	d.symbols = d.symbols[:0]
+	d.objects = d.objects[:0]
+	d.savedError = nil
+	d.replaying = 0
+	d.ivarOffset = -1
+	d.objectIndex = -1
 	d.topValue = v
 	if err := d.parseRootTypeAndRecoverPanic(val); err != nil {
 		return err
@@ -106,6 +143,11 @@ func (d *Decoder) Decode(v interface{}) (err error) {
 	return nil
 }
 
+// More reports whether there is another document to decode
+func (d *Decoder) More() bool {
+	return d.r.Len() > 0
+}
+
 // AddUserDefinedLoad will use the given callback to handle the class type data
 //
 // This was implemented so we could support RPG Maker VX Ace types such as "Table"
@@ -116,6 +158,39 @@ func (d *Decoder) AddUserDefinedLoad(className string, callback func(d []byte, v
 	d.userDefinedLoadMap[className] = callback
 }
 
+// AddUserMarshalLoad will use the given callback to handle objects of the
+// class that were dumped with marshal_dump, the callback is given the dumped
+// data decoded as an interface{}.
+//
+// Objects of classes without a callback have their data decoded directly
+// into the value.
+func (d *Decoder) AddUserMarshalLoad(className string, callback func(data interface{}, v reflect.Value)) {
+	if _, ok := d.userMarshalLoadMap[className]; ok {
+		panic("cannot add same user marshal type more than once: " + className)
+	}
+	d.userMarshalLoadMap[className] = callback
+}
+
+// AllowUnknownFields causes the Decoder to skip object fields that have no
+// matching struct field rather than failing.
+//
+// This is useful for data modified by scripts that add their own fields.
+func (d *Decoder) AllowUnknownFields() {
+	d.allowUnknownFields = true
+}
+
+// PreserveObjects causes the Decoder to decode values stored in interface{}
+// as the types in value.go, ie. *Object, *Hash and Symbol, rather than maps
+// and strings.
+//
+// This keeps the class names, instance variable order, string encodings and
+// shared objects, so that the data can be written again with an Encoder
+// without losing anything. User-defined types are decoded as *UserDefined
+// and user marshal types as *UserMarshal rather than calling the callbacks.
+func (d *Decoder) PreserveObjects() {
+	d.preserveObjects = true
+}
+
 // debugTopValue pretty prints the top value with JSON
 func (d *Decoder) debugTopValue() string {
 	dat, err := json.MarshalIndent(d.topValue, "", "    ")
@@ -126,18 +201,17 @@ func (d *Decoder) debugTopValue() string {
 }
 
 func (d *Decoder) parseRootTypeAndRecoverPanic(val reflect.Value) (err error) {
-	// allow disabling of catching panic() for development purposes
-	if !devMode {
-		defer func() {
-			if r := recover(); r != nil {
-				if re, ok := r.(*rubyError); ok {
-					err = re
-				} else {
-					panic(r)
-				}
+	// truncated or corrupt data panics while parsing, which is returned as an
+	// error so that callers reading untrusted files don't crash
+	defer func() {
+		if r := recover(); r != nil {
+			if recovered, ok := r.(error); ok {
+				err = recovered
+			} else {
+				err = fmt.Errorf("ruby: %v", r)
 			}
-		}()
-	}
+		}
+	}()
 	d.parseType(val)
 	return
 }
@@ -151,6 +225,15 @@ func (err *invalidFloat64) Error() string {
 	return "failed to parse float64 (double) from ruby data: " + err.Err.Error()
 }
 
+type overflowError struct {
+	Value string
+	Type  string
+}
+
+func (err *overflowError) Error() string {
+	return "ruby value " + err.Value + " overflows Go type " + err.Type
+}
+
 type unexpectedType struct {
 	Got      string
 	Expected string
@@ -172,23 +255,131 @@ func (err *unexpectedType) Error() string {
 	return "expected type " + err.Expected + " but got " + err.Got
 }
 
+// KeyValue is a key-value pair of a Ruby hash
+//
+// Decode hashes into []KeyValue to keep keys that can't be used as Go map
+// keys, such as the [map_id, event_id, "A"] arrays used by self switches.
+// Hashes decoded into interface{} are also stored as []KeyValue if any key
+// isn't a string or integer.
+type KeyValue struct {
+	Key   interface{}
+	Value interface{}
+}
+
+var keyValueType = reflect.TypeOf(KeyValue{})
+
+// indirect follows val through any pointers, allocating nil ones, so that
+// values can be decoded into *T and **T
+func indirect(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Ptr && val.Elem().CanSet() {
+		if val.Elem().IsNil() {
+			val.Elem().Set(reflect.New(val.Type().Elem().Elem()))
+		}
+		val = val.Elem()
+	}
+	return val
+}
+
 func (d *Decoder) parseType(val reflect.Value) {
-	switch kind := d.MustReadByte(); kind {
+	offset := d.offset()
+	if d.ivarOffset >= 0 {
+		offset = d.ivarOffset
+		d.ivarOffset = -1
+	}
+	kind := d.MustReadByte()
+	if kind != typeNull {
+		val = indirect(val)
+	}
+	objectIndex := d.registerObject(kind, offset)
+	d.objectIndex = objectIndex
+	d.parseValue(kind, val)
+	if kind == typeHashDefault {
+		// the default value follows the key-value pairs
+		var defaultValue interface{}
+		d.parseType(reflect.ValueOf(&defaultValue))
+		if val.Elem().Kind() == reflect.Interface && val.Elem().CanInterface() {
+			if hash, ok := val.Elem().Interface().(*Hash); ok {
+				hash.Default = defaultValue
+				hash.HasDefault = true
+			}
+		}
+	}
+	if objectIndex >= 0 {
+		d.objects[objectIndex].parsed = true
+	}
+}
+
+// offset returns the position of the next byte to be read
+func (d *Decoder) offset() int {
+	return len(d.data) - d.r.Len()
+}
+
+// registerObject records where an object starts so that it can be found by
+// object links. It returns the index of the object or -1 if the type isn't
+// an object, ie. nil, integers and symbols.
+func (d *Decoder) registerObject(kind byte, offset int) int {
+	if d.replaying > 0 {
+		return -1
+	}
+	switch kind {
+	case typeString, typeRegexp, typeArray, typeHash, typeHashDefault, typeObject, typeFloat,
+		typeBignum, typeUserDefined, typeUserMarshal, typeClass, typeModule:
+		d.objects = append(d.objects, objectEntry{offset: offset})
+		return len(d.objects) - 1
+	}
+	return -1
+}
+
+// parseObjectLink decodes the linked object again from where it was first
+// written.
+//
+// Links to an object that is still being decoded, ie. an array that contains
+// itself, are left as the zero value. This includes links followed while the
+// object is being replayed, otherwise an object that contains itself would
+// be replayed forever.
+func (d *Decoder) parseObjectLink(val reflect.Value) {
+	index := d.parseInt()
+	if index < 0 || index >= len(d.objects) {
+		panic(newRubyError("invalid object link: " + strconv.Itoa(index)))
+	}
+	object := d.objects[index]
+	if object.value != nil && val.Elem().Kind() == reflect.Interface && val.Elem().CanSet() {
+		// shared objects and objects that contain themselves are decoded
+		// as the same pointer
+		val.Elem().Set(reflect.ValueOf(object.value))
+		return
+	}
+	if !object.parsed {
+		return
+	}
+	r := d.r
+	d.r = bytes.NewBuffer(d.data[object.offset:])
+	d.replaying++
+	d.objects[index].parsed = false
+	d.parseType(val)
+	d.objects[index].parsed = true
+	d.replaying--
+	d.r = r
+}
+
+// storeObject keeps the value decoded for the current object when
+// preserving objects. It must be called before decoding any child values.
+func (d *Decoder) storeObject(value interface{}) {
+	if d.objectIndex >= 0 {
+		d.objects[d.objectIndex].value = value
+	}
+}
+
+func (d *Decoder) parseValue(kind byte, val reflect.Value) {
+	switch kind {
 	case typeNull: // 0
 		val = val.Elem()
 		if !val.CanSet() {
 			// skip if cannot set
 			return
 		}
-		switch val.Kind() {
-		case reflect.Interface, reflect.Struct, reflect.String, reflect.Int, reflect.Int32, reflect.Int64:
-			val.Set(reflect.Zero(val.Type()))
-		default:
-			d.saveError(&unexpectedType{
-				Got:      val.Kind().String(),
-				Expected: "pointer, struct, interface, string or integer",
-			})
-		}
+		// nil sets any kind to its zero value, ie. nil slices, false bools
+		val.Set(reflect.Zero(val.Type()))
 	case typeTrue: // 'T'
 		val = val.Elem()
 		if !val.CanSet() {
@@ -226,77 +417,52 @@ func (d *Decoder) parseType(val reflect.Value) {
 			// skip if cannot set
 			return
 		}
-		var floatingNumber float64
-		switch str {
-		case "nan":
-			floatingNumber = math.NaN()
-		case "inf":
-			floatingNumber = math.MaxFloat64
-		case "-inf":
-			floatingNumber = -math.MaxFloat64
-		default:
-			// note(jae): 2021-07-11
-			// sample data: 0.56659999999999999\0<6  <-  where \0 is a null-byte
-			//
-			// Not sure what to do with the "\0<6" part, so I'm just gonna
-			// ignore it. Seems related to the load_mantissa code.
-			// https://github.com/ruby/ruby/blob/e330bbeeb1bd70180e5f6b835f2a39488e6c2d42/marshal.c
-			strLen := len(str)
-			for i, c := range str {
-				if c == 0 {
-					strLen = i
-					break
-				}
-			}
-			str = str[:strLen]
-			var err error
-			floatingNumber, err = strconv.ParseFloat(str, 64)
-			if err != nil {
-				d.saveError(&invalidFloat64{Value: str, Err: err})
-				return
-			}
+		floatingNumber, err := parseFloat(str)
+		if err != nil {
+			d.saveError(&invalidFloat64{Value: str, Err: err})
+			return
 		}
 		switch val.Kind() {
 		case reflect.Interface:
 			val.Set(reflect.ValueOf(floatingNumber))
-		case reflect.Float64:
+		case reflect.Float32, reflect.Float64:
+			if val.OverflowFloat(floatingNumber) && !math.IsInf(floatingNumber, 0) {
+				d.saveError(&overflowError{Value: str, Type: val.Type().String()})
+				return
+			}
 			val.SetFloat(floatingNumber)
 		default:
 			d.saveError(&unexpectedType{
 				Got:      val.Kind().String(),
-				Expected: "float64",
+				Expected: "float32 or float64",
 			})
 		}
 	case typeFixNum: // i
 		intValue := d.parseInt()
-		val := val.Elem()
-		if !val.CanSet() {
-			// skip if cannot set
-			return
-		}
-		switch val.Kind() {
-		case reflect.Interface:
-			val.Set(reflect.ValueOf(int(intValue)))
-		case reflect.Int, reflect.Int32, reflect.Int64:
-			val.SetInt(int64(intValue))
-		default:
-			d.saveError(&unexpectedType{
-				Got:      val.Kind().String(),
-				Expected: "int, int32 or int64",
-			})
-		}
+		d.setInt(val, int64(intValue))
+	case typeBignum: // l
+		d.setBignum(val, d.parseBignum())
 	case typeSymbol: // :
 		symbol := d.parseSymbol()
-		val.Elem().Set(reflect.ValueOf(symbol))
+		d.setSymbol(val, symbol)
 	case typeSymbolLink: // ;
 		symbol := d.parseIndexAndLookupSymbol()
-		val.Elem().Set(reflect.ValueOf(symbol))
+		d.setSymbol(val, symbol)
 	case typeArray: // [
-		size := d.parseInt()
+		size := d.parseLength("array")
 		switch val.Kind() {
 		case reflect.Ptr:
 			switch val := val.Elem(); val.Kind() {
 			case reflect.Interface:
+				if d.preserveObjects {
+					arr := &Array{Elements: make([]interface{}, size)}
+					d.storeObject(arr)
+					val.Set(reflect.ValueOf(arr))
+					for i := 0; i < size; i++ {
+						d.parseType(reflect.ValueOf(&arr.Elements[i]))
+					}
+					return
+				}
 				if size == 0 {
 					// show [] instead of nil when printing to JSON
 					arr := make([]interface{}, 0)
@@ -319,14 +485,23 @@ func (d *Decoder) parseType(val reflect.Value) {
 				}
 				newv := reflect.MakeSlice(val.Type(), size, size)
 				val.Set(newv)
-				if val.Type().Elem().Kind() == reflect.Ptr {
-					for i := 0; i < size; i++ {
-						d.parseType(val.Index(i))
-					}
-				} else {
-					for i := 0; i < size; i++ {
+				for i := 0; i < size; i++ {
+					d.parseType(val.Index(i).Addr())
+				}
+				return
+			case reflect.Array:
+				// like encoding/json, extra elements are skipped and
+				// missing elements are zeroed
+				for i := 0; i < size; i++ {
+					if i < val.Len() {
 						d.parseType(val.Index(i).Addr())
+						continue
 					}
+					var unusedItem interface{}
+					d.parseType(reflect.ValueOf(&unusedItem))
+				}
+				for i := size; i < val.Len(); i++ {
+					val.Index(i).Set(reflect.Zero(val.Type().Elem()))
 				}
 				return
 			}
@@ -334,7 +509,7 @@ func (d *Decoder) parseType(val reflect.Value) {
 		// if unable to parse array, store error and skip array data in parsing
 		d.saveError(&unexpectedType{
 			Got:      val.Kind().String(),
-			Expected: "ptr interface or ptr slice",
+			Expected: "ptr interface, ptr slice or ptr array",
 		})
 		var emptyInterface interface{}
 		refEmptyInterface := reflect.ValueOf(&emptyInterface)
@@ -342,45 +517,51 @@ func (d *Decoder) parseType(val reflect.Value) {
 			d.parseType(refEmptyInterface)
 		}
 	case typeString: // "
-		str := d.parseString()
-		val = val.Elem()
-		if val.Kind() != reflect.Interface && val.Kind() != reflect.String {
-			d.saveError(&unexpectedType{
-				Got:      val.Kind().String(),
-				Expected: reflect.String.String(),
-			})
-			return
-		}
-		val.Set(reflect.ValueOf(str))
+		// note: strings without an encoding are binary strings in Ruby 1.9
+		data := d.parseBytes()
+		d.setString(val, data, EncodingBinary)
 	case typeIVar: // I
-		// Load ivar from type
-		var ivarData string
+		// Load instance variables for the wrapped type, these are used by
+		// Ruby 1.9 to store the encoding of strings, regexps and symbols
+		offset := d.offset() - 1
 		switch ivarKind := d.MustReadByte(); ivarKind {
 		case typeString:
-			ivarData = d.parseString()
+			objectIndex := d.registerObject(ivarKind, offset)
+			data := d.parseBytes()
+			encoding := d.parseIVarsEncoding()
+			d.objectIndex = objectIndex
+			d.setString(val, data, encoding)
+			if objectIndex >= 0 {
+				d.objects[objectIndex].parsed = true
+			}
+		case typeRegexp:
+			objectIndex := d.registerObject(ivarKind, offset)
+			data := d.parseBytes()
+			options := d.MustReadByte()
+			encoding := d.parseIVarsEncoding()
+			d.objectIndex = objectIndex
+			d.setRegexp(val, data, int(options), encoding)
+			if objectIndex >= 0 {
+				d.objects[objectIndex].parsed = true
+			}
 		default:
-			panic(newRubyError("expected symbol type '\"' but got '" + string(ivarKind) + "'"))
-		}
-		if symbolCharLen := d.parseInt(); symbolCharLen == 1 {
-			_ = d.parseSymbolOrSymbolLink() // can be symbol or symbol link
-			if boolKind := d.MustReadByte(); boolKind != typeTrue {
-				panic(newRubyError("expected type 'T' but got '" + string(boolKind) + "'"))
+			// ie. arrays, objects, symbols
+			if err := d.r.UnreadByte(); err != nil {
+				panic(err)
 			}
+			d.ivarOffset = offset
+			d.parseType(val)
+			_ = d.parseIVarsEncoding()
 		}
-		val := val.Elem()
-		if val.Kind() != reflect.Interface && val.Kind() != reflect.String {
-			d.saveError(&unexpectedType{
-				Got:      val.Kind().String(),
-				Expected: reflect.String.String(),
-			})
-			return
-		}
-		val.Set(reflect.ValueOf(ivarData))
+	case typeRegexp: // /
+		data := d.parseBytes()
+		options := d.MustReadByte()
+		d.setRegexp(val, data, int(options), EncodingBinary)
 	case typeObject: // o
 		// read class name of object
 		// ie. "RPG::Tileset"
-		_ = d.parseSymbolOrSymbolLink()
-		fieldCount := d.parseInt()
+		className := d.parseSymbolOrSymbolLink()
+		fieldCount := d.parseLength("object")
 
 		// DEBUG: Print class name to help with debugging
 		// log.Printf("Class name: %s\n", className)
@@ -415,6 +596,16 @@ func (d *Decoder) parseType(val reflect.Value) {
 			}*/
 			switch val.Kind() {
 			case reflect.Interface:
+				if d.preserveObjects {
+					obj := &Object{Class: className, Fields: make([]Field, fieldCount)}
+					d.storeObject(obj)
+					val.Set(reflect.ValueOf(obj))
+					for i := 0; i < fieldCount; i++ {
+						obj.Fields[i].Name = d.parseSymbolOrSymbolLink()
+						d.parseType(reflect.ValueOf(&obj.Fields[i].Value))
+					}
+					return
+				}
 				obj := make(map[string]interface{}, fieldCount)
 				val.Set(reflect.ValueOf(obj))
 				for i := 0; i < fieldCount; i++ {
@@ -452,7 +643,7 @@ func (d *Decoder) parseType(val reflect.Value) {
 						d.parseType(reflect.ValueOf(&unusedField))
 					}
 				}
-				if len(unknownFields) > 0 {
+				if len(unknownFields) > 0 && !d.allowUnknownFields {
 					panic(newRubyError(fmt.Sprintf("ruby: unknown object fields %v for struct %s", unknownFields, refType.String())))
 				}
 			default:
@@ -473,8 +664,8 @@ func (d *Decoder) parseType(val reflect.Value) {
 		default:
 			panic(fmt.Sprintf("object: unhandled type: %T", val))
 		}
-	case typeHash: // {
-		size := d.parseInt()
+	case typeHash, typeHashDefault: // { and }
+		size := d.parseLength("hash")
 
 		switch val.Kind() {
 		case reflect.Ptr:
@@ -491,52 +682,61 @@ func (d *Decoder) parseType(val reflect.Value) {
 			}
 			switch val.Kind() {
 			case reflect.Interface:
+				if d.preserveObjects {
+					hash := &Hash{Pairs: make([]KeyValue, size)}
+					d.storeObject(hash)
+					val.Set(reflect.ValueOf(hash))
+					for i := 0; i < size; i++ {
+						d.parseType(reflect.ValueOf(&hash.Pairs[i].Key))
+						d.parseType(reflect.ValueOf(&hash.Pairs[i].Value))
+					}
+					return
+				}
+				pairs := make([]KeyValue, size)
+				hasScalarKeys := true
+				for i := 0; i < size; i++ {
+					d.parseType(reflect.ValueOf(&pairs[i].Key))
+					d.parseType(reflect.ValueOf(&pairs[i].Value))
+					switch pairs[i].Key.(type) {
+					case int, string:
+					default:
+						hasScalarKeys = false
+					}
+				}
+				if !hasScalarKeys {
+					val.Set(reflect.ValueOf(pairs))
+					return
+				}
 				hash := make(map[string]interface{}, size)
-				val.Set(reflect.ValueOf(hash))
-				for i := 0; i < int(size); i++ {
-					var key interface{}
-					d.parseType(reflect.ValueOf(&key))
-
-					var value interface{}
-					d.parseType(reflect.ValueOf(&value))
-					switch key := key.(type) {
+				for _, pair := range pairs {
+					switch key := pair.Key.(type) {
 					case int:
 						// note(jae): 2021-06-10
 						// handle map ID keys for "MapInfos.rvdata2"
-						hash[strconv.Itoa(key)] = value
+						hash[strconv.Itoa(key)] = pair.Value
 					case string:
-						hash[key] = value
-					default:
-						panic(newRubyError(fmt.Sprintf("hash: expected string or int type but got %T,", key)))
+						hash[key] = pair.Value
 					}
 				}
+				val.Set(reflect.ValueOf(hash))
 			case reflect.Map:
 				mapType := val.Type()
 				if val.IsNil() {
 					newVal := reflect.MakeMapWithSize(mapType, size)
 					val.Set(newVal)
 				}
-				for i := 0; i < int(size); i++ {
+				for i := 0; i < size; i++ {
 					var keyInterface interface{}
-					key := reflect.ValueOf(&keyInterface)
-					d.parseType(key)
+					d.parseType(reflect.ValueOf(&keyInterface))
 
 					subValue := reflect.New(mapType.Elem())
 					d.parseType(subValue)
 
-					keyUnderlying := key.Elem().Elem()
-					valueUnderlying := subValue.Elem()
-					if valueUnderlying.Kind() == reflect.Ptr {
-						valueUnderlying = valueUnderlying.Elem()
-					}
-					if got := keyUnderlying.Kind(); got != mapType.Key().Kind() {
-						d.saveError(&unexpectedType{
-							Got:      got.String(),
-							Expected: mapType.String(),
-						})
+					key, ok := d.convertMapKey(keyInterface, mapType.Key())
+					if !ok {
 						continue
 					}
-					val.SetMapIndex(keyUnderlying, valueUnderlying)
+					val.SetMapIndex(key, subValue.Elem())
 				}
 			case reflect.Struct:
 				refType := val.Type()
@@ -579,20 +779,32 @@ func (d *Decoder) parseType(val reflect.Value) {
 						d.parseType(reflect.ValueOf(&unusedField))
 					}
 				}
-				if len(unknownFields) > 0 {
+				if len(unknownFields) > 0 && !d.allowUnknownFields {
 					panic(newRubyError(fmt.Sprintf("ruby: unknown object fields %v for struct %s", unknownFields, refType.String())))
 				}
 			case reflect.Slice:
-				d.saveError(&unexpectedType{
-					Got:      val.Kind().String(),
-					Expected: "map[string]interface{} or map[string|int]*customStructHere",
-				})
-				return
+				if val.Type().Elem() != keyValueType {
+					d.saveError(&unexpectedType{
+						Got:      val.Type().String(),
+						Expected: "map[string]interface{}, map[string|int]*customStructHere or []KeyValue",
+					})
+					var emptyInterface interface{}
+					refEmptyInterface := reflect.ValueOf(&emptyInterface)
+					for i := 0; i < size; i++ {
+						d.parseType(refEmptyInterface)
+						d.parseType(refEmptyInterface)
+					}
+					return
+				}
+				pairs := make([]KeyValue, size)
+				for i := 0; i < size; i++ {
+					d.parseType(reflect.ValueOf(&pairs[i].Key))
+					d.parseType(reflect.ValueOf(&pairs[i].Value))
+				}
+				val.Set(reflect.ValueOf(pairs).Convert(val.Type()))
 			default:
 				panic(fmt.Sprintf("hash: unhandled inner type: %s", val.Kind().String()))
 			}
-		case reflect.Map:
-			panic("hash: todo handle explicit map type")
 		default:
 			panic(fmt.Sprintf("hash: unhandled type: %s", val.Kind().String()))
 		}
@@ -601,21 +813,171 @@ func (d *Decoder) parseType(val reflect.Value) {
 		// ie. "Table"
 		className := d.parseSymbolOrSymbolLink()
 		byteCount := d.parseInt()
+		if byteCount < 0 {
+			panic(newRubyError("user defined data has negative length: " + strconv.Itoa(byteCount)))
+		}
 		userDefinedData := d.r.Next(byteCount)
+		if len(userDefinedData) != byteCount {
+			panic(io.ErrUnexpectedEOF)
+		}
 
 		//_ = className
 		//_ = userDefinedData
 
+		if d.preserveObjects && val.Elem().Kind() == reflect.Interface {
+			userDefined := &UserDefined{Class: className, Data: copyBytes(userDefinedData)}
+			d.storeObject(userDefined)
+			val.Elem().Set(reflect.ValueOf(userDefined))
+			return
+		}
 		funcCallback := d.userDefinedLoadMap[className]
 		if funcCallback == nil {
 			panic("Unhandled user defined type: " + className)
 		}
 		funcCallback(userDefinedData, val)
+	case typeUserMarshal: // U
+		// read class name of object dumped with marshal_dump
+		// ie. "Game_Interpreter"
+		className := d.parseSymbolOrSymbolLink()
+		if d.preserveObjects && val.Elem().Kind() == reflect.Interface {
+			userMarshal := &UserMarshal{Class: className}
+			d.storeObject(userMarshal)
+			val.Elem().Set(reflect.ValueOf(userMarshal))
+			d.parseType(reflect.ValueOf(&userMarshal.Data))
+			return
+		}
+		funcCallback := d.userMarshalLoadMap[className]
+		if funcCallback == nil {
+			d.parseType(val)
+			return
+		}
+		var data interface{}
+		d.parseType(reflect.ValueOf(&data))
+		funcCallback(data, val)
+	case typeObjectLink: // @
+		d.parseObjectLink(val)
+	case typeClass, typeModule: // c and m
+		// classes and modules are stored as their name, ie. "RPG::Weapon"
+		data := d.parseBytes()
+		if d.preserveObjects && val.Elem().Kind() == reflect.Interface {
+			val.Elem().Set(reflect.ValueOf(ClassRef{Name: string(data), IsModule: kind == typeModule}))
+			return
+		}
+		d.setString(val, data, EncodingASCII)
 	default:
 		panic(errors.New("unimplemented type: '" + string(kind) + "' (byte: " + strconv.Itoa(int(kind)) + ")"))
 	}
 }
 
+// convertMapKey converts a decoded hash key to the key type of a Go map.
+//
+// Integer keys can be stored in any integer type or as a decimal string,
+// string and symbol keys can be stored in any string type.
+func (d *Decoder) convertMapKey(key interface{}, keyType reflect.Type) (reflect.Value, bool) {
+	keyValue := reflect.New(keyType).Elem()
+	switch key := key.(type) {
+	case int:
+		switch keyType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if keyValue.OverflowInt(int64(key)) {
+				d.saveError(&overflowError{Value: strconv.Itoa(key), Type: keyType.String()})
+				return keyValue, false
+			}
+			keyValue.SetInt(int64(key))
+			return keyValue, true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if key < 0 || keyValue.OverflowUint(uint64(key)) {
+				d.saveError(&overflowError{Value: strconv.Itoa(key), Type: keyType.String()})
+				return keyValue, false
+			}
+			keyValue.SetUint(uint64(key))
+			return keyValue, true
+		case reflect.String:
+			keyValue.SetString(strconv.Itoa(key))
+			return keyValue, true
+		}
+	case string:
+		if keyType.Kind() == reflect.String {
+			keyValue.SetString(key)
+			return keyValue, true
+		}
+	}
+	if key != nil && reflect.TypeOf(key).AssignableTo(keyType) {
+		keyValue.Set(reflect.ValueOf(key))
+		return keyValue, true
+	}
+	d.saveError(&unexpectedType{
+		Got:      fmt.Sprintf("%T", key),
+		Expected: "hash key of type " + keyType.String(),
+	})
+	return keyValue, false
+}
+
+// Ruby 1.9.2 (used by RPG Maker VX Ace) writes the low bits of a float's
+// mantissa after the decimal string. These constants match marshal.c.
+const (
+	decimalMantissa = 53 - 16
+	mantissaBits    = 32
+)
+
+// parseFloat parses a float written by Ruby's w_float
+func parseFloat(str string) (float64, error) {
+	switch str {
+	case "nan":
+		return math.NaN(), nil
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	// sample data: 0.56659999999999999\0<6  <-  where \0 is a null-byte
+	//
+	// The string is written with "%.17g" and the bytes after the null-byte
+	// are the remaining bits of the mantissa, see save_mantissa in:
+	// https://github.com/ruby/ruby/blob/v1_9_2_0/marshal.c
+	var mantissa string
+	if i := strings.IndexByte(str, 0); i != -1 {
+		str, mantissa = str[:i], str[i+1:]
+	}
+	floatingNumber, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+	return loadMantissa(floatingNumber, mantissa), nil
+}
+
+// loadMantissa is a port of load_mantissa from Ruby 1.9.2's marshal.c
+//
+// It replaces the low bits of the mantissa with the binary data written
+// after the decimal string.
+func loadMantissa(d float64, buf string) float64 {
+	if len(buf) == 0 {
+		return d
+	}
+	negative := d < 0
+	frac, exp := math.Frexp(math.Abs(d))
+	d, _ = math.Modf(math.Ldexp(frac, decimalMantissa))
+	dig := 0
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > mantissaBits/8 {
+			n = mantissaBits / 8
+		}
+		var m uint64
+		for i := 0; i < n; i++ {
+			m = m<<8 | uint64(buf[i])
+		}
+		buf = buf[n:]
+		dig += n * 8
+		d += math.Ldexp(float64(m), -dig)
+	}
+	d = math.Ldexp(d, exp-decimalMantissa)
+	if negative {
+		d = -d
+	}
+	return d
+}
+
 func getStructFieldMapFromType(structType reflect.Type) map[string]reflect.StructField {
 	// note(jae): 2021-06-13
 	// if we need to speed this up later we can cache it like encoding/json
@@ -626,6 +988,18 @@ func getStructFieldMapFromType(structType reflect.Type) map[string]reflect.Struc
 		lookupName := structField.Tag.Get("ruby")
 		if lookupName != "" {
 			structLookup[lookupName] = structField
+			continue
+		}
+		if structField.Anonymous && structField.Type.Kind() == reflect.Struct {
+			// fields of embedded structs are promoted unless the outer
+			// struct has a field with the same name
+			for lookupName, embeddedField := range getStructFieldMapFromType(structField.Type) {
+				if _, ok := structLookup[lookupName]; ok {
+					continue
+				}
+				embeddedField.Index = append([]int{i}, embeddedField.Index...)
+				structLookup[lookupName] = embeddedField
+			}
 		}
 	}
 	return structLookup
@@ -642,6 +1016,25 @@ func (d *Decoder) parseSymbolOrSymbolLink() string {
 	}
 }
 
+func (d *Decoder) setSymbol(val reflect.Value, symbol string) {
+	val = val.Elem()
+	if !val.CanSet() {
+		// skip if cannot set
+		return
+	}
+	switch {
+	case val.Kind() == reflect.Interface && d.preserveObjects:
+		val.Set(reflect.ValueOf(Symbol(symbol)))
+	case val.Kind() == reflect.Interface, val.Kind() == reflect.String:
+		val.Set(reflect.ValueOf(symbol).Convert(val.Type()))
+	default:
+		d.saveError(&unexpectedType{
+			Got:      val.Kind().String(),
+			Expected: "string",
+		})
+	}
+}
+
 func (d *Decoder) parseIndexAndLookupSymbol() string {
 	index := d.parseInt()
 	symbol := d.symbols[index]
@@ -650,27 +1043,161 @@ func (d *Decoder) parseIndexAndLookupSymbol() string {
 
 func (d *Decoder) parseSymbol() string {
 	symbol := d.parseString()
-	d.symbols = append(d.symbols, symbol)
+	if d.replaying == 0 {
+		d.symbols = append(d.symbols, symbol)
+	}
 	return symbol
 }
 
 func (d *Decoder) parseString() string {
-	len := d.parseInt()
-	if len == 0 {
+	return string(d.parseBytes())
+}
+
+// parseBytes returns the bytes of a string, the returned slice shares
+// memory with the data being decoded
+func (d *Decoder) parseBytes() []byte {
+	size := d.parseInt()
+	if size == 0 {
 		// note(jae): 2021-06-09
 		// end of "Tilesets.rvdata2" had an empty string
-		return ""
+		return nil
 	}
-	str := make([]byte, len)
-	if _, err := d.r.Read(str); err != nil {
-		panic(err)
+	if size < 0 {
+		panic(newRubyError("string has negative length: " + strconv.Itoa(size)))
+	}
+	data := d.r.Next(size)
+	if len(data) != size {
+		panic(io.ErrUnexpectedEOF)
+	}
+	return data
+}
+
+// parseLength reads the number of elements of an array, hash or object.
+//
+// Every element takes at least one byte, so a length larger than the
+// remaining data is rejected before anything is allocated for it.
+func (d *Decoder) parseLength(kind string) int {
+	size := d.parseInt()
+	if size < 0 {
+		panic(newRubyError(kind + " has negative length: " + strconv.Itoa(size)))
+	}
+	if size > d.r.Len() {
+		panic(io.ErrUnexpectedEOF)
+	}
+	return size
+}
+
+// parseIVarsEncoding reads the instance variables of an IVar type and
+// returns the encoding they specify
+func (d *Decoder) parseIVarsEncoding() string {
+	encoding := EncodingBinary
+	ivarCount := d.parseInt()
+	for i := 0; i < ivarCount; i++ {
+		name := d.parseSymbolOrSymbolLink()
+		var value interface{}
+		d.parseType(reflect.ValueOf(&value))
+		switch name {
+		case "E":
+			// Ruby uses "E" as a short-hand for the two most common encodings
+			if value == true {
+				encoding = EncodingUTF8
+			} else {
+				encoding = EncodingASCII
+			}
+		case "encoding":
+			switch value := value.(type) {
+			case string:
+				encoding = value
+			case *RawString:
+				encoding = string(value.Data)
+			}
+		}
+	}
+	return encoding
+}
+
+func (d *Decoder) setString(val reflect.Value, data []byte, encoding string) {
+	val = val.Elem()
+	if !val.CanSet() {
+		// skip if cannot set
+		return
+	}
+	switch {
+	case val.Type() == stringType:
+		val.Set(reflect.ValueOf(String{
+			Value:    d.transcode(encoding, data),
+			Encoding: encoding,
+		}))
+	case val.Type() == rawStringType:
+		val.Set(reflect.ValueOf(RawString{
+			Data:     copyBytes(data),
+			Encoding: encoding,
+		}))
+	case val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8:
+		val.SetBytes(copyBytes(data))
+	case val.Kind() == reflect.String:
+		val.SetString(d.transcode(encoding, data))
+	case val.Kind() == reflect.Interface && d.preserveObjects:
+		str := &RawString{
+			Data:     copyBytes(data),
+			Encoding: encoding,
+		}
+		d.storeObject(str)
+		val.Set(reflect.ValueOf(str))
+	case val.Kind() == reflect.Interface:
+		val.Set(reflect.ValueOf(d.transcode(encoding, data)))
+	default:
+		d.saveError(&unexpectedType{
+			Got:      val.Kind().String(),
+			Expected: "string or []byte",
+		})
 	}
-	return string(str)
 }
 
+// copyBytes copies data so that it doesn't share memory with the data being
+// decoded. It always returns a non-nil slice so empty strings are []byte{}.
+func copyBytes(data []byte) []byte {
+	return append(make([]byte, 0, len(data)), data...)
+}
+
+func (d *Decoder) setRegexp(val reflect.Value, data []byte, options int, encoding string) {
+	val = val.Elem()
+	if !val.CanSet() {
+		// skip if cannot set
+		return
+	}
+	value := Regexp{
+		Source:   d.transcode(encoding, data),
+		Options:  options,
+		Encoding: encoding,
+	}
+	switch {
+	case val.Type() == regexpType:
+		val.Set(reflect.ValueOf(value))
+	case val.Kind() == reflect.String:
+		val.SetString(value.Source)
+	case val.Kind() == reflect.Interface && d.preserveObjects:
+		d.storeObject(&value)
+		val.Set(reflect.ValueOf(&value))
+	case val.Kind() == reflect.Interface:
+		val.Set(reflect.ValueOf(value))
+	default:
+		d.saveError(&unexpectedType{
+			Got:      val.Kind().String(),
+			Expected: "Regexp, string or interface",
+		})
+	}
+}
+
+var (
+	stringType    = reflect.TypeOf(String{})
+	rawStringType = reflect.TypeOf(RawString{})
+	regexpType    = reflect.TypeOf(Regexp{})
+)
+
 func (d *Decoder) parseInt() int {
 	var result int
-	b, _ := d.r.ReadByte()
+	b := d.readIntByte()
 	c := int(int8(b))
 	if c == 0 {
 		return 0
@@ -683,14 +1210,14 @@ func (d *Decoder) parseInt() int {
 	if cInt8 > 0 {
 		result = 0
 		for i := int8(0); i < cInt8; i++ {
-			n, _ := d.r.ReadByte()
+			n := d.readIntByte()
 			result |= int(uint(n) << (8 * uint(i)))
 		}
 	} else {
 		result = -1
 		c = -c
 		for i := 0; i < c; i++ {
-			n, _ := d.r.ReadByte()
+			n := d.readIntByte()
 			result &= ^(0xff << uint(8*i))
 			result |= int(n) << uint(8*i)
 		}
@@ -698,6 +1225,128 @@ func (d *Decoder) parseInt() int {
 	return result
 }
 
+// readIntByte reads a byte of an integer, which can't be cut off
+func (d *Decoder) readIntByte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		panic(io.ErrUnexpectedEOF)
+	}
+	return b
+}
+
+// parseBignum reads an integer that doesn't fit in a Fixnum
+func (d *Decoder) parseBignum() *big.Int {
+	sign := d.MustReadByte()
+	// length is the number of 16-bit words
+	size := d.parseInt() * 2
+	if size < 0 {
+		panic(newRubyError("bignum has negative length: " + strconv.Itoa(size)))
+	}
+	data := d.r.Next(size)
+	if len(data) != size {
+		panic(io.ErrUnexpectedEOF)
+	}
+	// convert from little-endian to big-endian for big.Int
+	bigEndian := make([]byte, size)
+	for i, b := range data {
+		bigEndian[size-1-i] = b
+	}
+	v := new(big.Int).SetBytes(bigEndian)
+	if sign == '-' {
+		v.Neg(v)
+	}
+	return v
+}
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// setInt stores an integer in any Go integer, unsigned integer or float
+// type if it doesn't overflow
+func (d *Decoder) setInt(val reflect.Value, v int64) {
+	val = val.Elem()
+	if !val.CanSet() {
+		// skip if cannot set
+		return
+	}
+	switch val.Kind() {
+	case reflect.Interface:
+		if int64(int(v)) == v {
+			val.Set(reflect.ValueOf(int(v)))
+		} else {
+			val.Set(reflect.ValueOf(v))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.OverflowInt(v) {
+			d.saveError(&overflowError{Value: strconv.FormatInt(v, 10), Type: val.Type().String()})
+			return
+		}
+		val.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v < 0 || val.OverflowUint(uint64(v)) {
+			d.saveError(&overflowError{Value: strconv.FormatInt(v, 10), Type: val.Type().String()})
+			return
+		}
+		val.SetUint(uint64(v))
+	case reflect.Float32, reflect.Float64:
+		val.SetFloat(float64(v))
+	case reflect.Struct:
+		if val.Type() != bigIntType {
+			d.saveError(&unexpectedType{
+				Got:      val.Type().String(),
+				Expected: "integer, float or big.Int",
+			})
+			return
+		}
+		val.Set(reflect.ValueOf(big.NewInt(v)).Elem())
+	default:
+		d.saveError(&unexpectedType{
+			Got:      val.Kind().String(),
+			Expected: "integer or float",
+		})
+	}
+}
+
+// setBignum is setInt for integers that may not fit in an int64
+func (d *Decoder) setBignum(val reflect.Value, v *big.Int) {
+	if v.IsInt64() {
+		d.setInt(val, v.Int64())
+		return
+	}
+	val = val.Elem()
+	if !val.CanSet() {
+		// skip if cannot set
+		return
+	}
+	switch val.Kind() {
+	case reflect.Interface:
+		val.Set(reflect.ValueOf(v))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !v.IsUint64() || val.OverflowUint(v.Uint64()) {
+			d.saveError(&overflowError{Value: v.String(), Type: val.Type().String()})
+			return
+		}
+		val.SetUint(v.Uint64())
+	case reflect.Float32, reflect.Float64:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		if val.OverflowFloat(f) {
+			d.saveError(&overflowError{Value: v.String(), Type: val.Type().String()})
+			return
+		}
+		val.SetFloat(f)
+	case reflect.Struct:
+		if val.Type() != bigIntType {
+			d.saveError(&unexpectedType{
+				Got:      val.Type().String(),
+				Expected: "integer, float or big.Int",
+			})
+			return
+		}
+		val.Set(reflect.ValueOf(v).Elem())
+	default:
+		d.saveError(&overflowError{Value: v.String(), Type: val.Type().String()})
+	}
+}
+
 // saveError saves the first err it is called with,
 // for reporting at the end of the unmarshal.
 func (d *Decoder) saveError(err error) {
@@ -1,7 +1,13 @@
 package rubymarshal
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
 	"testing"
 )
 
@@ -61,3 +67,625 @@ func TestString(t *testing.T) {
 		}
 	})
 }
+
+func TestAllowUnknownFields(t *testing.T) {
+	// Thing object with a single field "@a" set to 1
+	b, err := hex.DecodeString(rubyMarshalHeader + "6F3A0A5468696E67063A0740616906")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		B int `ruby:"@b"`
+	}
+	d := NewDecoder(b)
+	d.AllowUnknownFields()
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected float64
+	}{
+		{"1", 1},
+		{"-0.5", -0.5},
+		{"inf", math.Inf(1)},
+		{"-inf", math.Inf(-1)},
+		// written by RPG Maker VX Ace with the low bits of the mantissa after the null-byte
+		{"0.56659999999999999\x00<6", math.Float64frombits(0x3fe2219652bd3c36)},
+		{"-0.56659999999999999\x00<6", -math.Float64frombits(0x3fe2219652bd3c36)},
+	}
+	for _, test := range tests {
+		input := []byte{0x04, 0x08, typeFloat, byte(len(test.Input) + 5)}
+		input = append(input, test.Input...)
+		var v float64
+		d := NewDecoder(input)
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != test.Expected {
+			t.Errorf("expected %q to decode as %v but got %v", test.Input, test.Expected, v)
+		}
+	}
+
+	// NaN can't be compared with ==
+	var v float64
+	d := NewDecoder([]byte("\x04\x08f\x08nan"))
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(v) {
+		t.Fatalf("expected NaN but got %v", v)
+	}
+}
+
+func TestStringEncoding(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    string
+		Expected String
+	}{
+		{
+			Name:     "binary",
+			Input:    "\"\x08\xff\x00\x01",
+			Expected: String{Value: "\xff\x00\x01", Encoding: EncodingBinary},
+		},
+		{
+			Name:     "utf-8",
+			Input:    "I\"\x0b\xe3\x81\x82\xe3\x81\x84\x06:\x06ET",
+			Expected: String{Value: "あい", Encoding: EncodingUTF8},
+		},
+		{
+			Name:     "us-ascii",
+			Input:    "I\"\x07hi\x06:\x06EF",
+			Expected: String{Value: "hi", Encoding: EncodingASCII},
+		},
+		{
+			Name:     "shift_jis without transcoder",
+			Input:    "I\"\x09\x82\xa0\x82\xa2\x06:\x0dencoding\"\x0eShift_JIS",
+			Expected: String{Value: "\x82\xa0\x82\xa2", Encoding: "Shift_JIS"},
+		},
+		{
+			Name:     "windows-1252",
+			Input:    "I\"\x08\x80 \xe9\x06:\x0dencoding\"\x11Windows-1252",
+			Expected: String{Value: "€ é", Encoding: "Windows-1252"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var v String
+			d := NewDecoder([]byte("\x04\x08" + test.Input))
+			if err := d.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			if v != test.Expected {
+				t.Fatalf("expected %+q but got %+q", test.Expected, v)
+			}
+		})
+	}
+
+	t.Run("transcoder", func(t *testing.T) {
+		var v []string
+		d := NewDecoder([]byte("\x04\x08[\x07I\"\x09\x82\xa0\x82\xa2\x06:\x0dencoding\"\x0eShift_JISI\"\x07hi\x06:\x06EF"))
+		d.SetTranscoder(func(encoding string, data []byte) (string, error) {
+			if encoding != "Shift_JIS" {
+				t.Fatalf("unexpected encoding: %s", encoding)
+			}
+			return "あい", nil
+		})
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v[0] != "あい" || v[1] != "hi" {
+			t.Fatalf("unexpected strings: %q", v)
+		}
+	})
+}
+
+func TestIVar(t *testing.T) {
+	// [/a+/i] with an encoding, followed by a symbol and array with instance variables
+	b := []byte("\x04\x08[\x08I/\x07a+\x01\x06:\x06EF" +
+		"I:\x08abc\x06;\x00T" +
+		"I[\x06i\x06\x06:\x07@xi\x07")
+	var v []interface{}
+	d := NewDecoder(b)
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if re, ok := v[0].(Regexp); !ok || re != (Regexp{Source: "a+", Options: 1, Encoding: EncodingASCII}) {
+		t.Fatalf("unexpected regexp: %#v", v[0])
+	}
+	if v[1] != "abc" {
+		t.Fatalf("unexpected symbol: %#v", v[1])
+	}
+	if arr, ok := v[2].([]interface{}); !ok || len(arr) != 1 || arr[0] != 1 {
+		t.Fatalf("unexpected array: %#v", v[2])
+	}
+}
+
+func TestRawString(t *testing.T) {
+	// ["\x78\x9c\x00", "" (UTF-8)]
+	b := []byte("\x04\x08[\x07\"\x08\x78\x9c\x00I\"\x00\x06:\x06ET")
+
+	var raw []RawString
+	d := NewDecoder(b)
+	if err := d.Decode(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 2 ||
+		!bytes.Equal(raw[0].Data, []byte{0x78, 0x9c, 0x00}) || raw[0].Encoding != EncodingBinary ||
+		raw[1].Data == nil || len(raw[1].Data) != 0 || raw[1].Encoding != EncodingUTF8 {
+		t.Fatalf("unexpected raw strings: %#v", raw)
+	}
+
+	var byteSlices [][]byte
+	d = NewDecoder(b)
+	if err := d.Decode(&byteSlices); err != nil {
+		t.Fatal(err)
+	}
+	if len(byteSlices) != 2 || !bytes.Equal(byteSlices[0], []byte{0x78, 0x9c, 0x00}) {
+		t.Fatalf("unexpected byte slices: %#v", byteSlices)
+	}
+
+	// ensure data is copied rather than sharing memory with the input
+	byteSlices[0][0] = 0
+	if b[6] != 0x78 {
+		t.Fatal("expected decoded []byte to not share memory with the input")
+	}
+}
+
+func TestNumericTypes(t *testing.T) {
+	// [300, -1, 2**64, 1.5, nil]
+	b := []byte("\x04\x08[\x0ai\x02\x2c\x01i\xfal+\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00f\x081.50")
+
+	var v []interface{}
+	d := NewDecoder(b)
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	expectedBig, _ := new(big.Int).SetString("18446744073709551616", 10)
+	if len(v) != 5 || v[0] != 300 || v[1] != -1 || v[3] != 1.5 || v[4] != nil {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+	if bigValue, ok := v[2].(*big.Int); !ok || bigValue.Cmp(expectedBig) != 0 {
+		t.Fatalf("unexpected bignum: %#v", v[2])
+	}
+
+	t.Run("typed", func(t *testing.T) {
+		tests := []struct {
+			Name     string
+			Input    string
+			Value    interface{}
+			Expected interface{}
+		}{
+			{"int16", "i\x02\x2c\x01", new(int16), int16(300)},
+			{"uint8", "i\x02\xff\x00", new(uint8), uint8(255)},
+			{"float32", "i\xfa", new(float32), float32(-1)},
+			{"float32 from float", "f\x081.5", new(float32), float32(1.5)},
+			{"int64 from bignum", "l-\x08\x00\x00\x00\x00\x01\x00", new(int64), int64(-(1 << 32))},
+			{"uint64 from bignum", "l+\x09\xff\xff\xff\xff\xff\xff\xff\xff", new(uint64), uint64(math.MaxUint64)},
+			{"nil bool", "0", new(bool), false},
+			{"nil slice", "0", &[]int{1}, []int(nil)},
+		}
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				d := NewDecoder([]byte("\x04\x08" + test.Input))
+				if err := d.Decode(test.Value); err != nil {
+					t.Fatal(err)
+				}
+				if got := reflect.ValueOf(test.Value).Elem().Interface(); !reflect.DeepEqual(got, test.Expected) {
+					t.Fatalf("expected %#v but got %#v", test.Expected, got)
+				}
+			})
+		}
+	})
+
+	t.Run("big.Int", func(t *testing.T) {
+		var v big.Int
+		d := NewDecoder([]byte("\x04\x08l+\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00"))
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Cmp(expectedBig) != 0 {
+			t.Fatalf("unexpected big.Int: %s", v.String())
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		tests := []struct {
+			Name  string
+			Input string
+			Value interface{}
+		}{
+			{"int8", "i\x02\x2c\x01", new(int8)},
+			{"negative uint", "i\xfa", new(uint)},
+			{"bignum int64", "l+\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00", new(int64)},
+		}
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				d := NewDecoder([]byte("\x04\x08" + test.Input))
+				err := d.Decode(test.Value)
+				var overflowErr *overflowError
+				if !errors.As(err, &overflowErr) {
+					t.Fatalf("expected overflow error but got %v", err)
+				}
+			})
+		}
+	})
+}
+
+func TestArray(t *testing.T) {
+	// [1, 2, 3]
+	b := []byte("\x04\x08[\x08i\x06i\x07i\x08")
+	t.Run("exact size", func(t *testing.T) {
+		var v [3]int
+		if err := NewDecoder(b).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != [3]int{1, 2, 3} {
+			t.Fatalf("unexpected array: %v", v)
+		}
+	})
+	t.Run("smaller", func(t *testing.T) {
+		var v [2]int
+		if err := NewDecoder(b).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != [2]int{1, 2} {
+			t.Fatalf("unexpected array: %v", v)
+		}
+	})
+	t.Run("larger", func(t *testing.T) {
+		v := [4]int{9, 9, 9, 9}
+		if err := NewDecoder(b).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != [4]int{1, 2, 3, 0} {
+			t.Fatalf("unexpected array: %v", v)
+		}
+	})
+	t.Run("pointers", func(t *testing.T) {
+		var v []*int
+		if err := NewDecoder([]byte("\x04\x08[\x07i\x060")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if len(v) != 2 || v[0] == nil || *v[0] != 1 || v[1] != nil {
+			t.Fatalf("unexpected pointers: %v", v)
+		}
+	})
+}
+
+func TestHash(t *testing.T) {
+	type Item struct {
+		Name string `ruby:"@name"`
+	}
+	// {1 => Item(@name: "a"), 2 => nil}
+	itemHash := []byte("\x04\x08{\x07i\x06o:\x09Item\x06:\x0a@name\"\x06ai\x070")
+	t.Run("int keys and pointer values", func(t *testing.T) {
+		var v map[int]*Item
+		if err := NewDecoder(itemHash).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if len(v) != 2 || v[1] == nil || v[1].Name != "a" || v[2] != nil {
+			t.Fatalf("unexpected map: %#v", v)
+		}
+	})
+	t.Run("int keys as strings", func(t *testing.T) {
+		var v map[string]Item
+		if err := NewDecoder(itemHash).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if len(v) != 2 || v["1"].Name != "a" {
+			t.Fatalf("unexpected map: %#v", v)
+		}
+	})
+	t.Run("symbol and string keys", func(t *testing.T) {
+		// {:a => 1, "b" => 2}
+		var v map[string]uint8
+		if err := NewDecoder([]byte("\x04\x08{\x07:\x06ai\x06\"\x06bi\x07")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if len(v) != 2 || v["a"] != 1 || v["b"] != 2 {
+			t.Fatalf("unexpected map: %#v", v)
+		}
+	})
+	t.Run("mismatched keys", func(t *testing.T) {
+		var v map[int]int
+		if err := NewDecoder([]byte("\x04\x08{\x06:\x06ai\x06")).Decode(&v); err == nil {
+			t.Fatal("expected error for symbol key in map[int]int")
+		}
+	})
+
+	// self switches: {[1, 2, "A"] => true}
+	selfSwitches := []byte("\x04\x08{\x06[\x08i\x06i\x07\"\x06AT")
+	t.Run("key values", func(t *testing.T) {
+		var v []KeyValue
+		if err := NewDecoder(selfSwitches).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		expected := []KeyValue{{Key: []interface{}{1, 2, "A"}, Value: true}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("expected %#v but got %#v", expected, v)
+		}
+	})
+	t.Run("key values in interface", func(t *testing.T) {
+		var v interface{}
+		if err := NewDecoder(selfSwitches).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if pairs, ok := v.([]KeyValue); !ok || len(pairs) != 1 || pairs[0].Value != true {
+			t.Fatalf("unexpected value: %#v", v)
+		}
+	})
+}
+
+func TestObjectLink(t *testing.T) {
+	t.Run("strings and floats", func(t *testing.T) {
+		// s = "a"; f = 1.5; [s, s, f, f]
+		b := []byte("\x04\x08[\x09I\"\x06a\x06:\x06ET@\x06f\x081.5@\x07")
+		var v []interface{}
+		if err := NewDecoder(b).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		expected := []interface{}{"a", "a", 1.5, 1.5}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("expected %#v but got %#v", expected, v)
+		}
+	})
+	t.Run("objects", func(t *testing.T) {
+		type Item struct {
+			Name string `ruby:"@name"`
+		}
+		// o = Item.new("a"); [o, o, :@name]
+		b := []byte("\x04\x08[\x08o:\x09Item\x06:\x0a@name\"\x06a@\x06;\x06")
+		var v []interface{}
+		if err := NewDecoder(b).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		// the link shouldn't register the symbols again
+		if len(v) != 3 || v[2] != "@name" {
+			t.Fatalf("unexpected values: %#v", v)
+		}
+		if !reflect.DeepEqual(v[0], v[1]) {
+			t.Fatalf("expected linked object to match but got %#v and %#v", v[0], v[1])
+		}
+
+		var items []*Item
+		b[3] = '\x07'
+		if err := NewDecoder(b[:len(b)-2]).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 2 || items[0].Name != "a" || items[1].Name != "a" {
+			t.Fatalf("unexpected items: %#v", items)
+		}
+	})
+	t.Run("recursive", func(t *testing.T) {
+		// a = []; a << a
+		var v interface{}
+		if err := NewDecoder([]byte("\x04\x08[\x06@\x00")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, []interface{}{nil}) {
+			t.Fatalf("unexpected value: %#v", v)
+		}
+	})
+	t.Run("recursive linked twice", func(t *testing.T) {
+		// a = []; a << a; [a, a]
+		var v interface{}
+		if err := NewDecoder([]byte("\x04\x08[\x07[\x06@\x06@\x06")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		expected := []interface{}{[]interface{}{nil}, []interface{}{nil}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("expected %#v but got %#v", expected, v)
+		}
+	})
+}
+
+func TestUserMarshal(t *testing.T) {
+	// object of class Foo with marshal_dump returning [1, 2]
+	b := []byte("\x04\x08U:\x08Foo[\x07i\x06i\x07")
+	t.Run("without callback", func(t *testing.T) {
+		var v []int
+		if err := NewDecoder(b).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, []int{1, 2}) {
+			t.Fatalf("unexpected value: %#v", v)
+		}
+	})
+	t.Run("callback", func(t *testing.T) {
+		var v int
+		d := NewDecoder(b)
+		d.AddUserMarshalLoad("Foo", func(data interface{}, val reflect.Value) {
+			arr := data.([]interface{})
+			val.Elem().SetInt(int64(arr[0].(int) + arr[1].(int)))
+		})
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != 3 {
+			t.Fatalf("unexpected value: %d", v)
+		}
+	})
+}
+
+func TestDecodeMisc(t *testing.T) {
+	t.Run("class", func(t *testing.T) {
+		var v string
+		if err := NewDecoder([]byte("\x04\x08c\x10RPG::Weapon")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != "RPG::Weapon" {
+			t.Fatalf("unexpected class: %q", v)
+		}
+	})
+	t.Run("hash with default", func(t *testing.T) {
+		// h = Hash.new(0); h[:a] = 1
+		var v map[string]int
+		if err := NewDecoder([]byte("\x04\x08}\x06:\x06ai\x06i\x00")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if len(v) != 1 || v["a"] != 1 {
+			t.Fatalf("unexpected hash: %#v", v)
+		}
+	})
+	t.Run("embedded struct", func(t *testing.T) {
+		type Inner struct {
+			A int `ruby:"@a"`
+		}
+		type Outer struct {
+			Inner
+			B int `ruby:"@b"`
+		}
+		var v Outer
+		if err := NewDecoder([]byte("\x04\x08o:\x0aOuter\x07:\x07@ai\x06:\x07@bi\x07")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.A != 1 || v.B != 2 {
+			t.Fatalf("unexpected struct: %#v", v)
+		}
+	})
+	t.Run("pointer to pointer", func(t *testing.T) {
+		var v *int
+		if err := NewDecoder([]byte("\x04\x08i\x06")).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v == nil || *v != 1 {
+			t.Fatalf("unexpected value: %v", v)
+		}
+	})
+}
+
+func TestMultipleDocuments(t *testing.T) {
+	// Marshal.dump(1) + Marshal.dump(:a) + Marshal.dump([:a, :a])
+	// symbol links in the last document don't refer to the symbol in the second
+	d := NewDecoder([]byte("\x04\x08i\x06\x04\x08:\x06a\x04\x08[\x07:\x06b;\x00"))
+	var number int
+	if err := d.Decode(&number); err != nil || number != 1 {
+		t.Fatalf("unexpected first document: %v, %v", number, err)
+	}
+	if !d.More() {
+		t.Fatal("expected more documents")
+	}
+	var symbol string
+	if err := d.Decode(&symbol); err != nil || symbol != "a" {
+		t.Fatalf("unexpected second document: %v, %v", symbol, err)
+	}
+	var symbols []string
+	if err := d.Decode(&symbols); err != nil || !reflect.DeepEqual(symbols, []string{"b", "b"}) {
+		t.Fatalf("unexpected third document: %v, %v", symbols, err)
+	}
+	if d.More() {
+		t.Fatal("expected no more documents")
+	}
+	if err := d.Decode(&number); err != io.EOF {
+		t.Fatalf("expected io.EOF but got %v", err)
+	}
+}
+
+func TestMultipleDocumentsAfterError(t *testing.T) {
+	// Marshal.dump("a") + Marshal.dump(1)
+	// the type error in the first document doesn't affect the second
+	d := NewDecoder([]byte("\x04\x08\"\x06a\x04\x08i\x06"))
+	var number int
+	if err := d.Decode(&number); err == nil {
+		t.Fatal("expected error decoding a string into an int")
+	}
+	if err := d.Decode(&number); err != nil || number != 1 {
+		t.Fatalf("unexpected second document: %v, %v", number, err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	bignum, _ := new(big.Int).SetString("-1073741825", 10)
+	for _, tc := range []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"zero", 0, "i\x00"},
+		{"small", 122, "i\x7f"},
+		{"one byte", 123, "i\x01\x7b"},
+		{"negative", -124, "i\xff\x84"},
+		{"max fixnum", 1<<30 - 1, "i\x04\xff\xff\xff\x3f"},
+		{"bignum", 1 << 30, "l+\x07\x00\x00\x00\x40"},
+		{"negative bignum", bignum, "l-\x07\x01\x00\x00\x40"},
+		{"nil bool", []interface{}{nil, true, false}, "[\x080TF"},
+		{"string", "abc", "I\"\x08abc\x06:\x06ET"},
+		{"binary string", []byte("abc"), "\"\x08abc"},
+		{"symbol links", []Symbol{"a", "b", "a"}, "[\x08:\x06a:\x06b;\x00"},
+		{"float", 1.5, "f\x081.5"},
+		{"sorted map", map[int]int{2: 3, 1: 4}, "{\x07i\x06i\x09i\x07i\x08"},
+		{"object", &Object{Class: "A", Fields: []Field{{"@a", 1}}}, "o:\x06A\x06:\x07@ai\x06"},
+		{"class links", []interface{}{ClassRef{Name: "A"}, ClassRef{Name: "B"}, ClassRef{Name: "A"}}, "[\x08c\x06Ac\x06B@\x06"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(tc.value); err != nil {
+				t.Fatal(err)
+			}
+			if expected := "\x04\x08" + tc.expected; buf.String() != expected {
+				t.Fatalf("expected %q but got %q", expected, buf.String())
+			}
+		})
+	}
+
+	// floats are written with their mantissa like Ruby 1.9.2 and decode to the same value
+	for _, f := range []float64{0.5666, 1.0 / 3, -2.75e-10, math.MaxFloat64, math.Inf(-1)} {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(f); err != nil {
+			t.Fatal(err)
+		}
+		var decoded float64
+		if err := NewDecoder(buf.Bytes()).Decode(&decoded); err != nil || decoded != f {
+			t.Fatalf("expected %v but got %v, %v", f, decoded, err)
+		}
+	}
+
+	// an array that contains itself is written with a link to itself
+	input := []byte("\x04\x08[\x06@\x00")
+	d := NewDecoder(input)
+	d.PreserveObjects()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	arr := v.(*Array)
+	if arr.Elements[0] != arr {
+		t.Fatalf("expected array to contain itself but got %#v", arr.Elements[0])
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), input) {
+		t.Fatalf("expected %q but got %q", input, buf.Bytes())
+	}
+
+	if err := NewEncoder(&buf).Encode(struct{}{}); err == nil {
+		t.Fatal("expected error encoding an unsupported struct")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, data := range []string{
+		"\x04\x08i",
+		"\x04\x08i\x02\x01",
+		"\x04\x08{\x06:\x0bfoo",
+		"\x04\x08[\x07i\x06",
+		"\x04\x08u:\x0aTable\x0a\x01",
+		// lengths larger than the data must fail before allocating
+		"\x04\x08{\x04\xff\xff\xff\x0f",
+		"\x04\x08[\x04\xff\xff\xff\x0f",
+		"\x04\x08o:\x06A\x04\xff\xff\xff\x0f",
+		"\x04\x08[\xfa",
+	} {
+		var v interface{}
+		if err := NewDecoder([]byte(data)).Decode(&v); err == nil {
+			t.Fatalf("expected error decoding %q", data)
+		}
+	}
+}
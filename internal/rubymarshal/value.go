@@ -0,0 +1,141 @@
+package rubymarshal
+
+import (
+	"bytes"
+	"math/big"
+)
+
+// The types in this file are decoded into interface{} values when
+// PreserveObjects is enabled. They keep everything needed to encode the data
+// again as Ruby wrote it, including class names, the order of instance
+// variables and which objects are shared.
+
+// Symbol is a Ruby symbol, it's encoded as a symbol rather than a string
+type Symbol string
+
+// Object is a Ruby object decoded without a Go struct
+type Object struct {
+	// Class is the name of the class, ie. "Game_Party"
+	Class  string
+	Fields []Field
+}
+
+// Field is an instance variable of an object, the name includes the "@" prefix
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Get returns the value of the instance variable, ie. "@gold"
+func (obj *Object) Get(name string) (interface{}, bool) {
+	for _, field := range obj.Fields {
+		if field.Name == name {
+			return field.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set sets the value of the instance variable, adding it if it doesn't exist
+func (obj *Object) Set(name string, value interface{}) {
+	for i := range obj.Fields {
+		if obj.Fields[i].Name == name {
+			obj.Fields[i].Value = value
+			return
+		}
+	}
+	obj.Fields = append(obj.Fields, Field{Name: name, Value: value})
+}
+
+// Array is a Ruby array
+type Array struct {
+	Elements []interface{}
+}
+
+// Hash is a Ruby hash that keeps the order of its keys
+type Hash struct {
+	Pairs []KeyValue
+	// Default is the value returned for missing keys, ie. Hash.new(0)
+	Default    interface{}
+	HasDefault bool
+}
+
+// Get returns the value for the key, see Set for how keys are compared
+func (hash *Hash) Get(key interface{}) (interface{}, bool) {
+	if i := hash.index(key); i != -1 {
+		return hash.Pairs[i].Value, true
+	}
+	return nil, false
+}
+
+// Set sets the value for the key, adding it to the end if it doesn't exist.
+//
+// Keys are compared by value like Ruby's Hash, so an *Array or *RawString key
+// matches another with the same contents.
+func (hash *Hash) Set(key interface{}, value interface{}) {
+	if i := hash.index(key); i != -1 {
+		hash.Pairs[i].Value = value
+		return
+	}
+	hash.Pairs = append(hash.Pairs, KeyValue{Key: key, Value: value})
+}
+
+// Delete removes the key from the hash
+func (hash *Hash) Delete(key interface{}) {
+	if i := hash.index(key); i != -1 {
+		hash.Pairs = append(hash.Pairs[:i], hash.Pairs[i+1:]...)
+	}
+}
+
+func (hash *Hash) index(key interface{}) int {
+	for i, pair := range hash.Pairs {
+		if keysEqual(pair.Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// keysEqual compares hash keys by value
+func keysEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !keysEqual(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *RawString:
+		b, ok := b.(*RawString)
+		return ok && bytes.Equal(a.Data, b.Data)
+	case *big.Int:
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	case float64, int, bool, nil, Symbol, ClassRef:
+		return a == b
+	}
+	return false
+}
+
+// UserDefined is an object that was dumped with _dump, ie. an RGSS Table
+type UserDefined struct {
+	Class string
+	Data  []byte
+}
+
+// UserMarshal is an object that was dumped with marshal_dump
+type UserMarshal struct {
+	Class string
+	Data  interface{}
+}
+
+// ClassRef is a reference to a Ruby class or module, ie. RPG::Weapon
+type ClassRef struct {
+	Name     string
+	IsModule bool
+}
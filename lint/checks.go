@@ -0,0 +1,233 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/silbinarywolf/rmvx"
+)
+
+// Event command codes used by the checks
+const (
+	commandConditionalBranch = 111
+	commandChangeItems       = 126
+	commandChangeWeapons     = 127
+	commandChangeArmors      = 128
+	commandTransferPlayer    = 201
+	commandShopProcessing    = 302
+	commandShopItem          = 605
+)
+
+// Kinds of goods in Shop Processing, which match Conditional Branch 8, 9 and 10
+const (
+	goodsItem = iota
+	goodsWeapon
+	goodsArmor
+)
+
+type linter struct {
+	data     *projectData
+	options  Options
+	disabled map[string]bool
+	findings []Finding
+	assets   *rmvx.AssetResolver
+}
+
+func run(data *projectData, options Options) []Finding {
+	linter := &linter{
+		data:     data,
+		options:  options,
+		disabled: make(map[string]bool),
+		assets:   rmvx.NewAssetResolver(data.fsys, options.RTP),
+	}
+	for _, check := range options.Disabled {
+		linter.disabled[check] = true
+	}
+	index := rmvx.NewCrossReference()
+	for _, mapID := range data.mapIDs {
+		m := data.maps[mapID]
+		index.AddMap(mapID, m)
+		linter.checkMap(mapID, m)
+	}
+	index.AddCommonEvents(data.commonEvents)
+	for _, commonEvent := range data.commonEvents {
+		if commonEvent.ID == 0 {
+			continue
+		}
+		linter.checkCommands(commonEvent.List, Location{CommonEventID: commonEvent.ID})
+	}
+	index.AddTroops(data.troops)
+	for _, troop := range data.troops {
+		for i, page := range troop.Pages {
+			linter.checkCommands(page.List, Location{TroopID: troop.ID, Page: i})
+		}
+	}
+	linter.checkUnreadSwitches(index)
+	return linter.findings
+}
+
+func (linter *linter) report(check string, severity Severity, location Location, format string, args ...interface{}) {
+	if linter.disabled[check] {
+		return
+	}
+	linter.findings = append(linter.findings, Finding{
+		Check:    check,
+		Severity: severity,
+		Location: location,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (linter *linter) checkMap(mapID int, m *rmvx.Map) {
+	if len(m.EncounterList) > 0 && m.EncounterStep == 0 {
+		linter.report(CheckEncounterStep, SeverityWarning, Location{MapID: mapID, Command: -1},
+			"map has %d encounters but encounter steps is 0", len(m.EncounterList))
+	}
+	eventIDs := make([]int, 0, len(m.Events))
+	for eventID := range m.Events {
+		eventIDs = append(eventIDs, eventID)
+	}
+	sort.Ints(eventIDs)
+	for _, eventID := range eventIDs {
+		event := m.Events[eventID]
+		for i := range event.Pages {
+			page := &event.Pages[i]
+			location := Location{MapID: mapID, EventID: eventID, Page: i, Command: -1}
+			if name := page.Graphic.CharacterName; name != "" && !linter.hasCharacter(name) {
+				linter.report(CheckMissingCharacter, SeverityError, location, "character graphic %q not found", name)
+			}
+			if page.Condition.ItemValid {
+				linter.checkItem(goodsItem, page.Condition.ItemID, location)
+			}
+			if isEmptyPage(page) && !(i == 0 && len(event.Pages) > 1) && !hasCondition(&page.Condition) {
+				linter.report(CheckEmptyPage, SeverityWarning, location, "page has no commands or graphic")
+			}
+			linter.checkCommands(page.List, location)
+		}
+	}
+}
+
+// isEmptyPage reports whether the page has no commands other than the end
+// of the list and no graphic
+func isEmptyPage(page *rmvx.MapEventPage) bool {
+	for _, command := range page.List {
+		if command.Code != 0 {
+			return false
+		}
+	}
+	return page.Graphic.CharacterName == "" && page.Graphic.Tile == 0
+}
+
+// hasCondition reports whether any condition is set, empty pages with
+// conditions are used to hide an event, ie. after a self switch is turned on
+func hasCondition(condition *rmvx.MapPageCondition) bool {
+	return condition.Switch1Valid || condition.Switch2Valid || condition.VariableValid ||
+		condition.SelfSwitchValid || condition.ItemValid || condition.ActorValid
+}
+
+func (linter *linter) checkCommands(list []rmvx.EventCommand, location Location) {
+	for i, command := range list {
+		location.Command = i
+		params := command.Parameters
+		switch command.Code {
+		case commandTransferPlayer:
+			// transfers using variables can't be checked
+			if paramInt(params, 0) == 0 {
+				linter.checkTransfer(paramInt(params, 1), paramInt(params, 2), paramInt(params, 3), location)
+			}
+		case commandChangeItems:
+			linter.checkItem(goodsItem, paramInt(params, 0), location)
+		case commandChangeWeapons:
+			linter.checkItem(goodsWeapon, paramInt(params, 0), location)
+		case commandChangeArmors:
+			linter.checkItem(goodsArmor, paramInt(params, 0), location)
+		case commandConditionalBranch:
+			switch conditionType := paramInt(params, 0); conditionType {
+			case 8, 9, 10:
+				linter.checkItem(conditionType-8, paramInt(params, 1), location)
+			}
+		case commandShopProcessing, commandShopItem:
+			linter.checkItem(paramInt(params, 0), paramInt(params, 1), location)
+		}
+	}
+}
+
+func (linter *linter) checkTransfer(mapID, x, y int, location Location) {
+	m, ok := linter.data.maps[mapID]
+	if !ok {
+		linter.report(CheckTransferMissingMap, SeverityError, location, "transfer to missing map %d", mapID)
+		return
+	}
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+		linter.report(CheckTransferOutOfBounds, SeverityError, location,
+			"transfer to (%d, %d) is outside of map %d which is %dx%d", x, y, mapID, m.Width, m.Height)
+	}
+}
+
+// checkItem reports an ID that isn't in the database, databases that weren't
+// loaded are skipped
+func (linter *linter) checkItem(kind int, id int, location Location) {
+	data := linter.data
+	var name string
+	var count int
+	var exists bool
+	switch kind {
+	case goodsItem:
+		name, count = "item", len(data.items)
+		exists = id > 0 && id < count && data.items[id].ID == id
+	case goodsWeapon:
+		name, count = "weapon", len(data.weapons)
+		exists = id > 0 && id < count && data.weapons[id].ID == id
+	case goodsArmor:
+		name, count = "armor", len(data.armors)
+		exists = id > 0 && id < count && data.armors[id].ID == id
+	default:
+		return
+	}
+	if count == 0 || exists {
+		return
+	}
+	linter.report(CheckMissingItem, SeverityError, location, "%s %d does not exist", name, id)
+}
+
+// hasCharacter reports whether the character graphic is in the project or the RTP
+func (linter *linter) hasCharacter(name string) bool {
+	_, err := linter.assets.Resolve(rmvx.AssetCharacters, name)
+	return err == nil
+}
+
+func (linter *linter) checkUnreadSwitches(index *rmvx.CrossReference) {
+	switchIDs := make([]int, 0, len(index.Switches))
+	for switchID := range index.Switches {
+		switchIDs = append(switchIDs, switchID)
+	}
+	sort.Ints(switchIDs)
+	for _, switchID := range switchIDs {
+		references := index.Switch(switchID)
+		writes := references.Writes()
+		if len(writes) == 0 || len(references.Reads()) > 0 {
+			continue
+		}
+		name := ""
+		if system := linter.data.system; system != nil && switchID < len(system.Switches) {
+			name = system.Switches[switchID]
+		}
+		write := writes[0]
+		linter.report(CheckUnreadSwitch, SeverityWarning, Location{
+			MapID:         write.MapID,
+			EventID:       write.EventID,
+			CommonEventID: write.CommonEventID,
+			TroopID:       write.TroopID,
+			Page:          write.Page,
+			Command:       write.Command,
+		}, "switch %04d %q is set %d times but never read", switchID, name, len(writes))
+	}
+}
+
+func paramInt(params []interface{}, i int) int {
+	if i < 0 || i >= len(params) {
+		return 0
+	}
+	value, _ := params[i].(int)
+	return value
+}
@@ -0,0 +1,174 @@
+// Package lint checks an RPG Maker VX Ace project for common data mistakes,
+// such as transfers to maps that don't exist.
+package lint
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/silbinarywolf/rmvx"
+)
+
+// Names of the checks run by Run
+const (
+	// CheckTransferMissingMap is a Transfer Player command to a map that has no map file
+	CheckTransferMissingMap = "transfer-missing-map"
+	// CheckTransferOutOfBounds is a Transfer Player command to a position outside of the map
+	CheckTransferOutOfBounds = "transfer-out-of-bounds"
+	// CheckUnreadSwitch is a switch that is turned on or off but never read by
+	// events. Switches only read by scripts are reported too.
+	CheckUnreadSwitch = "unread-switch"
+	// CheckMissingCharacter is an event page using a character graphic that
+	// isn't in the project or the RTP
+	CheckMissingCharacter = "missing-character"
+	// CheckMissingItem is an item, weapon or armor ID that isn't in the database
+	CheckMissingItem = "missing-item"
+	// CheckEncounterStep is a map with encounters and encounter steps set to
+	// 0, which causes an encounter every few steps
+	CheckEncounterStep = "encounter-step"
+	// CheckEmptyPage is an event page without commands or a graphic that
+	// isn't used to hide the event
+	CheckEmptyPage = "empty-page"
+)
+
+// Severity is how likely a finding is to be a bug
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(severity))
+}
+
+// Location is where a finding is in the project
+type Location struct {
+	// MapID and EventID are set for maps and map events
+	MapID         int
+	EventID       int
+	CommonEventID int
+	TroopID       int
+	// Page is the index of the event or troop page, starting from 0
+	Page int
+	// Command is the index of the command in the page's list or -1 if the
+	// finding isn't about a command
+	Command int
+}
+
+// String returns the location with page and line numbers starting from 1
+// like the editor, ie. "Data/Map001.rvdata2: event 2, page 1, line 4"
+func (location Location) String() string {
+	var parts []string
+	switch {
+	case location.MapID != 0:
+		if location.EventID == 0 {
+			return rmvx.MapFilename(location.MapID)
+		}
+		parts = append(parts, fmt.Sprintf("%s: event %d, page %d", rmvx.MapFilename(location.MapID), location.EventID, location.Page+1))
+	case location.CommonEventID != 0:
+		parts = append(parts, fmt.Sprintf("Data/CommonEvents.rvdata2: common event %d", location.CommonEventID))
+	case location.TroopID != 0:
+		parts = append(parts, fmt.Sprintf("Data/Troops.rvdata2: troop %d, page %d", location.TroopID, location.Page+1))
+	}
+	if location.Command >= 0 {
+		parts = append(parts, fmt.Sprintf("line %d", location.Command+1))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Finding is a problem found by a check
+type Finding struct {
+	Check    string
+	Severity Severity
+	Location Location
+	Message  string
+}
+
+// String returns the finding on a single line, ie.
+// "error: Data/Map001.rvdata2: event 2, page 1, line 4: transfer to missing map 9 (transfer-missing-map)"
+func (finding Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", finding.Severity, finding.Location, finding.Message, finding.Check)
+}
+
+// Options controls how Run checks the project
+type Options struct {
+	// RTP is searched for graphics that aren't in the project, ie. os.DirFS
+	// of the directory the RTP is installed in
+	RTP fs.FS
+	// Disabled is the name of each check that isn't run, ie. CheckEmptyPage
+	Disabled []string
+}
+
+// projectData is the data used by the checks, it's loaded from the project by Run
+type projectData struct {
+	fsys fs.FS
+	// mapIDs is the ID of every map file in ascending order
+	mapIDs       []int
+	maps         map[int]*rmvx.Map
+	system       *rmvx.System
+	commonEvents []rmvx.CommonEvent
+	troops       []rmvx.Troop
+	items        []rmvx.Item
+	weapons      []rmvx.Weapon
+	armors       []rmvx.Armor
+}
+
+// Run checks every map, common event and troop in the project. Findings are
+// in the order they appear in the project.
+//
+// Use LoadOptions.AllowMissing when loading the project to skip checks that
+// need database files the project doesn't have.
+func Run(project *rmvx.Project, options Options) ([]Finding, error) {
+	data, err := loadProjectData(project)
+	if err != nil {
+		return nil, err
+	}
+	return run(data, options), nil
+}
+
+func loadProjectData(project *rmvx.Project) (*projectData, error) {
+	data := &projectData{
+		fsys: project.FS(),
+		maps: make(map[int]*rmvx.Map),
+	}
+	scan, err := project.ScanMaps()
+	if err != nil {
+		return nil, err
+	}
+	data.mapIDs = scan.Files
+	for _, mapID := range scan.Files {
+		m, err := project.Map(mapID)
+		if err != nil {
+			return nil, err
+		}
+		data.maps[mapID] = m
+	}
+	if data.system, err = project.GetSystem(); err != nil {
+		return nil, err
+	}
+	if data.commonEvents, err = project.GetCommonEvents(); err != nil {
+		return nil, err
+	}
+	if data.troops, err = project.GetTroops(); err != nil {
+		return nil, err
+	}
+	if data.items, err = project.GetItems(); err != nil {
+		return nil, err
+	}
+	if data.weapons, err = project.GetWeapons(); err != nil {
+		return nil, err
+	}
+	if data.armors, err = project.GetArmors(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
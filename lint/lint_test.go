@@ -0,0 +1,122 @@
+package lint
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/silbinarywolf/rmvx"
+)
+
+func TestRun(t *testing.T) {
+	cmd := func(code int, params ...interface{}) rmvx.EventCommand {
+		return rmvx.EventCommand{Code: code, Parameters: params}
+	}
+	data := &projectData{
+		fsys: fstest.MapFS{
+			"Graphics/Characters/Actor1.png": &fstest.MapFile{},
+		},
+		mapIDs: []int{1, 2},
+		maps: map[int]*rmvx.Map{
+			1: {
+				Width:  10,
+				Height: 8,
+				Events: map[int]rmvx.MapEvent{
+					1: {ID: 1, Pages: []rmvx.MapEventPage{
+						{
+							Graphic: rmvx.MapEventGraphic{CharacterName: "Actor1"},
+							List: []rmvx.EventCommand{
+								cmd(201, 0, 2, 3, 4, 2, 0),
+								cmd(201, 0, 3, 0, 0, 2, 0),
+								cmd(201, 0, 1, 10, 0, 2, 0),
+								cmd(201, 1, 5, 6, 7, 2, 0),
+								cmd(121, 5, 5, 0),
+								cmd(121, 6, 6, 0),
+								cmd(126, 1, 0, 0, 1),
+								cmd(126, 2, 0, 0, 1),
+								cmd(302, 1, 3, 0, 0, false),
+								cmd(0),
+							},
+						},
+						// empty pages that hide the event are fine
+						{Condition: rmvx.MapPageCondition{SelfSwitchValid: true, SelfSwitchCH: "A"}, List: []rmvx.EventCommand{cmd(0)}},
+					}},
+					2: {ID: 2, Pages: []rmvx.MapEventPage{
+						{Graphic: rmvx.MapEventGraphic{CharacterName: "People9"}, List: []rmvx.EventCommand{cmd(111, 0, 6, 0), cmd(0)}},
+					}},
+					3: {ID: 3, Pages: []rmvx.MapEventPage{
+						{List: []rmvx.EventCommand{cmd(0)}},
+					}},
+				},
+			},
+			2: {
+				Width:         5,
+				Height:        5,
+				EncounterList: []rmvx.MapEncounter{{TroopID: 1, Weight: 10}},
+			},
+		},
+		system: &rmvx.System{Switches: []string{"", "", "", "", "", "Door Open", ""}},
+		commonEvents: []rmvx.CommonEvent{
+			{},
+			{ID: 1, List: []rmvx.EventCommand{cmd(128, 4, 0, 0, 1)}},
+		},
+		items: []rmvx.Item{{}, {BaseItem: rmvx.BaseItem{ID: 1}}},
+	}
+	findings := run(data, Options{
+		RTP: fstest.MapFS{
+			"Graphics/Characters/People1.png": &fstest.MapFile{},
+		},
+	})
+	expected := []Finding{
+		{CheckTransferMissingMap, SeverityError, Location{MapID: 1, EventID: 1, Command: 1}, "transfer to missing map 3"},
+		{CheckTransferOutOfBounds, SeverityError, Location{MapID: 1, EventID: 1, Command: 2}, "transfer to (10, 0) is outside of map 1 which is 10x8"},
+		{CheckMissingItem, SeverityError, Location{MapID: 1, EventID: 1, Command: 7}, "item 2 does not exist"},
+		{CheckMissingCharacter, SeverityError, Location{MapID: 1, EventID: 2, Command: -1}, `character graphic "People9" not found`},
+		{CheckEmptyPage, SeverityWarning, Location{MapID: 1, EventID: 3, Command: -1}, "page has no commands or graphic"},
+		{CheckEncounterStep, SeverityWarning, Location{MapID: 2, Command: -1}, "map has 1 encounters but encounter steps is 0"},
+		{CheckUnreadSwitch, SeverityWarning, Location{MapID: 1, EventID: 1, Command: 4}, `switch 0005 "Door Open" is set 1 times but never read`},
+	}
+	if !reflect.DeepEqual(findings, expected) {
+		t.Fatalf("unexpected findings\nexpected: %v\ngot: %v", expected, findings)
+	}
+	if got := findings[0].String(); got != "error: Data/Map001.rvdata2: event 1, page 1, line 2: transfer to missing map 3 (transfer-missing-map)" {
+		t.Fatalf("unexpected string: %s", got)
+	}
+	if got := (Location{CommonEventID: 1, Command: 0}).String(); got != "Data/CommonEvents.rvdata2: common event 1, line 1" {
+		t.Fatalf("unexpected string: %s", got)
+	}
+
+	findings = run(data, Options{Disabled: []string{CheckEncounterStep, CheckUnreadSwitch, CheckMissingItem, CheckEmptyPage}})
+	if len(findings) != 3 || findings[2].Check != CheckMissingCharacter {
+		t.Fatalf("expected disabled checks to be skipped but got %v", findings)
+	}
+}
+
+func TestRunProject(t *testing.T) {
+	project, err := rmvx.LoadProjectWithOptions(context.Background(), os.DirFS("../testdata"), rmvx.LoadOptions{
+		AllowMissing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := Run(project, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test project doesn't have its graphics or a second map
+	var got []string
+	for _, finding := range findings {
+		if finding.Check != CheckEmptyPage {
+			got = append(got, finding.String())
+		}
+	}
+	expected := []string{
+		`error: Data/Map001.rvdata2: event 1, page 1: character graphic "Her" not found (missing-character)`,
+		"error: Data/Map001.rvdata2: event 2, page 1, line 1: transfer to missing map 2 (transfer-missing-map)",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected findings\nexpected: %q\ngot: %q", expected, got)
+	}
+}
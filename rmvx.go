@@ -4,60 +4,55 @@ package rmvx
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
-	"math"
 	"reflect"
-	"strconv"
+	"sort"
+	"sync"
 
 	"github.com/silbinarywolf/rmvx/internal/rubymarshal"
 )
 
-var ErrInvalidProject = errors.New("invalid project")
-
-// Table is a user-defined Ruby type for RPG Maker VX Ace
-type Table struct {
-	X    int32
-	Y    int32
-	Z    int32
-	Data []int16
-}
-
-func (table *Table) Get(x, y, z int) int16 {
-	return table.Data[(table.X*table.Y*int32(z))+(table.X*int32(y))+int32(x)]
-}
-
-func (table *Table) GetInt32(x, y, z int32) int16 {
-	return table.Data[(table.X*table.Y*z)+(table.X*y)+x]
-}
-
-func (table *Table) GetWrappedInt32(x, y, z int32) int16 {
-	var xWrapped int32
-	if x%table.X < 0 {
-		xWrapped = x + table.X
-	} else {
-		xWrapped = x
-	}
-	var yWrapped int32
-	if y%table.Y < 0 {
-		yWrapped = y + table.Y
-	} else {
-		yWrapped = y
-	}
-	return table.GetInt32(xWrapped, yWrapped, z)
-}
+var (
+	ErrInvalidProject  = errors.New("invalid project")
+	ErrMapNotFound     = errors.New("map not found")
+	ErrTilesetNotFound = errors.New("tileset not found")
+	// ErrNotLoaded is returned when accessing a database that was skipped with LoadSkip
+	ErrNotLoaded = errors.New("database not loaded")
+)
 
 type Project struct {
 	System System
 	// Actors is a slice of actor data where the 0th entry is empty due to how RMVX stores data
 	Actors []Actor
 
-	fs       fs.FS
-	tilesets []Tileset
-	mapInfos map[int]MapInfo
+	fs           fs.FS
+	options      LoadOptions
+	tilesets     []Tileset
+	mapInfos     map[int]MapInfo
+	commonEvents []CommonEvent
+	troops       []Troop
+	items        []Item
+	weapons      []Weapon
+	armors       []Armor
+
+	tilesetsDB lazyDatabase
+	mapInfosDB lazyDatabase
+	systemDB   lazyDatabase
+	actorsDB   lazyDatabase
+
+	commonEventsDB lazyDatabase
+	troopsDB       lazyDatabase
+	itemsDB        lazyDatabase
+	weaponsDB      lazyDatabase
+	armorsDB       lazyDatabase
+
+	mapCacheMu sync.Mutex
+	mapCache   map[int]*mapCacheEntry
 }
 
 type Tileset struct {
@@ -165,9 +160,11 @@ type MapPageCondition struct {
 }
 
 type MapEncounter struct {
-	TroopID   int         `ruby:"@troop_id"`
-	RegionSet interface{} `ruby:"@region_set"`
-	Weight    int         `ruby:"@weight"`
+	TroopID int `ruby:"@troop_id"`
+	// RegionSet is the list of region IDs the encounter can occur in.
+	// If empty, the encounter can occur anywhere on the map.
+	RegionSet []int `ruby:"@region_set"`
+	Weight    int   `ruby:"@weight"`
 }
 
 type MapEvent struct {
@@ -178,6 +175,53 @@ type MapEvent struct {
 	Pages []MapEventPage `ruby:"@pages"`
 }
 
+type CommonEvent struct {
+	ID   int    `ruby:"@id"`
+	Name string `ruby:"@name"`
+	// Trigger is 0 for none, 1 for autorun and 2 for parallel
+	Trigger int `ruby:"@trigger"`
+	// SwitchID is the switch that must be on for an autorun or parallel
+	// common event to run
+	SwitchID int            `ruby:"@switch_id"`
+	List     []EventCommand `ruby:"@list"`
+}
+
+type Troop struct {
+	ID      int           `ruby:"@id"`
+	Name    string        `ruby:"@name"`
+	Members []TroopMember `ruby:"@members"`
+	Pages   []TroopPage   `ruby:"@pages"`
+}
+
+type TroopMember struct {
+	EnemyID int  `ruby:"@enemy_id"`
+	X       int  `ruby:"@x"`
+	Y       int  `ruby:"@y"`
+	Hidden  bool `ruby:"@hidden"`
+}
+
+type TroopPage struct {
+	Condition TroopPageCondition `ruby:"@condition"`
+	// Span is 0 for once per battle, 1 for once per turn and 2 for every moment
+	Span int            `ruby:"@span"`
+	List []EventCommand `ruby:"@list"`
+}
+
+type TroopPageCondition struct {
+	TurnEnding  bool `ruby:"@turn_ending"`
+	TurnValid   bool `ruby:"@turn_valid"`
+	EnemyValid  bool `ruby:"@enemy_valid"`
+	ActorValid  bool `ruby:"@actor_valid"`
+	SwitchValid bool `ruby:"@switch_valid"`
+	TurnA       int  `ruby:"@turn_a"`
+	TurnB       int  `ruby:"@turn_b"`
+	EnemyIndex  int  `ruby:"@enemy_index"`
+	EnemyHP     int  `ruby:"@enemy_hp"`
+	ActorID     int  `ruby:"@actor_id"`
+	ActorHP     int  `ruby:"@actor_hp"`
+	SwitchID    int  `ruby:"@switch_id"`
+}
+
 type BackgroundSound struct {
 	Name   string `ruby:"@name" json:"name"`
 	Pitch  int    `ruby:"@pitch" json:"pitch"`
@@ -300,35 +344,140 @@ type ActorFeature struct {
 	Value  float64 `ruby:"@value" json:"value"`
 }
 
+// BaseItem has the fields shared by items, weapons and armors
+type BaseItem struct {
+	ID          int            `ruby:"@id" json:"id"`
+	Name        string         `ruby:"@name" json:"name"`
+	IconIndex   int            `ruby:"@icon_index" json:"iconIndex"`
+	Description string         `ruby:"@description" json:"description"`
+	Features    []ActorFeature `ruby:"@features" json:"features"`
+	Note        string         `ruby:"@note" json:"note"`
+}
+
+type Item struct {
+	BaseItem
+	Scope       int          `ruby:"@scope" json:"scope"`
+	Occasion    int          `ruby:"@occasion" json:"occasion"`
+	Speed       int          `ruby:"@speed" json:"speed"`
+	AnimationID int          `ruby:"@animation_id" json:"animationId"`
+	SuccessRate int          `ruby:"@success_rate" json:"successRate"`
+	Repeats     int          `ruby:"@repeats" json:"repeats"`
+	TPGain      int          `ruby:"@tp_gain" json:"tpGain"`
+	HitType     int          `ruby:"@hit_type" json:"hitType"`
+	Damage      ItemDamage   `ruby:"@damage" json:"damage"`
+	Effects     []ItemEffect `ruby:"@effects" json:"effects"`
+	ItemTypeID  int          `ruby:"@itype_id" json:"itypeId"`
+	Price       int          `ruby:"@price" json:"price"`
+	Consumable  bool         `ruby:"@consumable" json:"consumable"`
+}
+
+type ItemDamage struct {
+	Type      int    `ruby:"@type" json:"type"`
+	ElementID int    `ruby:"@element_id" json:"elementId"`
+	Formula   string `ruby:"@formula" json:"formula"`
+	Variance  int    `ruby:"@variance" json:"variance"`
+	Critical  bool   `ruby:"@critical" json:"critical"`
+}
+
+type ItemEffect struct {
+	Code   int     `ruby:"@code" json:"code"`
+	DataID int     `ruby:"@data_id" json:"dataId"`
+	Value1 float64 `ruby:"@value1" json:"value1"`
+	Value2 float64 `ruby:"@value2" json:"value2"`
+}
+
+type Weapon struct {
+	BaseItem
+	Price       int `ruby:"@price" json:"price"`
+	EquipTypeID int `ruby:"@etype_id" json:"etypeId"`
+	// Params is MaxHP, MaxMP, ATK, DEF, MAT, MDF, AGI and LUK
+	Params       []int `ruby:"@params" json:"params"`
+	WeaponTypeID int   `ruby:"@wtype_id" json:"wtypeId"`
+	AnimationID  int   `ruby:"@animation_id" json:"animationId"`
+}
+
+type Armor struct {
+	BaseItem
+	Price       int `ruby:"@price" json:"price"`
+	EquipTypeID int `ruby:"@etype_id" json:"etypeId"`
+	// Params is MaxHP, MaxMP, ATK, DEF, MAT, MDF, AGI and LUK
+	Params      []int `ruby:"@params" json:"params"`
+	ArmorTypeID int   `ruby:"@atype_id" json:"atypeId"`
+}
+
+// FS returns the file system the project was loaded from
+func (project *Project) FS() fs.FS {
+	return project.fs
+}
+
 func (project *Project) getMapFilenameByID(mapID int) (string, error) {
-	if mapID == 0 {
-		return "", errors.New("invalid map id: 0")
-	}
-	_, ok := project.mapInfos[mapID]
-	if !ok {
-		return "", fmt.Errorf("map does not exist: %d", mapID)
+	if mapID <= 0 {
+		return "", fmt.Errorf("%w: invalid map id: %d", ErrMapNotFound, mapID)
 	}
-	idPart := strconv.Itoa(mapID)
-	switch len(idPart) {
-	case 2:
-		idPart = "0" + idPart
-	case 1:
-		idPart = "00" + idPart
-	case 0:
-		idPart = "000"
-	}
-	mapFilename := "Map" + idPart
-	return mapFilename, nil
+	return mapAssetName(mapID), nil
 }
 
 func (project *Project) GetTileset(id int) (*Tileset, error) {
-	if id == 0 || id >= len(project.tilesets) {
-		return nil, errors.New("invalid tileset id given, out of bounds")
+	if err := project.loadTilesets(); err != nil {
+		return nil, err
+	}
+	if id <= 0 || id >= len(project.tilesets) {
+		return nil, fmt.Errorf("%w: %d", ErrTilesetNotFound, id)
 	}
 	tileset := &project.tilesets[id]
 	return tileset, nil
 }
 
+// Tilesets returns every tileset in the project ordered by ID.
+//
+// Unlike Actors, this does not include the empty 0th entry.
+func (project *Project) Tilesets() ([]Tileset, error) {
+	if err := project.loadTilesets(); err != nil {
+		return nil, err
+	}
+	if len(project.tilesets) <= 1 {
+		return nil, nil
+	}
+	return append([]Tileset(nil), project.tilesets[1:]...), nil
+}
+
+// GetMapInfo returns the map info for the given map ID
+func (project *Project) GetMapInfo(mapID int) (MapInfo, error) {
+	if err := project.loadMapInfos(); err != nil {
+		return MapInfo{}, err
+	}
+	mapInfo, ok := project.mapInfos[mapID]
+	if !ok {
+		return MapInfo{}, fmt.Errorf("%w: %d", ErrMapNotFound, mapID)
+	}
+	return mapInfo, nil
+}
+
+// MapInfos returns a copy of the map infos in the project keyed by map ID
+func (project *Project) MapInfos() (map[int]MapInfo, error) {
+	if err := project.loadMapInfos(); err != nil {
+		return nil, err
+	}
+	mapInfos := make(map[int]MapInfo, len(project.mapInfos))
+	for id, mapInfo := range project.mapInfos {
+		mapInfos[id] = mapInfo
+	}
+	return mapInfos, nil
+}
+
+// MapIDs returns the ID of every map in the project in ascending order
+func (project *Project) MapIDs() ([]int, error) {
+	if err := project.loadMapInfos(); err != nil {
+		return nil, err
+	}
+	mapIDs := make([]int, 0, len(project.mapInfos))
+	for id := range project.mapInfos {
+		mapIDs = append(mapIDs, id)
+	}
+	sort.Ints(mapIDs)
+	return mapIDs, nil
+}
+
 func (project *Project) LoadMapByID(mapID int) (*Map, error) {
 	mapFilename, err := project.getMapFilenameByID(mapID)
 	if err != nil {
@@ -336,13 +485,49 @@ func (project *Project) LoadMapByID(mapID int) (*Map, error) {
 	}
 	var mapData Map
 	if err := loadRMVXDataFile(project, mapFilename, &mapData); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &mapNotFoundError{mapID: mapID, err: err}
+		}
 		return nil, err
 	}
 	return &mapData, nil
 }
 
+// mapNotFoundError is ErrMapNotFound along with the error from opening the
+// map file, so errors.Is works for both
+type mapNotFoundError struct {
+	mapID int
+	err   error
+}
+
+func (err *mapNotFoundError) Error() string {
+	return fmt.Sprintf("%s: %d: %s", ErrMapNotFound, err.mapID, err.err)
+}
+
+func (err *mapNotFoundError) Is(target error) bool {
+	return target == ErrMapNotFound
+}
+
+func (err *mapNotFoundError) Unwrap() error {
+	return err.err
+}
+
+// LoadMapByName loads the first map with the given name, in the order
+// they're displayed in the editor
+func (project *Project) LoadMapByName(name string) (*Map, error) {
+	tree, err := project.MapTree()
+	if err != nil {
+		return nil, err
+	}
+	mapID, ok := tree.LookupName(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrMapNotFound, name)
+	}
+	return project.LoadMapByID(mapID)
+}
+
 func loadRMVXDataFile(project *Project, assetName string, value interface{}) error {
-	f, err := project.fs.Open("Data/" + assetName + ".rvdata2")
+	f, err := project.fs.Open(dataDirectory + "/" + assetName + dataFileExt)
 	if err != nil {
 		return err
 	}
@@ -352,66 +537,39 @@ func loadRMVXDataFile(project *Project, assetName string, value interface{}) err
 		return err
 	}
 	d := rubymarshal.NewDecoder(bytesData)
-	d.AddUserDefinedLoad("Table", loadTable)
-	d.AddUserDefinedLoad("Tone", loadTone)
+	addUserDefinedLoads(d, &project.options)
+	if project.options.AllowUnknownFields {
+		d.AllowUnknownFields()
+	}
+	if project.options.Transcoder != nil {
+		d.SetTranscoder(project.options.Transcoder)
+	}
 	if err := d.Decode(value); err != nil {
 		return err
 	}
 	return nil
 }
 
-func LoadProject(fs fs.FS) (*Project, error) {
-	// Load entrypoint file
-	{
-		f, err := fs.Open("Game.rvproj2")
-		if err != nil {
-			return nil, err
-		}
-		bytesData, err := ioutil.ReadAll(f)
-		f.Close()
-		if err != nil {
-			return nil, err
-		}
-		if !bytes.HasPrefix(bytesData, []byte("RPGVXAce 1")) {
-			return nil, ErrInvalidProject
-		}
-	}
-
-	project := &Project{}
-	project.fs = fs
-
-	// Load tilesets
-	if err := loadRMVXDataFile(project, "Tilesets", &project.tilesets); err != nil {
-		return nil, err
-	}
-
-	// Load map infos
-	if err := loadRMVXDataFile(project, "MapInfos", &project.mapInfos); err != nil {
-		return nil, err
-	}
-
-	// Load System
-	if err := loadRMVXDataFile(project, "System", &project.System); err != nil {
-		return nil, err
-	}
-
-	// Load Actors
-	if err := loadRMVXDataFile(project, "Actors", &project.Actors); err != nil {
-		return nil, err
-	}
-
-	// DEBUG: Get generic output of data, this is used to figure out struct... structure.
-	/* var v interface{}
-	if err := loadRMVXDataFile(project, "CommonEvents", &v); err != nil {
-		panic(err)
+// checkProjectFile loads the entrypoint file and checks that it's an RPG Maker VX Ace project
+func checkProjectFile(fs fs.FS) error {
+	f, err := fs.Open("Game.rvproj2")
+	if err != nil {
+		return err
 	}
-	b, err := json.MarshalIndent(v, "", "\t")
+	bytesData, err := ioutil.ReadAll(f)
+	f.Close()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if !bytes.HasPrefix(bytesData, []byte("RPGVXAce 1")) {
+		return ErrInvalidProject
 	}
-	panic(fmt.Sprintf("%s", b)) */
+	return nil
+}
 
-	return project, nil
+// LoadProject loads the project with the default LoadOptions
+func LoadProject(fs fs.FS) (*Project, error) {
+	return LoadProjectWithOptions(context.Background(), fs, LoadOptions{})
 }
 
 type Tone struct {
@@ -419,8 +577,46 @@ type Tone struct {
 	Gray             int16
 }
 
+// ToneFloat is Tone without any loss of precision.
+//
+// Use this instead of Tone for fields that may have been set by scripts
+// with fractional values.
+type ToneFloat struct {
+	Red, Green, Blue float64
+	Gray             float64
+}
+
+var toneFloatType = reflect.TypeOf(ToneFloat{})
+
 func loadTone(data []byte, val reflect.Value) {
-	r := bytes.NewBuffer(data)
+	decodeTone(data, val, false)
+}
+
+// loadPreciseTone is loadTone but interface values are stored as ToneFloat,
+// see LoadOptions.PreciseTone
+func loadPreciseTone(data []byte, val reflect.Value) {
+	decodeTone(data, val, true)
+}
+
+func decodeTone(data []byte, val reflect.Value, precise bool) {
+	if len(data) != 4*8 {
+		panic(errors.New("Tone: bad file format"))
+	}
+	value := ToneFloat{
+		Red:   readFloat64(data[0:]),
+		Green: readFloat64(data[8:]),
+		Blue:  readFloat64(data[16:]),
+		Gray:  readFloat64(data[24:]),
+	}
+	destType := val.Elem().Type()
+	if destType.Kind() == reflect.Ptr {
+		destType = destType.Elem()
+	}
+	if destType == toneFloatType ||
+		(precise && destType.Kind() == reflect.Interface) {
+		setUserDefined("Tone", reflect.ValueOf(value), val)
+		return
+	}
 
 	// note(jae): 2021-06-18
 	// RPG Maker VX Ace stores "Tone" as doubles even though
@@ -431,100 +627,47 @@ func loadTone(data []byte, val reflect.Value) {
 	// and in the GUI, it's impossible to do so.
 	//
 	// This is why we just store them as int16s internally.
-	red := mustReadFloat64(r)
-	green := mustReadFloat64(r)
-	blue := mustReadFloat64(r)
-	gray := mustReadFloat64(r)
-	value := Tone{
-		Red:   int16(red),
-		Green: int16(green),
-		Blue:  int16(blue),
-		Gray:  int16(gray),
-	}
-	switch val.Elem().Kind() {
-	case reflect.Ptr, reflect.Interface:
-		val.Elem().Set(reflect.ValueOf(&value))
-	case reflect.Struct:
-		val.Elem().Set(reflect.ValueOf(value))
-	default:
-		panic("loadTone: unhandled type: " + val.Elem().Kind().String())
-	}
-}
-
-func loadTable(data []byte, val reflect.Value) {
-	r := bytes.NewBuffer(data)
-
-	// Read header
-	var x, y, z int32
-	var arrSize int
-	{
-		_ = mustReadInt32(r) // argument count
-		x = mustReadInt32(r)
-		y = mustReadInt32(r)
-		z = mustReadInt32(r)
-		sizeInt32 := mustReadInt32(r)
-		if sizeInt32 != x*y*z {
-			panic(errors.New("Table: bad file format"))
-		}
-		arrSize = int(sizeInt32)
-	}
-
-	// Read array
-	tableData := make([]int16, arrSize)
-	{
-		// note(Jae): 2021-06-09
-		// If we want to speed-up load times for LittleEndian machines,
-		// we could just detect machine endianness and then do a copy()
-		// (Original Ruby source code does a memcpy())
-		for i := range tableData {
-			tableData[i] = mustReadInt16(r)
+	setUserDefined("Tone", reflect.ValueOf(Tone{
+		Red:   int16(value.Red),
+		Green: int16(value.Green),
+		Blue:  int16(value.Blue),
+		Gray:  int16(value.Gray),
+	}), val)
+}
+
+func dumpTone(val reflect.Value) []byte {
+	var value ToneFloat
+	switch tone := reflect.Indirect(val).Interface().(type) {
+	case Tone:
+		value = ToneFloat{
+			Red:   float64(tone.Red),
+			Green: float64(tone.Green),
+			Blue:  float64(tone.Blue),
+			Gray:  float64(tone.Gray),
 		}
-	}
-
-	value := Table{
-		X:    x,
-		Y:    y,
-		Z:    z,
-		Data: tableData,
-	}
-	switch val.Elem().Kind() {
-	case reflect.Ptr, reflect.Interface:
-		val.Elem().Set(reflect.ValueOf(&value))
-	case reflect.Struct:
-		val.Elem().Set(reflect.ValueOf(value))
+	case ToneFloat:
+		value = tone
 	default:
-		panic("LoadTable: unhandled type: " + val.Elem().Kind().String())
+		panic(fmt.Sprintf("dumpTone: unhandled type: %T", tone))
 	}
+	data := make([]byte, 0, 4*8)
+	data = appendFloat64(data, value.Red)
+	data = appendFloat64(data, value.Green)
+	data = appendFloat64(data, value.Blue)
+	data = appendFloat64(data, value.Gray)
+	return data
 }
 
-// mustReadFloat64 is a fast-path binary.LittleEndian.Read
-func mustReadFloat64(r *bytes.Buffer) float64 {
-	b := make([]byte, 8)
-	if _, err := r.Read(b); err != nil {
-		panic(err)
-	}
-	_ = b[7] // bounds check hint to compiler; see golang.org/issue/14808
-	uint64Value := uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
-		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
-	return math.Float64frombits(uint64Value)
+func loadTable(data []byte, val reflect.Value) {
+	setTable(decodeTable(data, false), val)
 }
 
-// mustReadInt32 is a fast-path binary.LittleEndian.Read
-func mustReadInt32(r *bytes.Buffer) int32 {
-	bs := make([]byte, 4)
-	if _, err := r.Read(bs); err != nil {
-		panic(err)
-	}
-	_ = bs[3] // bounds check hint to compiler; see golang.org/issue/14808
-	return int32(uint32(bs[0]) | uint32(bs[1])<<8 | uint32(bs[2])<<16 | uint32(bs[3])<<24)
+// loadTableAliased is loadTable but the table data shares memory with the
+// file data, see LoadOptions.AliasTableData
+func loadTableAliased(data []byte, val reflect.Value) {
+	setTable(decodeTable(data, true), val)
 }
 
-// mustReadInt16 is a fast-path binary.LittleEndian.Read
-func mustReadInt16(r *bytes.Buffer) int16 {
-	bs := make([]byte, 2)
-	if _, err := r.Read(bs); err != nil {
-		panic(err)
-	}
-	_ = bs[1] // bounds check hint to compiler; see golang.org/issue/14808
-	return int16(uint16(bs[0]) | uint16(bs[1])<<8)
+func setTable(value Table, val reflect.Value) {
+	setUserDefined("Table", reflect.ValueOf(value), val)
 }
@@ -1,6 +1,8 @@
 package rmvx
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,7 +12,10 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"unsafe"
 
 	"github.com/silbinarywolf/rmvx/internal/jsondiff"
 	"github.com/silbinarywolf/rmvx/internal/rubymarshal"
@@ -199,8 +204,7 @@ func assertDecodeMatchesJSON(t *testing.T, fileName string, input []byte, v inte
 	typeName := ref.Type().Elem().Kind().String()
 
 	d := rubymarshal.NewDecoder(input)
-	d.AddUserDefinedLoad("Table", loadTable)
-	d.AddUserDefinedLoad("Tone", loadTone)
+	addUserDefinedLoads(d, &LoadOptions{})
 	if err := d.Decode(v); err != nil {
 		t.Fatal(err)
 	}
@@ -225,3 +229,1660 @@ func assertDecodeMatchesJSON(t *testing.T, fileName string, input []byte, v inte
 		t.Fatalf("expected output to equal contents of \"%s\"\n\n%s", outputFilename, str)
 	}
 }
+
+func TestMapRegionAndShadow(t *testing.T) {
+	project, err := LoadProject(&osFS{dir: testDataDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.LoadMapByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.RegionAt(3, 4); got != 0 {
+		t.Fatalf("expected region 0 but got %d", got)
+	}
+	m.SetShadowAt(3, 4, ShadowTopLeft|ShadowBottomRight)
+	m.SetRegionAt(3, 4, 63)
+	m.SetRegionAt(5, 4, 63)
+	m.SetRegionAt(0, 0, 2)
+	if got := m.RegionAt(3, 4); got != 63 {
+		t.Fatalf("expected region 63 but got %d", got)
+	}
+	if got := m.ShadowAt(3, 4); got != ShadowTopLeft|ShadowBottomRight {
+		t.Fatalf("expected shadow to be preserved when setting region but got %04b", got)
+	}
+	if got := m.RegionAt(-1, m.Height); got != 0 {
+		t.Fatalf("expected region 0 for out of bounds position but got %d", got)
+	}
+	index := m.RegionIndex()
+	if len(index) != 2 {
+		t.Fatalf("expected 2 regions but got %d", len(index))
+	}
+	if got := index[63]; len(got) != 2 || got[0] != (Point{3, 4}) || got[1] != (Point{5, 4}) {
+		t.Fatalf("unexpected tiles for region 63: %v", got)
+	}
+}
+
+func TestMapEncounters(t *testing.T) {
+	m := Map{
+		EncounterList: []MapEncounter{
+			{TroopID: 1, Weight: 10, RegionSet: []int{}},
+			{TroopID: 2, Weight: 5, RegionSet: []int{1}},
+			{TroopID: 3, Weight: 5, RegionSet: []int{1, 2}},
+			{TroopID: 1, Weight: 10, RegionSet: []int{2}},
+		},
+	}
+	m.Data.X, m.Data.Y, m.Data.Z = 2, 1, 4
+	m.Data.Data = make([]int16, 2*1*4)
+	m.SetRegionAt(1, 0, 1)
+
+	if got := m.Encounters(0, 0); len(got) != 1 || got[0].TroopID != 1 || got[0].Probability != 1 {
+		t.Fatalf("unexpected encounters for tile without region: %+v", got)
+	}
+	got := m.Encounters(1, 0)
+	expected := []EncounterCandidate{
+		{TroopID: 1, Weight: 10, Probability: 0.5},
+		{TroopID: 2, Weight: 5, Probability: 0.25},
+		{TroopID: 3, Weight: 5, Probability: 0.25},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v but got %+v", expected, got)
+	}
+	if tables := m.EncountersByRegion(); len(tables) != 2 || len(tables[1]) != 3 {
+		t.Fatalf("unexpected encounter tables: %+v", tables)
+	}
+}
+
+func TestMapTree(t *testing.T) {
+	tree := NewMapTree(map[int]MapInfo{
+		1: {Name: "World", Order: 1},
+		2: {Name: "Town", Order: 3, ParentID: 1},
+		3: {Name: "Inn", Order: 4, ParentID: 2},
+		4: {Name: "Cave", Order: 2, ParentID: 1},
+		5: {Name: "Debug Room", Order: 5},
+		6: {Name: "Lost", Order: 6, ParentID: 99},
+	})
+	if got, expected := tree.Roots(), []int{1, 5, 6}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected roots %v but got %v", expected, got)
+	}
+	if got, expected := tree.Children(1), []int{4, 2}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected children %v but got %v", expected, got)
+	}
+	if got, expected := tree.Path(3), []string{"World", "Town", "Inn"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected path %v but got %v", expected, got)
+	}
+	if got, expected := tree.IDs(), []int{1, 4, 2, 3, 5, 6}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected order %v but got %v", expected, got)
+	}
+	if id, ok := tree.LookupName("Inn"); !ok || id != 3 {
+		t.Fatalf("expected to find map 3 by name but got %d", id)
+	}
+	if _, ok := tree.LookupName("Nowhere"); ok {
+		t.Fatal("expected not to find map by name")
+	}
+
+	// a corrupt MapInfos where 2 and 3 are each other's parent
+	tree = NewMapTree(map[int]MapInfo{
+		1: {Name: "World", Order: 1},
+		2: {Name: "Town", Order: 3, ParentID: 3},
+		3: {Name: "Inn", Order: 2, ParentID: 2},
+		4: {Name: "Cellar", Order: 4, ParentID: 3},
+	})
+	if got, expected := tree.Roots(), []int{1, 3}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected roots %v but got %v", expected, got)
+	}
+	if got, expected := tree.IDs(), []int{1, 3, 2, 4}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected order %v but got %v", expected, got)
+	}
+	if got, expected := tree.Path(2), []string{"Inn", "Town"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected path %v but got %v", expected, got)
+	}
+	if parent := tree.Parent(3); parent != 0 {
+		t.Fatalf("expected map in cycle to be a root map but got parent %d", parent)
+	}
+}
+
+func TestProjectLookup(t *testing.T) {
+	project, err := LoadProject(&osFS{dir: testDataDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapIDs, err := project.MapIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{1, 2}; !reflect.DeepEqual(mapIDs, expected) {
+		t.Fatalf("expected map IDs %v but got %v", expected, mapIDs)
+	}
+	tilesets, err := project.Tilesets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tilesets) == 0 || tilesets[0].ID != 1 {
+		t.Fatalf("expected first tileset to have ID 1: %v", tilesets)
+	}
+	if _, err := project.LoadMapByName("Start"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.LoadMapByName("Nowhere"); !errors.Is(err, ErrMapNotFound) {
+		t.Fatalf("expected ErrMapNotFound but got %v", err)
+	}
+	if _, err := project.LoadMapByID(999); !errors.Is(err, ErrMapNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrMapNotFound and fs.ErrNotExist but got %v", err)
+	}
+	if _, err := project.GetTileset(999); !errors.Is(err, ErrTilesetNotFound) {
+		t.Fatalf("expected ErrTilesetNotFound but got %v", err)
+	}
+}
+
+func TestMapFilename(t *testing.T) {
+	for _, test := range []struct {
+		ID       int
+		Filename string
+	}{
+		{1, "Data/Map001.rvdata2"},
+		{42, "Data/Map042.rvdata2"},
+		{999, "Data/Map999.rvdata2"},
+		{1000, "Data/Map1000.rvdata2"},
+		{123456, "Data/Map123456.rvdata2"},
+	} {
+		if got := MapFilename(test.ID); got != test.Filename {
+			t.Errorf("expected %q but got %q", test.Filename, got)
+		}
+		if got, ok := ParseMapFilename(test.Filename); !ok || got != test.ID {
+			t.Errorf("expected %q to parse as %d but got %d", test.Filename, test.ID, got)
+		}
+	}
+	for _, name := range []string{"MapInfos.rvdata2", "Map0001.rvdata2", "Map01.rvdata2", "Map000.rvdata2", "Map-01.rvdata2", "Map001.rvdata"} {
+		if id, ok := ParseMapFilename(name); ok {
+			t.Errorf("expected %q to not parse as a map file but got %d", name, id)
+		}
+	}
+}
+
+func TestScanMaps(t *testing.T) {
+	project, err := LoadProject(&osFS{dir: testDataDirectory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan, err := project.ScanMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// testdata has Map001 and MapInfos has an entry for Map002 but no file
+	expected := &MapScan{
+		Files:   []int{1},
+		Missing: []int{2},
+	}
+	if !reflect.DeepEqual(scan, expected) {
+		t.Fatalf("expected %+v but got %+v", expected, scan)
+	}
+}
+
+func TestLoadAll(t *testing.T) {
+	// the test project has no common events, troops, items, weapons or
+	// armors, so add databases with a single empty entry
+	files := addTestProjectFiles(t, fstest.MapFS{})
+	databases := []string{"Data/CommonEvents.rvdata2", "Data/Troops.rvdata2", "Data/Items.rvdata2", "Data/Weapons.rvdata2", "Data/Armors.rvdata2"}
+	for _, name := range databases {
+		files[name] = &fstest.MapFile{Data: []byte("\x04\x08[\x060")}
+	}
+	project, err := LoadAll(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := project.options
+	for _, mode := range []LoadMode{options.Tilesets, options.MapInfos, options.System, options.Actors, options.CommonEvents,
+		options.Troops, options.Items, options.Weapons, options.Armors, options.Maps} {
+		if mode != LoadEager {
+			t.Fatalf("expected every database to be loaded eagerly: %+v", options)
+		}
+	}
+	if len(project.tilesets) == 0 || len(project.mapInfos) == 0 || len(project.Actors) == 0 {
+		t.Fatal("expected databases to be loaded")
+	}
+	// databases that are lazy by default must already be decoded
+	for _, name := range databases {
+		delete(files, name)
+	}
+	if _, err := project.GetCommonEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.GetTroops(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.GetItems(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.GetWeapons(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.GetArmors(); err != nil {
+		t.Fatal(err)
+	}
+	if len(project.mapCache) != 1 {
+		t.Fatalf("expected 1 map to be cached but got %d", len(project.mapCache))
+	}
+	var wg sync.WaitGroup
+	maps := make([]*Map, 8)
+	for i := range maps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := project.Map(1)
+			if err != nil {
+				t.Error(err)
+			}
+			maps[i] = m
+		}(i)
+	}
+	wg.Wait()
+	for _, m := range maps {
+		if m == nil || m != maps[0] {
+			t.Fatal("expected every call to Map to return the same cached map")
+		}
+	}
+	if _, err := project.Map(2); !errors.Is(err, ErrMapNotFound) {
+		t.Fatalf("expected ErrMapNotFound but got %v", err)
+	}
+}
+
+func TestLoadProjectWithOptions(t *testing.T) {
+	project, err := LoadProjectWithOptions(context.Background(), &osFS{dir: testDataDirectory}, LoadOptions{
+		Tilesets: LoadSkip,
+		MapInfos: LoadLazy,
+		Actors:   LoadLazy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.tilesets) != 0 || len(project.mapInfos) != 0 || len(project.Actors) != 0 {
+		t.Fatal("expected skipped and lazy databases to not be loaded")
+	}
+	if len(project.System.ArmorTypes) == 0 {
+		t.Fatal("expected System to be loaded eagerly")
+	}
+	if _, err := project.GetTileset(1); !errors.Is(err, ErrNotLoaded) {
+		t.Fatalf("expected ErrNotLoaded but got %v", err)
+	}
+	if mapIDs, err := project.MapIDs(); err != nil || len(mapIDs) == 0 {
+		t.Fatalf("expected map infos to be loaded lazily: %v", err)
+	}
+	if actors, err := project.GetActors(); err != nil || len(actors) == 0 {
+		t.Fatalf("expected actors to be loaded lazily: %v", err)
+	}
+
+	// Missing files
+	emptyProject := fstest.MapFS{
+		"Game.rvproj2": &fstest.MapFile{Data: []byte("RPGVXAce 1.02")},
+		"Data":         &fstest.MapFile{Mode: fs.ModeDir},
+	}
+	if _, err := LoadProject(emptyProject); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist but got %v", err)
+	}
+	if _, err := LoadProjectWithOptions(context.Background(), emptyProject, LoadOptions{
+		AllowMissing: true,
+		Maps:         LoadEager,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := LoadProjectWithOptions(ctx, &osFS{dir: testDataDirectory}, LoadOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable(3, 2, 2)
+	if !table.Set(2, 1, 1, 7) {
+		t.Fatal("expected Set to succeed")
+	}
+	if table.Set(3, 0, 0, 1) {
+		t.Fatal("expected Set to fail when out of bounds")
+	}
+	if v, ok := table.TryGet(2, 1, 1); !ok || v != 7 {
+		t.Fatalf("expected 7 but got %d", v)
+	}
+	if _, ok := table.TryGet(-1, 0, 0); ok {
+		t.Fatal("expected TryGet to fail when out of bounds")
+	}
+	if v := table.GetWrappedInt32(-1, 5, 1); v != 7 {
+		t.Fatalf("expected wrapped value to be 7 but got %d", v)
+	}
+	if v := table.GetWrappedInt32(-4, -1, 1); v != 7 {
+		t.Fatalf("expected wrapped value to be 7 but got %d", v)
+	}
+	for _, empty := range []Table{NewTable(0, 2, 1), NewTable(3, 0, 1)} {
+		if v := empty.GetWrappedInt32(1, 1, 0); v != 0 {
+			t.Fatalf("expected 0 for a table with no cells but got %d", v)
+		}
+	}
+	layer := table.Layer(1)
+	if len(layer) != 6 || layer[5] != 7 {
+		t.Fatalf("unexpected layer: %v", layer)
+	}
+	layer[0] = 3
+	if v := table.Get(0, 0, 1); v != 3 {
+		t.Fatalf("expected layer to share memory with table but got %d", v)
+	}
+
+	table.Resize(4, 1, 3)
+	expected := []int16{
+		0, 0, 0, 0,
+		3, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	if table.X != 4 || table.Y != 1 || table.Z != 3 || !reflect.DeepEqual(table.Data, expected) {
+		t.Fatalf("unexpected resized table: %+v", table)
+	}
+
+	if _, err := NewTableFromData(2, 2, 1, []int16{1, 2, 3}); err == nil {
+		t.Fatal("expected error when data does not match size")
+	}
+}
+
+func benchmarkDecode(b *testing.B, inputFilename string, newValue func() interface{}, loadTable func(data []byte, v reflect.Value)) {
+	input, err := readEntireRMDataFile(inputFilename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := rubymarshal.NewDecoder(input)
+		d.AddUserDefinedLoad("Table", loadTable)
+		d.AddUserDefinedLoad("Tone", loadTone)
+		if err := d.Decode(newValue()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMap(b *testing.B) {
+	benchmarkDecode(b, "Map001.rvdata2", func() interface{} { return &Map{} }, loadTable)
+}
+
+func BenchmarkDecodeTilesets(b *testing.B) {
+	benchmarkDecode(b, "Tilesets.rvdata2", func() interface{} { return &[]Tileset{} }, loadTable)
+}
+
+func BenchmarkDecodeMapAliased(b *testing.B) {
+	benchmarkDecode(b, "Map001.rvdata2", func() interface{} { return &Map{} }, loadTableAliased)
+}
+
+func BenchmarkDecodeTilesetsAliased(b *testing.B) {
+	benchmarkDecode(b, "Tilesets.rvdata2", func() interface{} { return &[]Tileset{} }, loadTableAliased)
+}
+
+func TestDecodeTableAliased(t *testing.T) {
+	// argument count, x, y, z, size and then 2 cells
+	data := []byte{
+		2, 0, 0, 0,
+		2, 0, 0, 0,
+		1, 0, 0, 0,
+		1, 0, 0, 0,
+		2, 0, 0, 0,
+		0x01, 0x02,
+		0xFF, 0xFF,
+	}
+	for _, alias := range []bool{false, true} {
+		// the buffer is backed by int16s so the cells are aligned for aliasing
+		buf := int16sAsBytes(make([]int16, len(data)/2))
+		copy(buf, data)
+		table := decodeTable(buf, alias)
+		if expected := []int16{0x0201, -1}; !reflect.DeepEqual(table.Data, expected) {
+			t.Fatalf("expected %v but got %v", expected, table.Data)
+		}
+		buf[tableHeaderSize] = 0x03
+		aliased := table.Data[0] == 0x0203
+		if shouldAlias := alias && isLittleEndian; aliased != shouldAlias {
+			t.Fatalf("expected table data to share memory with the file data to be %v but got %v", shouldAlias, aliased)
+		}
+		if aliased && &table.Data[0] != (*int16)(unsafe.Pointer(&buf[tableHeaderSize])) {
+			t.Fatal("expected table data to start at the cells in the file data")
+		}
+	}
+}
+
+func TestUserDefinedRoundTrip(t *testing.T) {
+	table := NewTable(3, 2, 1)
+	table.Set(1, 1, 0, -5)
+	for _, value := range []interface{}{
+		table,
+		Tone{Red: -68, Green: 34, Blue: 255, Gray: 0},
+		ToneFloat{Red: -68.25, Green: 34.5, Blue: 255, Gray: 0.125},
+		Color{Red: 255, Green: 128.5, Blue: 0, Alpha: 160},
+		Rect{X: -1, Y: 2, Width: 544, Height: 416},
+	} {
+		var userDefined *userDefinedType
+		for i := range userDefinedTypes {
+			for _, goType := range userDefinedTypes[i].goTypes {
+				if goType == reflect.TypeOf(value) {
+					userDefined = &userDefinedTypes[i]
+				}
+			}
+		}
+		if userDefined == nil {
+			t.Fatalf("%T is not registered as a user-defined type", value)
+		}
+		data := userDefined.dump(reflect.ValueOf(value))
+		loaded := reflect.New(reflect.TypeOf(value))
+		userDefined.load(data, loaded)
+		if redumped := userDefined.dump(loaded); !reflect.DeepEqual(redumped, data) {
+			t.Fatalf("expected %s to dump the same data after loading\nexpected: %v\ngot: %v", userDefined.className, data, redumped)
+		}
+		if table, ok := loaded.Elem().Interface().(Table); ok {
+			loaded = reflect.ValueOf(&Table{X: table.X, Y: table.Y, Z: table.Z, Data: table.Data})
+		}
+		if got := loaded.Elem().Interface(); !reflect.DeepEqual(got, value) {
+			t.Fatalf("expected %s to round-trip as %+v but got %+v", userDefined.className, value, got)
+		}
+	}
+}
+
+func TestDecodeColor(t *testing.T) {
+	// array containing a single Color, ie. [Color.new(255, 0, 0, 128)]
+	input := append([]byte("\x04\x08[\x06u:\x0aColor\x25"), dumpColor(reflect.ValueOf(Color{255, 0, 0, 128}))...)
+	var v []interface{}
+	d := rubymarshal.NewDecoder(input)
+	addUserDefinedLoads(d, &LoadOptions{})
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if color, ok := v[0].(*Color); !ok || *color != (Color{255, 0, 0, 128}) {
+		t.Fatalf("expected *Color but got %#v", v[0])
+	}
+}
+
+func TestPreciseTone(t *testing.T) {
+	data := dumpTone(reflect.ValueOf(ToneFloat{Red: 10.5, Green: -20.25, Blue: 0, Gray: 255}))
+
+	var tone Tone
+	loadTone(data, reflect.ValueOf(&tone))
+	if expected := (Tone{Red: 10, Green: -20, Blue: 0, Gray: 255}); tone != expected {
+		t.Fatalf("expected %+v but got %+v", expected, tone)
+	}
+
+	var v interface{}
+	loadPreciseTone(data, reflect.ValueOf(&v))
+	if tone, ok := v.(*ToneFloat); !ok || *tone != (ToneFloat{Red: 10.5, Green: -20.25, Blue: 0, Gray: 255}) {
+		t.Fatalf("expected *ToneFloat but got %#v", v)
+	}
+}
+
+// rbLong and the other rb* functions build Ruby Marshal data for tests,
+// symbols are written in full every time rather than linked
+func rbLong(v int) string {
+	switch {
+	case v == 0:
+		return "\x00"
+	case v > 0 && v < 123:
+		return string(rune(v + 5))
+	case v < 0 && v > -124:
+		return string([]byte{byte(v - 5)})
+	}
+	var b []byte
+	for n := v; len(b) < 4; n >>= 8 {
+		b = append(b, byte(n))
+		if (v > 0 && n>>8 == 0) || (v < 0 && n>>8 == -1) {
+			break
+		}
+	}
+	if v > 0 {
+		return string(append([]byte{byte(len(b))}, b...))
+	}
+	return string(append([]byte{byte(-len(b))}, b...))
+}
+
+func rbInt(v int) string         { return "i" + rbLong(v) }
+func rbSym(s string) string      { return ":" + rbLong(len(s)) + s }
+func rbStr(s string) string      { return "I\"" + rbLong(len(s)) + s + "\x06:\x06ET" }
+func rbFloat(s string) string    { return "f" + rbLong(len(s)) + s }
+func rbClass(name string) string { return "c" + rbLong(len(name)) + name }
+func rbLink(index int) string    { return "@" + rbLong(index) }
+
+func rbArray(items ...string) string {
+	return "[" + rbLong(len(items)) + strings.Join(items, "")
+}
+
+func rbHash(keysAndValues ...string) string {
+	return "{" + rbLong(len(keysAndValues)/2) + strings.Join(keysAndValues, "")
+}
+
+func rbObject(className string, namesAndValues ...string) string {
+	s := "o" + rbSym(className) + rbLong(len(namesAndValues)/2)
+	for i := 0; i < len(namesAndValues); i += 2 {
+		s += rbSym(namesAndValues[i]) + namesAndValues[i+1]
+	}
+	return s
+}
+
+// testSaveDocuments returns the header and contents of a save file with
+// some of what Game.exe writes
+func testSaveDocuments() (string, string) {
+	header := rbHash(
+		rbSym("characters"), rbArray(rbArray(rbStr("Actor1"), rbInt(0))),
+		rbSym("playtime_s"), rbInt(4000),
+	)
+	// comments are the index of the object for object links
+	contents := rbHash( // 0
+		rbSym("switches"), rbObject("Game_Switches", "@data", rbArray("0", "T", "F")), // 1, 2
+		rbSym("variables"), rbObject("Game_Variables", "@data", rbArray("0", rbInt(5))), // 3, 4
+		rbSym("self_switches"), rbObject("Game_SelfSwitches", "@data", rbHash( // 5, 6
+			rbArray(rbInt(1), rbInt(2), rbStr("A")), "T", // 7, 8
+		)),
+		rbSym("actors"), rbObject("Game_Actors", "@data", rbArray("0", // 9, 10
+			rbObject("Game_Actor", // 11
+				"@actor_id", rbInt(1),
+				"@name", rbStr("Eric"), // 12
+				"@level", rbInt(3),
+				"@exp", rbHash(rbInt(1), rbInt(300)), // 13
+				"@equips", rbArray(rbObject("Game_BaseItem", "@class", rbClass("RPG::Weapon"), "@item_id", rbInt(1))), // 14, 15, 16
+				"@tp", rbFloat("12.5"), // 17
+				"@result", rbObject("Game_ActionResult", "@battler", rbLink(11)), // 18
+			),
+		)),
+		rbSym("party"), rbObject("Game_Party", "@gold", rbInt(500), "@actors", rbArray(rbInt(1)), "@items", rbHash(rbInt(1), rbInt(2))), // 19, 20, 21
+		rbSym("map"), rbObject("Game_Map", // 22
+			"@map_id", rbInt(1),
+			"@events", rbHash(rbInt(1), rbObject("Game_Event", // 23, 24
+				"@id", rbInt(1),
+				"@x", rbInt(3),
+				"@event", rbObject("RPG::Event", "@id", rbInt(1), "@pages", rbArray(rbObject("RPG::Event::Page", "@trigger", rbInt(3)))), // 25, 26, 27
+				"@page", rbLink(27),
+			)),
+			"@interpreter", "U"+rbSym("Game_Interpreter")+rbArray( // 28, 29
+				rbInt(0), rbInt(1), rbInt(2),
+				rbArray(rbObject("RPG::EventCommand", "@code", rbInt(101), "@indent", rbInt(0), "@parameters", rbArray())), // 30, 31, 32
+				rbInt(1),
+				rbHash(rbInt(0), "T"), // 33
+			),
+		),
+		rbSym("player"), rbObject("Game_Player", "@x", rbInt(5), "@y", rbInt(6), "@vehicle_type", rbSym("walk"), "@script_field", rbInt(1)),
+		rbSym("script_data"), rbInt(1),
+	)
+	return header, contents
+}
+
+func testSaveData() []byte {
+	header, contents := testSaveDocuments()
+	return []byte("\x04\x08" + header + "\x04\x08" + contents)
+}
+
+func TestLoadTruncatedSave(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Save01.rvdata2": &fstest.MapFile{Data: []byte("\x04\x08{\x06:\x0bfoo")},
+	}
+	if _, err := LoadSave(fsys, 1); err == nil {
+		t.Fatal("expected error for truncated save")
+	}
+	if _, err := LoadSaveHeader(fsys, 1); err == nil {
+		t.Fatal("expected error for truncated save header")
+	}
+	if _, err := EditSave(fsys, 1); err == nil {
+		t.Fatal("expected error for truncated save")
+	}
+
+	// cut the save off at every point after the header
+	data := testSaveData()
+	for size := len(data) - 1; size > 0; size-- {
+		fsys["Save01.rvdata2"] = &fstest.MapFile{Data: data[:size]}
+		if _, err := LoadSave(fsys, 1); err == nil {
+			t.Fatalf("expected error for save truncated to %d bytes", size)
+		}
+		if _, err := EditSave(fsys, 1); err == nil {
+			t.Fatalf("expected error for save truncated to %d bytes", size)
+		}
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Save01.rvdata2": &fstest.MapFile{Data: testSaveData()},
+	}
+	save, err := LoadSave(fsys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (SaveHeader{
+		Characters:      []SaveCharacter{{CharacterName: "Actor1", CharacterIndex: 0}},
+		PlaytimeSeconds: 4000,
+	}); !reflect.DeepEqual(save.Header, expected) {
+		t.Fatalf("expected header %+v but got %+v", expected, save.Header)
+	}
+	if !save.Switches.Get(1) || save.Switches.Get(2) || save.Switches.Get(100) {
+		t.Fatalf("unexpected switches: %v", save.Switches.Data)
+	}
+	if save.Variables.Get(1) != 5 {
+		t.Fatalf("unexpected variables: %v", save.Variables.Data)
+	}
+	if !save.SelfSwitches.Get(1, 2, "A") || save.SelfSwitches.Get(1, 2, "B") {
+		t.Fatalf("unexpected self switches: %v", save.SelfSwitches.Data)
+	}
+	actor := save.Actors.Get(1)
+	if actor == nil || actor.Name != "Eric" || actor.Level != 3 || actor.Exp[1] != 300 || actor.TP != 12.5 ||
+		!reflect.DeepEqual(actor.Equips, []GameBaseItem{{Class: "RPG::Weapon", ItemID: 1}}) {
+		t.Fatalf("unexpected actor: %+v", actor)
+	}
+	if save.Party.Gold != 500 || save.Party.Items[1] != 2 {
+		t.Fatalf("unexpected party: %+v", save.Party)
+	}
+	event := save.Map.Events[1]
+	if event == nil || event.X != 3 || event.Page == nil || event.Page.Trigger != 3 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if interpreter := save.Map.Interpreter; interpreter.MapID != 1 || interpreter.EventID != 2 ||
+		len(interpreter.List) != 1 || interpreter.List[0].Code != 101 || interpreter.Index != 1 || interpreter.Branch[0] != true {
+		t.Fatalf("unexpected interpreter: %+v", interpreter)
+	}
+	if save.Player.X != 5 || save.Player.Y != 6 || save.Player.VehicleType != "walk" {
+		t.Fatalf("unexpected player: %+v", save.Player)
+	}
+
+	if header, err := LoadSaveHeader(fsys, 1); err != nil || !reflect.DeepEqual(header, save.Header) {
+		t.Fatalf("expected LoadSaveHeader to match LoadSave but got %+v, %v", header, err)
+	}
+	header, _ := testSaveDocuments()
+	fsys["Save03.rvdata2"] = &fstest.MapFile{Data: []byte("\x04\x08" + header)}
+	if _, err := LoadSave(fsys, 3); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF for save without contents but got %v", err)
+	}
+	if _, err := LoadSave(fsys, 2); !errors.Is(err, ErrSaveNotFound) {
+		t.Fatalf("expected ErrSaveNotFound but got %v", err)
+	}
+	if name := SaveFilename(16); name != "Save16.rvdata2" {
+		t.Fatalf("unexpected save filename: %s", name)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	// data files written by RPG Maker VX Ace should be written again
+	// byte-for-byte, including floats, shared objects and symbol links
+	for _, name := range []string{"Actors", "MapInfos", "Map001", "System", "Tilesets"} {
+		t.Run(name, func(t *testing.T) {
+			input, err := readEntireRMDataFile(name + ".rvdata2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := rubymarshal.NewDecoder(input)
+			d.PreserveObjects()
+			var v interface{}
+			if err := d.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := rubymarshal.NewEncoder(&buf).Encode(v); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), input) {
+				t.Fatalf("expected encoded data to match %s.rvdata2", name)
+			}
+		})
+	}
+}
+
+func TestSaveEditor(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Save01.rvdata2": &fstest.MapFile{Data: testSaveData()},
+	}
+	editor, err := EditSave(fsys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged, err := editor.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := LoadSave(fsys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unchanged, original) {
+		t.Fatalf("expected save to be unchanged after writing\nexpected: %+v\ngot: %+v", original, unchanged)
+	}
+
+	for _, err := range []error{
+		editor.SetSwitch(1, false),
+		editor.SetSwitch(10, true),
+		editor.SetVariable(1, 42),
+		editor.SetSelfSwitch(1, 2, "A", false),
+		editor.SetSelfSwitch(3, 4, "B", true),
+		editor.SetGold(1 << 30),
+		editor.SetItemCount(1, 0),
+		editor.SetItemCount(3, 200),
+		editor.SetPartyMembers([]int{1, 2}),
+		editor.TransferPlayer(2, 8, 5, 2),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	save, err := editor.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if save.Switches.Get(1) || !save.Switches.Get(10) || save.Variables.Get(1) != 42 {
+		t.Fatalf("unexpected switches and variables: %v, %v", save.Switches.Data, save.Variables.Data)
+	}
+	if save.SelfSwitches.Get(1, 2, "A") || !save.SelfSwitches.Get(3, 4, "B") {
+		t.Fatalf("unexpected self switches: %v", save.SelfSwitches.Data)
+	}
+	if save.Party.Gold != 99999999 || len(save.Party.Items) != 1 || save.Party.Items[3] != 99 ||
+		!reflect.DeepEqual(save.Party.Actors, []int{1, 2}) {
+		t.Fatalf("unexpected party: %+v", save.Party)
+	}
+	if !save.Player.Transferring || save.Player.NewMapID != 2 || save.Player.NewX != 8 || save.Player.NewY != 5 {
+		t.Fatalf("unexpected player: %+v", save.Player)
+	}
+	if event := save.Map.Events[1]; event == nil || event.Page == nil || event.Page.Trigger != 3 {
+		t.Fatalf("expected event page to survive writing but got %+v", event)
+	}
+
+	// the action result refers back to the actor, which must still be
+	// the same object after writing
+	bytesData, err := editor.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err := NewSaveEditor(bytesData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actors, _ := edited.object("actors")
+	data, _ := actors.Get("@data")
+	actor := data.(*rubymarshal.Array).Elements[1].(*rubymarshal.Object)
+	result, _ := actor.Get("@result")
+	if battler, _ := result.(*rubymarshal.Object).Get("@battler"); battler != actor {
+		t.Fatalf("expected @battler to link to the actor but got %#v", battler)
+	}
+}
+
+func TestSaveEditorRoundTrip(t *testing.T) {
+	// Save01.rvdata2 is laid out like a save written by Game.exe with the
+	// default scripts, with the screens, interpreters, map data, vehicles
+	// and followers that the hand-built test save leaves out. An unedited
+	// save must be written back byte-for-byte.
+	input, err := os.ReadFile("testdata/Save01.rvdata2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor, err := NewSaveEditor(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := editor.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Fatalf("expected unedited save to match Save01.rvdata2")
+	}
+
+	save, err := LoadSave(os.DirFS("testdata"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(save.Header.Characters) != 1 || save.Header.PlaytimeSeconds != 754 {
+		t.Fatalf("unexpected header: %+v", save.Header)
+	}
+	if actor := save.Actors.Get(1); actor == nil || actor.Name != "Eric" || actor.TP != 13.5 {
+		t.Fatalf("unexpected actor: %+v", actor)
+	}
+	if save.Party.Gold != 720 || save.Player.X != 5 || save.Player.Y != 6 {
+		t.Fatalf("unexpected party or player: %+v, %+v", save.Party, save.Player)
+	}
+}
+
+func TestActivePage(t *testing.T) {
+	save, err := decodeSave(testSaveData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// switch 1 is on, variable 1 is 5, self switch A of event 2 on map 1 is on,
+	// the party has 2 of item 1 and actor 1
+	for _, tc := range []struct {
+		name      string
+		eventID   int
+		condition MapPageCondition
+		met       bool
+	}{
+		{"no condition", 2, MapPageCondition{}, true},
+		{"switch", 2, MapPageCondition{Switch1Valid: true, Switch1ID: 1}, true},
+		{"switch off", 2, MapPageCondition{Switch1Valid: true, Switch1ID: 1, Switch2Valid: true, Switch2ID: 2}, false},
+		{"variable", 2, MapPageCondition{VariableValid: true, VariableID: 1, VariableValue: 5}, true},
+		{"variable less", 2, MapPageCondition{VariableValid: true, VariableID: 1, VariableValue: 6}, false},
+		{"self switch", 2, MapPageCondition{SelfSwitchValid: true, SelfSwitchCH: "A"}, true},
+		{"self switch other event", 3, MapPageCondition{SelfSwitchValid: true, SelfSwitchCH: "A"}, false},
+		{"self switch off", 2, MapPageCondition{SelfSwitchValid: true, SelfSwitchCH: "B"}, false},
+		{"item", 2, MapPageCondition{ItemValid: true, ItemID: 1}, true},
+		{"item missing", 2, MapPageCondition{ItemValid: true, ItemID: 2}, false},
+		{"actor", 2, MapPageCondition{ActorValid: true, ActorID: 1}, true},
+		{"actor missing", 2, MapPageCondition{ActorValid: true, ActorID: 2}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			event := MapEvent{ID: tc.eventID, Pages: []MapEventPage{{Condition: tc.condition}}}
+			if met := event.ActivePage(save) != nil; met != tc.met {
+				t.Fatalf("expected conditions met to be %v", tc.met)
+			}
+		})
+	}
+
+	// the last page with its conditions met is used
+	event := MapEvent{ID: 2, Pages: []MapEventPage{
+		{Trigger: 0},
+		{Trigger: 1, Condition: MapPageCondition{SelfSwitchValid: true, SelfSwitchCH: "A"}},
+		{Trigger: 2, Condition: MapPageCondition{Switch1Valid: true, Switch1ID: 2}},
+	}}
+	if page := event.ActivePage(save); page == nil || page.Trigger != 1 {
+		t.Fatalf("expected second page but got %+v", page)
+	}
+	event.Pages = event.Pages[2:]
+	if i := event.ActivePageIndex(save); i != -1 {
+		t.Fatalf("expected no active page but got %d", i)
+	}
+
+	// self switches belong to the event's map rather than the player's map
+	state := NewMemoryState(nil)
+	state.TransferPlayer(3, 0, 0, 0)
+	state.SetSelfSwitch(1, 2, "A", true)
+	event = MapEvent{ID: 2, Pages: []MapEventPage{
+		{Trigger: 0},
+		{Trigger: 1, Condition: MapPageCondition{SelfSwitchValid: true, SelfSwitchCH: "A"}, List: []EventCommand{
+			{Code: commandControlSelfSwitch, Parameters: []interface{}{"B", 0}},
+		}},
+	}}
+	if i := event.ActivePageIndex(state); i != 0 {
+		t.Fatalf("expected first page on the player's map but got %d", i)
+	}
+	if page := event.ActivePageOnMap(1, state); page == nil || page.Trigger != 1 {
+		t.Fatalf("expected second page on map 1 but got %+v", page)
+	}
+	if _, err := NewInterpreter(state).RunEventOnMap(1, &event); err != nil {
+		t.Fatal(err)
+	}
+	if !state.SelfSwitch(1, 2, "B") || state.SelfSwitch(3, 2, "B") {
+		t.Fatal("expected self switch to be set on the event's map")
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	cmd := func(indent, code int, params ...interface{}) EventCommand {
+		return EventCommand{Code: code, Indent: indent, Parameters: params}
+	}
+	state := NewMemoryState(nil)
+	state.TransferPlayer(1, 0, 0, 0)
+	interpreter := NewInterpreter(state)
+	interpreter.CommonEvents = map[int][]EventCommand{
+		// Control Self Switch A = ON
+		3: {cmd(0, 123, "A", 0), cmd(0, 0)},
+	}
+	interpreter.Choose = func(choices []string, cancelType int) int {
+		if !reflect.DeepEqual(choices, []string{"Yes", "No"}) || cancelType != 2 {
+			t.Fatalf("unexpected choices: %v, %d", choices, cancelType)
+		}
+		return 1
+	}
+	trace, err := interpreter.Run([]EventCommand{
+		cmd(0, 101, "", 0, 0, 2),
+		cmd(0, 401, "Hello"),
+		cmd(0, 401, "there"),
+		cmd(0, 102, []interface{}{"Yes", "No"}, 2),
+		cmd(0, 402, 0, "Yes"),
+		cmd(1, 121, 1, 1, 0),
+		cmd(1, 0),
+		cmd(0, 402, 1, "No"),
+		cmd(1, 121, 2, 3, 0),
+		cmd(1, 0),
+		cmd(0, 404),
+		// loop adding 2 to variable 1 until it's 6 or more
+		cmd(0, 112),
+		cmd(1, 122, 1, 1, 1, 0, 2),
+		cmd(1, 111, 1, 1, 0, 6, 1),
+		cmd(2, 113),
+		cmd(2, 0),
+		cmd(1, 412),
+		cmd(1, 0),
+		cmd(0, 413),
+		// variable 2 = variable 1, then -7 / 2 rounds down like Ruby
+		cmd(0, 122, 2, 2, 0, 1, 1),
+		cmd(0, 122, 3, 3, 0, 0, -7),
+		cmd(0, 122, 3, 3, 4, 0, 2),
+		cmd(0, 111, 0, 2, 0),
+		cmd(1, 125, 0, 0, 1<<30),
+		cmd(1, 126, 1, 0, 0, 3),
+		cmd(1, 129, 1, 0, false),
+		cmd(1, 129, 2, 0, false),
+		cmd(1, 129, 1, 1, false),
+		cmd(1, 0),
+		cmd(0, 411),
+		cmd(1, 125, 0, 0, 5),
+		cmd(1, 0),
+		cmd(0, 412),
+		cmd(0, 119, "end"),
+		cmd(0, 121, 10, 10, 0),
+		cmd(0, 118, "end"),
+		cmd(0, 117, 3),
+		cmd(0, 355, "p 1"),
+		cmd(0, 655, "p 2"),
+		cmd(0, 122, 4, 4, 0, 0, 2),
+		cmd(0, 201, 1, 4, 1, 2, 8, 0),
+		cmd(0, 115),
+		cmd(0, 121, 11, 11, 0),
+		cmd(0, 0),
+	}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Switch(1) || !state.Switch(2) || !state.Switch(3) || state.Switch(10) || state.Switch(11) {
+		t.Fatalf("unexpected switches: %v", state.switches)
+	}
+	if state.Variable(1) != 6 || state.Variable(2) != 6 || state.Variable(3) != -4 {
+		t.Fatalf("unexpected variables: %v", state.variables)
+	}
+	if state.Gold() != 99999999 || state.ItemCount(1) != 3 || !reflect.DeepEqual(state.PartyMembers(), []int{2}) {
+		t.Fatalf("unexpected party: %d, %v, %v", state.Gold(), state.items, state.party)
+	}
+	if !state.SelfSwitch(1, 5, "A") {
+		t.Fatal("expected common event to set self switch of event 5")
+	}
+	if x, y, direction := state.Position(); state.MapID() != 2 || x != 6 || y != 6 || direction != 8 {
+		t.Fatalf("unexpected position: map %d, %d, %d, %d", state.MapID(), x, y, direction)
+	}
+	expected := []TraceEvent{
+		{EventID: 5, Command: cmd(0, 101, "", 0, 0, 2), Text: []string{"Hello", "there"}},
+		{EventID: 5, Command: cmd(0, 102, []interface{}{"Yes", "No"}, 2), Text: []string{"Yes", "No"}},
+		{EventID: 5, Command: cmd(0, 355, "p 1"), Text: []string{"p 1", "p 2"}},
+	}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Fatalf("unexpected trace\nexpected: %+v\ngot: %+v", expected, trace)
+	}
+
+	// lost battles end the game unless they can be lost
+	interpreter.Battle = func(troopID int) int {
+		return 2
+	}
+	if _, err := interpreter.Run([]EventCommand{
+		cmd(0, 301, 0, 4, false, true),
+		cmd(0, 601),
+		cmd(1, 121, 20, 20, 0),
+		cmd(0, 603),
+		cmd(1, 121, 21, 21, 0),
+		cmd(0, 604),
+		cmd(0, 301, 0, 4, false, false),
+	}, 5); !errors.Is(err, ErrGameOver) {
+		t.Fatalf("expected ErrGameOver but got %v", err)
+	}
+	if state.Switch(20) || !state.Switch(21) {
+		t.Fatal("expected lose branch to run")
+	}
+
+	interpreter.MaxSteps = 100
+	if _, err := interpreter.Run([]EventCommand{cmd(0, 112), cmd(1, 0), cmd(0, 413)}, 5); !errors.Is(err, ErrStepLimit) {
+		t.Fatalf("expected ErrStepLimit but got %v", err)
+	}
+	if _, err := interpreter.Run([]EventCommand{cmd(0, 111, 12, "$game_temp")}, 5); !errors.Is(err, ErrUnsupportedCommand) {
+		t.Fatalf("expected ErrUnsupportedCommand but got %v", err)
+	}
+
+	// events start from the state in a save
+	save, err := decodeSave(testSaveData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state = NewMemoryState(save)
+	event := MapEvent{ID: 2, Pages: []MapEventPage{
+		{List: []EventCommand{cmd(0, 121, 5, 5, 0)}},
+		{List: []EventCommand{cmd(0, 122, 1, 1, 1, 0, 1)}, Condition: MapPageCondition{SelfSwitchValid: true, SelfSwitchCH: "A"}},
+	}}
+	if _, err := NewInterpreter(state).RunEvent(&event); err != nil {
+		t.Fatal(err)
+	}
+	if state.Switch(5) || state.Variable(1) != 6 || state.ItemCount(1) != 2 {
+		t.Fatalf("expected second page to run but got %v, %v", state.switches, state.variables)
+	}
+}
+
+func TestCrossReference(t *testing.T) {
+	cmd := func(code int, params ...interface{}) EventCommand {
+		return EventCommand{Code: code, Parameters: params}
+	}
+	index := NewCrossReference()
+	index.AddMap(3, &Map{Events: map[int]MapEvent{
+		2: {ID: 2, Pages: []MapEventPage{
+			{
+				Condition: MapPageCondition{Switch1Valid: true, Switch1ID: 42, SelfSwitchValid: true, SelfSwitchCH: "A"},
+				List: []EventCommand{
+					cmd(111, 1, 7, 1, 8, 0),
+					cmd(121, 42, 43, 0),
+					cmd(123, "B", 0),
+					cmd(205, -1, map[string]interface{}{
+						"@list": []interface{}{
+							map[string]interface{}{"@code": 28, "@parameters": []interface{}{44}},
+						},
+					}),
+				},
+			},
+			{
+				MoveType: 3,
+				MoveRoute: MoveRoute{List: []MoveRouteItem{
+					{Code: 27, Parameters: []interface{}{42}},
+				}},
+			},
+		}},
+	}})
+	index.AddCommonEvents([]CommonEvent{
+		{},
+		{ID: 1, Trigger: 2, SwitchID: 42, List: []EventCommand{cmd(122, 7, 7, 1, 1, 9), cmd(123, "A", 1)}},
+	})
+	index.AddTroops([]Troop{
+		{},
+		{ID: 4, Pages: []TroopPage{
+			{Condition: TroopPageCondition{SwitchValid: true, SwitchID: 43}, List: []EventCommand{cmd(123, "A", 0)}},
+		}},
+	})
+
+	expected := References{
+		{Kind: ReferenceRead, MapID: 3, EventID: 2, Page: 0, Command: -1},
+		{Kind: ReferenceWrite, MapID: 3, EventID: 2, Page: 0, Command: 1, Code: 121},
+		{Kind: ReferenceWrite, MapID: 3, EventID: 2, Page: 1, Command: -1, MoveRoute: true},
+		{Kind: ReferenceRead, CommonEventID: 1, Command: -1},
+	}
+	if got := index.Switch(42); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected switch references\nexpected: %+v\ngot: %+v", expected, got)
+	}
+	if writes := index.Switch(42).Writes(); len(writes) != 2 || writes[1].Page != 1 {
+		t.Fatalf("unexpected switch writes: %+v", writes)
+	}
+	if got := index.Switch(43); len(got) != 2 || got[1].TroopID != 4 {
+		t.Fatalf("unexpected switch 43 references: %+v", got)
+	}
+	if got := index.Switch(44); len(got) != 1 || got[0].Code != 205 || !got[0].MoveRoute {
+		t.Fatalf("unexpected switch 44 references: %+v", got)
+	}
+	if got := index.Variable(7); len(got.Reads()) != 1 || len(got.Writes()) != 1 || got.Writes()[0].CommonEventID != 1 {
+		t.Fatalf("unexpected variable 7 references: %+v", got)
+	}
+	if got := index.Variable(9).Reads(); len(got) != 1 || got[0].Code != 122 {
+		t.Fatalf("unexpected variable 9 references: %+v", got)
+	}
+	// self switches from troops are ignored and common events apply to any event
+	if got := index.SelfSwitch(3, 2, "A"); len(got) != 2 || got[0].Kind != ReferenceRead || got[1].CommonEventID != 1 {
+		t.Fatalf("unexpected self switch references: %+v", got)
+	}
+	if got := index.SelfSwitch(3, 1, "B"); len(got) != 0 {
+		t.Fatalf("expected no references for another event but got %+v", got)
+	}
+
+	b, err := json.Marshal(index.Switches[44])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[{"kind":"write","mapId":3,"eventId":2,"page":0,"command":3,"code":205,"moveRoute":true}]`; string(b) != expected {
+		t.Fatalf("unexpected json\nexpected: %s\ngot: %s", expected, b)
+	}
+
+	// the test project has no common events or troops
+	project, err := LoadProjectWithOptions(context.Background(), &osFS{dir: testDataDirectory}, LoadOptions{
+		AllowMissing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.CrossReference(); err != nil {
+		t.Fatal(err)
+	}
+	project, err = LoadProjectWithOptions(context.Background(), &osFS{dir: testDataDirectory}, LoadOptions{
+		Troops: LoadSkip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := project.CrossReference(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist but got %v", err)
+	}
+	if _, err := project.GetTroops(); !errors.Is(err, ErrNotLoaded) {
+		t.Fatalf("expected ErrNotLoaded but got %v", err)
+	}
+}
+
+func TestLoadItems(t *testing.T) {
+	baseItem := func(id int, name string) []string {
+		return []string{
+			"@id", rbInt(id),
+			"@name", rbStr(name),
+			"@icon_index", rbInt(192),
+			"@description", rbStr(""),
+			"@features", rbArray(),
+			"@note", rbStr(""),
+		}
+	}
+	item := append(baseItem(1, "Potion"),
+		"@scope", rbInt(7),
+		"@occasion", rbInt(0),
+		"@speed", rbInt(0),
+		"@animation_id", rbInt(41),
+		"@success_rate", rbInt(100),
+		"@repeats", rbInt(1),
+		"@tp_gain", rbInt(0),
+		"@hit_type", rbInt(0),
+		"@damage", rbObject("RPG::UsableItem::Damage", "@type", rbInt(0), "@element_id", rbInt(0), "@formula", rbStr("0"), "@variance", rbInt(20), "@critical", "F"),
+		"@effects", rbArray(rbObject("RPG::UsableItem::Effect", "@code", rbInt(11), "@data_id", rbInt(0), "@value1", rbInt(0), "@value2", rbFloat("500"))),
+		"@itype_id", rbInt(1),
+		"@price", rbInt(50),
+		"@consumable", "T",
+	)
+	weapon := append(baseItem(1, "Hand Axe"),
+		"@price", rbInt(500),
+		"@etype_id", rbInt(0),
+		"@params", rbArray(rbInt(0), rbInt(0), rbInt(15), rbInt(0), rbInt(0), rbInt(0), rbInt(0), rbInt(0)),
+		"@wtype_id", rbInt(1),
+		"@animation_id", rbInt(6),
+	)
+	fsys := fstest.MapFS{
+		"Game.rvproj2":         &fstest.MapFile{Data: []byte("RPGVXAce 1.02")},
+		"Data/Items.rvdata2":   &fstest.MapFile{Data: []byte("\x04\x08" + rbArray("0", rbObject("RPG::Item", item...)))},
+		"Data/Weapons.rvdata2": &fstest.MapFile{Data: []byte("\x04\x08" + rbArray("0", rbObject("RPG::Weapon", weapon...)))},
+	}
+	project, err := LoadProjectWithOptions(context.Background(), fsys, LoadOptions{
+		AllowMissing: true,
+		Items:        LoadEager,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := project.GetItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[1].ID != 1 || items[1].Name != "Potion" || items[1].Damage.Variance != 20 ||
+		items[1].Effects[0].Value2 != 500 || !items[1].Consumable {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+	weapons, err := project.GetWeapons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weapons) != 2 || weapons[1].Name != "Hand Axe" || weapons[1].Params[2] != 15 || weapons[1].AnimationID != 6 {
+		t.Fatalf("unexpected weapons: %+v", weapons)
+	}
+	if armors, err := project.GetArmors(); err != nil || len(armors) != 0 {
+		t.Fatalf("expected missing armors to be empty: %v, %v", armors, err)
+	}
+}
+
+func TestAssetResolver(t *testing.T) {
+	project := fstest.MapFS{
+		"Graphics/Characters/Actor1.png":     &fstest.MapFile{Data: []byte("project")},
+		"Graphics/Characters/monster.PNG":    &fstest.MapFile{},
+		"Graphics/Characters/Event/Door.png": &fstest.MapFile{},
+		"Audio/BGM/Town.mid":                 &fstest.MapFile{},
+		"Audio/BGM/Town.ogg":                 &fstest.MapFile{},
+		"Graphics/Faces/Extra/Hero.png":      &fstest.MapFile{},
+	}
+	rtp := fstest.MapFS{
+		"Graphics/Characters/Actor1.png":  &fstest.MapFile{Data: []byte("rtp")},
+		"Graphics/Characters/People1.png": &fstest.MapFile{},
+		"Audio/SE/Cursor1.ogg":            &fstest.MapFile{},
+		"audio/me/Fanfare1.ogg":           &fstest.MapFile{},
+	}
+	resolver := NewAssetResolver(project, rtp)
+	for _, tc := range []struct {
+		kind AssetKind
+		name string
+		path string
+		rtp  int
+	}{
+		{AssetCharacters, "Actor1", "Graphics/Characters/Actor1.png", -1},
+		{AssetCharacters, "Monster", "Graphics/Characters/monster.PNG", -1},
+		{AssetCharacters, "Event/Door", "Graphics/Characters/Event/Door.png", -1},
+		{AssetBGM, "Town", "Audio/BGM/Town.ogg", -1},
+		{AssetCharacters, "People1", "Graphics/Characters/People1.png", 0},
+		{AssetSE, "Cursor1", "Audio/SE/Cursor1.ogg", 0},
+		// directories are matched case-insensitively too
+		{AssetCharacters, "event/door", "Graphics/Characters/Event/Door.png", -1},
+		{AssetFaces, "EXTRA/Hero", "Graphics/Faces/Extra/Hero.png", -1},
+		{AssetME, "Fanfare1", "audio/me/Fanfare1.ogg", 0},
+	} {
+		resolved, err := resolver.Resolve(tc.kind, tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved.Path != tc.path || resolved.RTP != tc.rtp {
+			t.Fatalf("expected %s in %d but got %s in %d", tc.path, tc.rtp, resolved.Path, resolved.RTP)
+		}
+	}
+	f, err := resolver.Open(AssetCharacters, "Actor1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "project" {
+		t.Fatalf("expected project graphic to take priority over the RTP but got %q", data)
+	}
+
+	for _, asset := range []Asset{{AssetCharacters, "Actor2"}, {AssetBGM, "Cursor1"}, {AssetCharacters, "Actor2"}} {
+		if _, err := resolver.Resolve(asset.Kind, asset.Name); !errors.Is(err, ErrAssetNotFound) {
+			t.Fatalf("expected ErrAssetNotFound but got %v", err)
+		}
+	}
+	if _, err := resolver.Resolve(AssetFaces, ""); !errors.Is(err, ErrAssetNotFound) {
+		t.Fatalf("expected ErrAssetNotFound but got %v", err)
+	}
+	if missing := resolver.Missing(); !reflect.DeepEqual(missing, []Asset{{AssetBGM, "Cursor1"}, {AssetCharacters, "Actor2"}}) {
+		t.Fatalf("unexpected missing assets: %v", missing)
+	}
+}
+
+// memoryBundleWriter keeps the files of a bundle in memory
+type memoryBundleWriter map[string]*bytes.Buffer
+
+func (files memoryBundleWriter) Create(name string) (io.Writer, error) {
+	files[name] = &bytes.Buffer{}
+	return files[name], nil
+}
+
+// readRGSS3A decodes an archive written by RGSS3AWriter the same way Game.exe does
+func readRGSS3A(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	u32 := func(offset int) uint32 {
+		return uint32(data[offset]) | uint32(data[offset+1])<<8 | uint32(data[offset+2])<<16 | uint32(data[offset+3])<<24
+	}
+	if !bytes.HasPrefix(data, []byte("RGSSAD\x00\x03")) {
+		t.Fatalf("unexpected header: %q", data[:8])
+	}
+	magic := u32(8)*9 + 3
+	files := make(map[string]string)
+	for offset := 12; ; {
+		fileOffset := u32(offset) ^ magic
+		if fileOffset == 0 {
+			break
+		}
+		size := u32(offset+4) ^ magic
+		fileMagic := u32(offset+8) ^ magic
+		nameLen := int(u32(offset+12) ^ magic)
+		name := append([]byte(nil), data[offset+16:offset+16+nameLen]...)
+		for i := range name {
+			name[i] ^= byte(magic >> (8 * uint(i%4)))
+		}
+		contents := append([]byte(nil), data[fileOffset:fileOffset+size]...)
+		xorRGSS3AData(contents, fileMagic)
+		files[string(name)] = string(contents)
+		offset += 16 + nameLen
+	}
+	return files
+}
+
+// addTestProjectFiles adds the files of the test project to the given files
+func addTestProjectFiles(t *testing.T, project fstest.MapFS) fstest.MapFS {
+	t.Helper()
+	for _, name := range []string{"Game.rvproj2", "Data/Actors.rvdata2", "Data/Map001.rvdata2", "Data/MapInfos.rvdata2", "Data/System.rvdata2", "Data/Tilesets.rvdata2"} {
+		data, err := os.ReadFile(filepath.Join(testDataDirectory, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		project[name] = &fstest.MapFile{Data: data}
+	}
+	return project
+}
+
+// loadTestProjectWith loads the test project with the given files added to it
+func loadTestProjectWith(t *testing.T, project fstest.MapFS) *Project {
+	t.Helper()
+	addTestProjectFiles(t, project)
+	// the test project has no common events, troops, enemies or animations
+	p, err := LoadProjectWithOptions(context.Background(), project, LoadOptions{
+		AllowMissing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAssetUsageAndBundle(t *testing.T) {
+	project := fstest.MapFS{
+		"Graphics/Characters/Her.png": &fstest.MapFile{Data: []byte("her")},
+		"Graphics/Faces/Unused.png":   &fstest.MapFile{},
+		"Audio/BGM/Unused.ogg":        &fstest.MapFile{},
+		"Save01.rvdata2":              &fstest.MapFile{},
+		".git/HEAD":                   &fstest.MapFile{},
+	}
+	p := loadTestProjectWith(t, project)
+
+	usage, err := p.AssetUsage(AssetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(usage.Unused, []string{"Audio/BGM/Unused.ogg", "Graphics/Faces/Unused.png"}) {
+		t.Fatalf("unexpected unused files: %v", usage.Unused)
+	}
+	for _, asset := range []Asset{{AssetCharacters, "Her"}, {AssetFaces, "Actor4"}, {AssetSystem, "Window"}, {AssetTilesets, "World_A1"}} {
+		found := false
+		for _, referenced := range usage.Referenced {
+			found = found || referenced == asset
+		}
+		if !found {
+			t.Fatalf("expected %s to be referenced", asset)
+		}
+	}
+	if len(usage.Missing) == 0 {
+		t.Fatal("expected assets only in the RTP to be missing without it")
+	}
+	if err := p.WriteBundle(memoryBundleWriter{}, BundleOptions{}); !errors.Is(err, ErrAssetNotFound) {
+		t.Fatalf("expected ErrAssetNotFound but got %v", err)
+	}
+
+	// put everything else in the RTP along with an asset that isn't used
+	rtp := fstest.MapFS{
+		"Graphics/Faces/Actor1.png": &fstest.MapFile{},
+	}
+	for _, asset := range usage.Missing {
+		rtp[string(asset.Kind)+"/"+asset.Name+asset.Kind.Extensions()[0]] = &fstest.MapFile{Data: []byte(asset.Name)}
+	}
+	usage, err = p.AssetUsage(AssetOptions{
+		RTP:   []fs.FS{rtp},
+		Extra: []Asset{{AssetBGM, "Unused"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage.Missing) != 0 || len(usage.Resolved) != len(usage.Referenced) {
+		t.Fatalf("unexpected missing assets: %v", usage.Missing)
+	}
+	if !reflect.DeepEqual(usage.Unused, []string{"Graphics/Faces/Unused.png"}) {
+		t.Fatalf("expected extra assets to be used: %v", usage.Unused)
+	}
+
+	files := memoryBundleWriter{}
+	if err := p.WriteBundle(files, BundleOptions{AssetOptions: AssetOptions{RTP: []fs.FS{rtp}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Graphics/Faces/Unused.png", "Audio/BGM/Unused.ogg", "Save01.rvdata2", "Game.rvproj2", ".git/HEAD", "Graphics/Faces/Actor4.png"} {
+		if _, ok := files[name]; ok {
+			t.Fatalf("expected %s to be left out of the bundle", name)
+		}
+	}
+	for _, name := range []string{"Data/Map001.rvdata2", "Graphics/Characters/Her.png"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("expected %s in the bundle", name)
+		}
+	}
+
+	files = memoryBundleWriter{}
+	if err := p.WriteBundle(files, BundleOptions{AssetOptions: AssetOptions{RTP: []fs.FS{rtp}}, IncludeRTP: true, Archive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["Data/Map001.rvdata2"]; ok {
+		t.Fatal("expected data files to be in the archive")
+	}
+	if files["Audio/SE/Save.ogg"].String() != "Save" {
+		t.Fatal("expected audio from the RTP to be next to the archive")
+	}
+	archived := readRGSS3A(t, files[RGSS3ArchiveName].Bytes())
+	if archived["Data\\Map001.rvdata2"] != string(project["Data/Map001.rvdata2"].Data) {
+		t.Fatal("expected map to round trip through the archive")
+	}
+	if archived["Graphics\\Characters\\Her.png"] != "her" || archived["Graphics\\Faces\\Actor4.png"] != "Actor4" {
+		t.Fatalf("expected graphics in the archive: %v", len(archived))
+	}
+	if _, ok := archived["Graphics\\Faces\\Actor1.png"]; ok {
+		t.Fatal("expected unused RTP graphics to be left out")
+	}
+}
+
+func TestExportMV(t *testing.T) {
+	project, err := LoadProjectWithOptions(context.Background(), &osFS{dir: testDataDirectory}, LoadOptions{
+		AllowMissing: true,
+		Troops:       LoadSkip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := memoryBundleWriter{}
+	if err := project.ExportMV(files); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"data/System.json", "data/Actors.json", "data/Tilesets.json", "data/MapInfos.json", "data/Map001.json", "data/CommonEvents.json"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("expected %s to be written", name)
+		}
+	}
+	if _, ok := files["data/Troops.json"]; ok {
+		t.Fatal("expected skipped troops to not be written")
+	}
+	if files["data/CommonEvents.json"].String() != "[\nnull\n]" {
+		t.Fatalf("unexpected empty database: %q", files["data/CommonEvents.json"])
+	}
+
+	var actors []map[string]interface{}
+	if err := json.Unmarshal(files["data/Actors.json"].Bytes(), &actors); err != nil {
+		t.Fatal(err)
+	}
+	if len(actors) != len(project.Actors) || actors[0] != nil {
+		t.Fatalf("expected %d actors with a null 0th entry but got %v", len(project.Actors), actors)
+	}
+	if actors[1]["name"] != project.Actors[1].Name || actors[1]["profile"] != project.Actors[1].Description || actors[1]["traits"] == nil {
+		t.Fatalf("unexpected actor: %v", actors[1])
+	}
+
+	var system struct {
+		EquipTypes []string        `json:"equipTypes"`
+		WindowTone []int           `json:"windowTone"`
+		VictoryME  mvAudio         `json:"victoryMe"`
+		Terms      mvTerms         `json:"terms"`
+		Sounds     []mvAudio       `json:"sounds"`
+		Boat       json.RawMessage `json:"boat"`
+	}
+	if err := json.Unmarshal(files["data/System.json"].Bytes(), &system); err != nil {
+		t.Fatal(err)
+	}
+	if len(system.EquipTypes) != len(project.System.Terms.ETypes)+1 || system.EquipTypes[0] != "" ||
+		len(system.WindowTone) != 4 || system.VictoryME.Name != project.System.BattleEndMusic.Name ||
+		len(system.Terms.Basic) != 10 || len(system.Terms.Params) != 10 || len(system.Terms.Commands) != 26 ||
+		system.Terms.Messages["victory"] == "" || len(system.Sounds) != len(project.System.Sounds) {
+		t.Fatalf("unexpected system: %+v", system)
+	}
+
+	m, err := project.Map(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mvMap struct {
+		Width  int                      `json:"width"`
+		Height int                      `json:"height"`
+		Data   []int                    `json:"data"`
+		Events []map[string]interface{} `json:"events"`
+	}
+	if err := json.Unmarshal(files["data/Map001.json"].Bytes(), &mvMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(mvMap.Data) != m.Width*m.Height*6 {
+		t.Fatalf("expected 6 layers but got %d tiles", len(mvMap.Data))
+	}
+	layer := func(z int) []int {
+		return mvMap.Data[z*m.Width*m.Height : (z+1)*m.Width*m.Height]
+	}
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			i := y*m.Width + x
+			if layer(0)[i] != int(m.Data.Get(x, y, 0)) || layer(1)[i] != int(m.Data.Get(x, y, 1)) ||
+				layer(2)[i] != 0 || layer(3)[i] != int(m.Data.Get(x, y, 2)) ||
+				layer(4)[i] != int(m.ShadowAt(x, y)) || layer(5)[i] != m.RegionAt(x, y) {
+				t.Fatalf("unexpected tile at %d, %d", x, y)
+			}
+		}
+	}
+	for eventID, event := range m.Events {
+		if mvMap.Events[eventID]["name"] != event.Name || mvMap.Events[eventID]["pages"] == nil {
+			t.Fatalf("unexpected event %d: %v", eventID, mvMap.Events[eventID])
+		}
+	}
+	if mvMap.Events[0] != nil {
+		t.Fatal("expected 0th event to be null")
+	}
+}
+
+func TestExportMVUntypedDatabases(t *testing.T) {
+	// a 2D table, ie. the parameters of a class by parameter and level
+	rbTable := func(x, y int, values ...int16) string {
+		var data string
+		for _, n := range []int{2, x, y, 1, len(values)} {
+			data += string([]byte{byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)})
+		}
+		for _, value := range values {
+			data += string([]byte{byte(value), byte(value >> 8)})
+		}
+		return "u" + rbSym("Table") + rbLong(len(data)) + data
+	}
+	equipFix := rbObject("RPG::BaseItem::Feature", "@code", rbInt(53), "@data_id", rbInt(1), "@value", rbInt(0))
+	files := addTestProjectFiles(t, fstest.MapFS{
+		"Data/Classes.rvdata2": &fstest.MapFile{Data: []byte("\x04\x08" + rbArray("0", rbObject("RPG::Class",
+			"@id", rbInt(1), "@features", rbArray(equipFix), "@exp_params", rbArray(rbInt(30)),
+			"@params", rbTable(2, 3, 1, 2, 3, 4, 5, 6),
+		)))},
+		"Data/Skills.rvdata2": &fstest.MapFile{Data: []byte("\x04\x08" + rbArray("0", rbObject("RPG::Skill",
+			"@id", rbInt(1), "@mp_cost", rbInt(5), "@damage", rbObject("RPG::UsableItem::Damage", "@element_id", rbInt(3)),
+		)))},
+		"Data/States.rvdata2": &fstest.MapFile{Data: []byte("\x04\x08" + rbArray("0", rbObject("RPG::State",
+			"@id", rbInt(1), "@min_turns", rbInt(2),
+		)))},
+		"Data/Animations.rvdata2": &fstest.MapFile{Data: []byte("\x04\x08" + rbArray("0", rbObject("RPG::Animation",
+			"@id", rbInt(1), "@animation1_name", rbStr("Attack1"),
+			"@frames", rbArray(rbObject("RPG::Animation::Frame", "@cell_max", rbInt(1), "@cell_data", rbTable(1, 8, 0, 10, 20, 100, 0, 0, 255, 1))),
+		)))},
+	})
+	// common events, troops, items, weapons, armors and enemies don't exist
+	project, err := LoadProjectWithOptions(context.Background(), files, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := memoryBundleWriter{}
+	if err := project.ExportMV(written); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"CommonEvents", "Troops", "Items", "Weapons", "Armors", "Enemies"} {
+		if _, ok := written["data/"+name+".json"]; ok {
+			t.Fatalf("expected missing %s to not be written", name)
+		}
+	}
+	for name, expected := range map[string]string{
+		"Classes":    `[null,{"expParams":[30],"id":1,"params":[[1,3,5],[2,4,6]],"traits":[{"code":53,"dataId":2,"value":0}]}]`,
+		"Skills":     `[null,{"damage":{"elementId":3},"id":1,"mpCost":5}]`,
+		"States":     `[null,{"id":1,"minTurns":2,"motion":0,"overlay":0}]`,
+		"Animations": `[null,{"animation1Name":"Attack1","frames":[[[0,10,20,100,0,0,255,1]]],"id":1}]`,
+	} {
+		var entries interface{}
+		if err := json.Unmarshal(written["data/"+name+".json"].Bytes(), &entries); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if b, _ := json.Marshal(entries); string(b) != expected {
+			t.Fatalf("unexpected %s\nexpected: %s\ngot: %s", name, expected, b)
+		}
+	}
+}
+
+func TestMVEventCommands(t *testing.T) {
+	commands := newMVEventCommands([]EventCommand{
+		{Code: commandShowChoices, Parameters: []interface{}{[]interface{}{"Yes", "No"}, 0}},
+		{Code: commandShowChoices, Parameters: []interface{}{[]interface{}{"Yes", "No"}, 2}},
+		{Code: commandShowChoices, Parameters: []interface{}{[]interface{}{"Yes", "No"}, 3}},
+		{Code: commandPlaySE, Parameters: []interface{}{map[string]interface{}{"@name": "Cursor1", "@volume": 80, "@pitch": 100}}},
+		{Code: 223, Indent: 1, Parameters: []interface{}{&Tone{Red: -68, Green: -68, Gray: 255}, 60, true}},
+		{Code: commandConditionalBranch, Parameters: []interface{}{11, 13}},
+		{Code: commandChangeActorGraphic, Parameters: []interface{}{1, "Actor1", 0, "Actor1", 0}},
+		{Code: commandGetLocationInfo, Parameters: []interface{}{1, 3, 0, 5, 6}},
+		{Code: commandGetLocationInfo, Parameters: []interface{}{1, 4, 0, 5, 6}},
+		{Code: commandGetLocationInfo, Parameters: []interface{}{1, 5, 1, 2, 3}},
+		{Code: commandChangeEquipment, Parameters: []interface{}{1, 0, 3}},
+		{Code: commandChangeEquipment, Parameters: []interface{}{1, 4, 0}},
+	})
+	b, err := json.Marshal(commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"code":102,"indent":0,"parameters":[["Yes","No"],-1,0,2,0]},` +
+		`{"code":102,"indent":0,"parameters":[["Yes","No"],1,0,2,0]},` +
+		`{"code":102,"indent":0,"parameters":[["Yes","No"],-2,0,2,0]},` +
+		`{"code":250,"indent":0,"parameters":[{"name":"Cursor1","pan":0,"pitch":100,"volume":80}]},` +
+		`{"code":223,"indent":1,"parameters":[[-68,-68,0,255],60,true]},` +
+		`{"code":111,"indent":0,"parameters":[11,"ok"]},` +
+		`{"code":322,"indent":0,"parameters":[1,"Actor1",0,"Actor1",0,""]},` +
+		`{"code":285,"indent":0,"parameters":[1,3,0,5,6]},` +
+		`{"code":285,"indent":0,"parameters":[1,5,0,5,6]},` +
+		`{"code":285,"indent":0,"parameters":[1,6,1,2,3]},` +
+		`{"code":319,"indent":0,"parameters":[1,1,3]},` +
+		`{"code":319,"indent":0,"parameters":[1,5,0]}]`
+	if string(b) != expected {
+		t.Fatalf("unexpected commands\nexpected: %s\ngot: %s", expected, b)
+	}
+	if name := mvFieldName("@character_name"); name != "characterName" {
+		t.Fatalf("unexpected field name: %s", name)
+	}
+}
+
+func TestRunJobsRecoversPanic(t *testing.T) {
+	jobs := []func() error{
+		func() error { return nil },
+		func() error { panic(io.ErrUnexpectedEOF) },
+	}
+	if err := runJobs(context.Background(), 2, jobs); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected panic to be returned as an error but got %v", err)
+	}
+	jobs = []func() error{
+		func() error { panic("bad file") },
+	}
+	if err := runJobs(context.Background(), 1, jobs); err == nil || !strings.Contains(err.Error(), "bad file") {
+		t.Fatalf("expected panic to be returned as an error but got %v", err)
+	}
+}
+
+func TestAssetUsageDefaultBattlebacks(t *testing.T) {
+	p := loadTestProjectWith(t, fstest.MapFS{
+		"Graphics/Battlebacks1/Grassland.png": &fstest.MapFile{},
+		"Graphics/Battlebacks2/Forest.png":    &fstest.MapFile{},
+		"Graphics/Battlebacks2/Unused.png":    &fstest.MapFile{},
+	})
+	// only the default scripts refer to Grassland
+	p.System.Battleback1Name = ""
+	p.System.Battleback2Name = ""
+	usage, err := p.AssetUsage(AssetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range usage.Unused {
+		if strings.HasPrefix(path, "Graphics/Battlebacks") && path != "Graphics/Battlebacks2/Unused.png" {
+			t.Fatalf("expected default battleback to be used: %s", path)
+		}
+	}
+	for _, asset := range usage.Missing {
+		if asset.Kind == AssetBattlebacks1 || asset.Kind == AssetBattlebacks2 {
+			t.Fatalf("expected default battlebacks to not be missing: %s", asset)
+		}
+	}
+
+	// default battlebacks in the RTP are copied
+	rtp := fstest.MapFS{
+		"Graphics/Battlebacks1/Wasteland.png": &fstest.MapFile{},
+	}
+	for _, asset := range usage.Missing {
+		rtp[string(asset.Kind)+"/"+asset.Name+asset.Kind.Extensions()[0]] = &fstest.MapFile{}
+	}
+	files := memoryBundleWriter{}
+	if err := p.WriteBundle(files, BundleOptions{AssetOptions: AssetOptions{RTP: []fs.FS{rtp}}, IncludeRTP: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Graphics/Battlebacks1/Grassland.png", "Graphics/Battlebacks2/Forest.png", "Graphics/Battlebacks1/Wasteland.png"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("expected %s in the bundle", name)
+		}
+	}
+	if _, ok := files["Graphics/Battlebacks2/Unused.png"]; ok {
+		t.Fatal("expected unused battleback to be left out of the bundle")
+	}
+}
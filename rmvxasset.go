@@ -0,0 +1,244 @@
+package rmvx
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrAssetNotFound is returned when an asset isn't in the project or any RTP
+var ErrAssetNotFound = errors.New("asset not found")
+
+// AssetKind is the directory of a kind of asset relative to the project
+// directory, ie. "Graphics/Characters"
+type AssetKind string
+
+const (
+	AssetAnimations   AssetKind = "Graphics/Animations"
+	AssetBattlebacks1 AssetKind = "Graphics/Battlebacks1"
+	AssetBattlebacks2 AssetKind = "Graphics/Battlebacks2"
+	AssetBattlers     AssetKind = "Graphics/Battlers"
+	AssetCharacters   AssetKind = "Graphics/Characters"
+	AssetFaces        AssetKind = "Graphics/Faces"
+	AssetParallaxes   AssetKind = "Graphics/Parallaxes"
+	AssetPictures     AssetKind = "Graphics/Pictures"
+	AssetSystem       AssetKind = "Graphics/System"
+	AssetTilesets     AssetKind = "Graphics/Tilesets"
+	AssetTitles1      AssetKind = "Graphics/Titles1"
+	AssetTitles2      AssetKind = "Graphics/Titles2"
+	AssetBGM          AssetKind = "Audio/BGM"
+	AssetBGS          AssetKind = "Audio/BGS"
+	AssetME           AssetKind = "Audio/ME"
+	AssetSE           AssetKind = "Audio/SE"
+	AssetMovies       AssetKind = "Movies"
+)
+
+// Extensions tried for each kind of asset in order, names in data files
+// don't have extensions
+var (
+	graphicExtensions = []string{".png", ".jpg", ".bmp"}
+	audioExtensions   = []string{".ogg", ".m4a", ".wav", ".mid", ".mp3", ".wma"}
+	movieExtensions   = []string{".ogv"}
+)
+
+// Extensions returns the file extensions tried for the kind of asset in order
+func (kind AssetKind) Extensions() []string {
+	switch {
+	case strings.HasPrefix(string(kind), "Graphics/"):
+		return graphicExtensions
+	case strings.HasPrefix(string(kind), "Audio/"):
+		return audioExtensions
+	case kind == AssetMovies:
+		return movieExtensions
+	}
+	return nil
+}
+
+// Asset is an asset referenced by name from a data file, ie. the character
+// graphic "Actor1"
+type Asset struct {
+	Kind AssetKind
+	Name string
+}
+
+func (asset Asset) String() string {
+	return string(asset.Kind) + "/" + asset.Name
+}
+
+// ResolvedAsset is the file an asset was found in
+type ResolvedAsset struct {
+	Asset
+	// Path is the path of the file in FS, ie. "Graphics/Characters/Actor1.png"
+	Path string
+	FS   fs.FS
+	// RTP is the index of the RTP the file is in or -1 if it's in the project
+	RTP int
+}
+
+// AssetResolver finds the files of assets in the project, falling back to
+// each RTP in order like Game.exe.
+//
+// Names are matched case-insensitively as projects are usually made on Windows.
+// It's safe to use from multiple goroutines.
+type AssetResolver struct {
+	mu      sync.Mutex
+	roots   []*assetRoot
+	missing map[Asset]bool
+}
+
+// assetRoot is the project or an RTP with its directory listings cached
+type assetRoot struct {
+	fsys fs.FS
+	// dirs is the listing of each directory by its path in fsys
+	dirs map[string]*assetDir
+}
+
+// assetDir is a directory listing keyed by lowercase name
+type assetDir struct {
+	files map[string][]string
+	dirs  map[string][]string
+}
+
+// NewAssetResolver returns a resolver for assets in the project directory
+// and the RTP directories, ie. os.DirFS("C:/Program Files (x86)/Common
+// Files/Enterbrain/RGSS3/RPGVXAce")
+func NewAssetResolver(project fs.FS, rtp ...fs.FS) *AssetResolver {
+	resolver := &AssetResolver{
+		missing: make(map[Asset]bool),
+	}
+	for _, fsys := range append([]fs.FS{project}, rtp...) {
+		resolver.roots = append(resolver.roots, &assetRoot{
+			fsys: fsys,
+			dirs: make(map[string]*assetDir),
+		})
+	}
+	return resolver
+}
+
+// AssetResolver returns a resolver for the project's assets that falls back
+// to the given RTP directories
+func (project *Project) AssetResolver(rtp ...fs.FS) *AssetResolver {
+	return NewAssetResolver(project.fs, rtp...)
+}
+
+// Resolve finds the file of the asset, an error wrapping ErrAssetNotFound is
+// returned if it's not in the project or any RTP
+func (resolver *AssetResolver) Resolve(kind AssetKind, name string) (ResolvedAsset, error) {
+	asset := Asset{Kind: kind, Name: name}
+	if name == "" {
+		return ResolvedAsset{}, fmt.Errorf("%w: %s has no name", ErrAssetNotFound, kind)
+	}
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	for i, root := range resolver.roots {
+		if path, ok := root.find(asset); ok {
+			return ResolvedAsset{
+				Asset: asset,
+				Path:  path,
+				FS:    root.fsys,
+				RTP:   i - 1,
+			}, nil
+		}
+	}
+	resolver.missing[asset] = true
+	return ResolvedAsset{}, fmt.Errorf("%w: %s", ErrAssetNotFound, asset)
+}
+
+// Open opens the file of the asset
+func (resolver *AssetResolver) Open(kind AssetKind, name string) (fs.File, error) {
+	resolved, err := resolver.Resolve(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.FS.Open(resolved.Path)
+}
+
+// Missing returns every asset that Resolve couldn't find, sorted by kind and name
+func (resolver *AssetResolver) Missing() []Asset {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	missing := make([]Asset, 0, len(resolver.missing))
+	for asset := range resolver.missing {
+		missing = append(missing, asset)
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Kind != missing[j].Kind {
+			return missing[i].Kind < missing[j].Kind
+		}
+		return missing[i].Name < missing[j].Name
+	})
+	return missing
+}
+
+// find returns the path of the asset, trying each extension in order and
+// preferring a file with the exact name over one that only differs by case
+func (root *assetRoot) find(asset Asset) (string, bool) {
+	if root.fsys == nil {
+		return "", false
+	}
+	// names can have a directory, ie. "Event/Door" for "Graphics/Characters/Event/Door.png"
+	segments := strings.Split(string(asset.Kind)+"/"+asset.Name, "/")
+	base := segments[len(segments)-1]
+	// directories are matched ignoring case too, a case-sensitive file
+	// system can have several that match so each of them is searched
+	dirs := []string{"."}
+	for _, segment := range segments[:len(segments)-1] {
+		var subdirs []string
+		for _, dir := range dirs {
+			for _, name := range matchNames(root.listDir(dir).dirs, segment) {
+				if dir == "." {
+					subdirs = append(subdirs, name)
+				} else {
+					subdirs = append(subdirs, dir+"/"+name)
+				}
+			}
+		}
+		dirs = subdirs
+	}
+	for _, ext := range asset.Kind.Extensions() {
+		for _, dir := range dirs {
+			if names := matchNames(root.listDir(dir).files, base+ext); len(names) > 0 {
+				return dir + "/" + names[0], true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchNames returns the entries with the name ignoring case, with the entry
+// with the exact name first
+func matchNames(entries map[string][]string, name string) []string {
+	names := entries[strings.ToLower(name)]
+	for i, other := range names {
+		if other == name && i > 0 {
+			sorted := append([]string{other}, names[:i]...)
+			return append(sorted, names[i+1:]...)
+		}
+	}
+	return names
+}
+
+func (root *assetRoot) listDir(dir string) *assetDir {
+	if listing, ok := root.dirs[dir]; ok {
+		return listing
+	}
+	listing := &assetDir{
+		files: make(map[string][]string),
+		dirs:  make(map[string][]string),
+	}
+	// a missing directory has no assets in it
+	entries, _ := fs.ReadDir(root.fsys, dir)
+	for _, entry := range entries {
+		key := strings.ToLower(entry.Name())
+		if entry.IsDir() {
+			listing.dirs[key] = append(listing.dirs[key], entry.Name())
+			continue
+		}
+		listing.files[key] = append(listing.files[key], entry.Name())
+	}
+	root.dirs[dir] = listing
+	return listing
+}
@@ -0,0 +1,504 @@
+package rmvx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultSystemGraphics are loaded by the default scripts without being
+// referenced by a data file
+var defaultSystemGraphics = []string{"Balloon", "BattleStart", "GameOver", "Iconset", "Shadow", "Window"}
+
+// defaultBattlebacks are the battlebacks Spriteset_Battle picks by name for
+// maps without one, from the terrain's autotile, on a vehicle or Grassland
+// otherwise. They're only needed if the game has battles, so they're kept
+// if they're found but aren't reported as missing.
+var defaultBattlebacks = []Asset{
+	{AssetBattlebacks1, "Clouds"},
+	{AssetBattlebacks1, "Desert"},
+	{AssetBattlebacks1, "DirtField"},
+	{AssetBattlebacks1, "Grassland"},
+	{AssetBattlebacks1, "Lava1"},
+	{AssetBattlebacks1, "Lava2"},
+	{AssetBattlebacks1, "PoisonSwamp"},
+	{AssetBattlebacks1, "Ship"},
+	{AssetBattlebacks1, "Snowfield"},
+	{AssetBattlebacks1, "Wasteland"},
+	{AssetBattlebacks2, "Cliff"},
+	{AssetBattlebacks2, "Clouds"},
+	{AssetBattlebacks2, "Desert"},
+	{AssetBattlebacks2, "Forest"},
+	{AssetBattlebacks2, "Grassland"},
+	{AssetBattlebacks2, "Lava"},
+	{AssetBattlebacks2, "PoisonSwamp"},
+	{AssetBattlebacks2, "Ship"},
+	{AssetBattlebacks2, "Snowfield"},
+	{AssetBattlebacks2, "Wasteland"},
+}
+
+// AssetOptions controls how referenced assets are found
+type AssetOptions struct {
+	// RTP is searched for assets that aren't in the project, see NewAssetResolver
+	RTP []fs.FS
+	// Extra is assets that are used by scripts, which can't be found by
+	// reading the data files
+	Extra []Asset
+}
+
+// AssetUsage is the assets referenced by the project's data files
+type AssetUsage struct {
+	// Referenced is every referenced asset sorted by kind and name
+	Referenced []Asset
+	// Resolved is the file of each referenced asset that was found, followed
+	// by the default battlebacks that were found
+	Resolved []ResolvedAsset
+	// Missing is every referenced asset that isn't in the project or the RTP
+	Missing []Asset
+	// Unused is the sorted path of every file in the project's Graphics, Audio
+	// and Movies directories that isn't referenced, ie. "Graphics/Faces/Actor4.png"
+	Unused []string
+}
+
+// AssetUsage finds every asset referenced by the databases, maps, events,
+// move routes and System along with the files in the project that aren't used.
+//
+// Assets loaded by scripts by name can't be found, add them to AssetOptions.Extra
+// so they aren't reported as unused.
+func (project *Project) AssetUsage(options AssetOptions) (*AssetUsage, error) {
+	referenced, err := project.referencedAssets()
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range options.Extra {
+		referenced[asset] = true
+	}
+	usage := &AssetUsage{}
+	for asset := range referenced {
+		usage.Referenced = append(usage.Referenced, asset)
+	}
+	sort.Slice(usage.Referenced, func(i, j int) bool {
+		a, b := usage.Referenced[i], usage.Referenced[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	resolver := project.AssetResolver(options.RTP...)
+	used := make(map[string]bool)
+	resolve := func(asset Asset) bool {
+		resolved, err := resolver.Resolve(asset.Kind, asset.Name)
+		if err != nil {
+			return false
+		}
+		usage.Resolved = append(usage.Resolved, resolved)
+		if resolved.RTP == -1 {
+			used[resolved.Path] = true
+		}
+		return true
+	}
+	for _, asset := range usage.Referenced {
+		if !resolve(asset) {
+			usage.Missing = append(usage.Missing, asset)
+		}
+	}
+	for _, asset := range defaultBattlebacks {
+		if !referenced[asset] {
+			resolve(asset)
+		}
+	}
+	for _, dir := range []string{"Graphics", "Audio", "Movies"} {
+		err := fs.WalkDir(project.fs, dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// a project without the directory has nothing unused in it
+				if errors.Is(err, fs.ErrNotExist) && path == dir {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if !entry.IsDir() && !used[path] {
+				usage.Unused = append(usage.Unused, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(usage.Unused)
+	return usage, nil
+}
+
+// referencedAssets returns every asset referenced by the project's data files
+func (project *Project) referencedAssets() (map[Asset]bool, error) {
+	refs := make(assetRefs)
+	for _, name := range defaultSystemGraphics {
+		refs.add(AssetSystem, name)
+	}
+
+	system, err := project.GetSystem()
+	if err != nil {
+		return nil, err
+	}
+	refs.add(AssetTitles1, system.Title1Name)
+	refs.add(AssetTitles2, system.Title2Name)
+	refs.add(AssetBattlebacks1, system.Battleback1Name)
+	refs.add(AssetBattlebacks2, system.Battleback2Name)
+	refs.add(AssetBattlers, system.BattlerName)
+	refs.add(AssetBGM, system.TitleBGM.Name)
+	refs.add(AssetBGM, system.BattleBGM.Name)
+	refs.add(AssetME, system.BattleEndMusic.Name)
+	refs.add(AssetME, system.GameoverMusic.Name)
+	for _, vehicle := range []*SystemVehicle{&system.Boat, &system.Ship, &system.Airship} {
+		refs.add(AssetCharacters, vehicle.CharacterName)
+		refs.add(AssetBGM, vehicle.BGM.Name)
+	}
+	for _, sound := range system.Sounds {
+		refs.add(AssetSE, sound.Name)
+	}
+
+	actors, err := project.GetActors()
+	if err != nil {
+		return nil, err
+	}
+	for _, actor := range actors {
+		refs.add(AssetCharacters, actor.CharacterName)
+		refs.add(AssetFaces, actor.FaceName)
+	}
+	tilesets, err := project.Tilesets()
+	if err != nil {
+		return nil, err
+	}
+	for _, tileset := range tilesets {
+		for _, name := range tileset.TilesetNames {
+			refs.add(AssetTilesets, name)
+		}
+	}
+	commonEvents, err := project.GetCommonEvents()
+	if err != nil {
+		return nil, err
+	}
+	for _, commonEvent := range commonEvents {
+		refs.addCommands(commonEvent.List)
+	}
+	troops, err := project.GetTroops()
+	if err != nil {
+		return nil, err
+	}
+	for _, troop := range troops {
+		for _, page := range troop.Pages {
+			refs.addCommands(page.List)
+		}
+	}
+
+	// enemies and animations don't have Go structs, so only the fields
+	// with assets are read
+	enemies, err := project.loadUntypedDatabase("Enemies")
+	if err != nil {
+		return nil, err
+	}
+	for _, enemy := range enemies {
+		refs.add(AssetBattlers, untypedString(enemy, "@battler_name"))
+	}
+	animations, err := project.loadUntypedDatabase("Animations")
+	if err != nil {
+		return nil, err
+	}
+	for _, animation := range animations {
+		refs.add(AssetAnimations, untypedString(animation, "@animation1_name"))
+		refs.add(AssetAnimations, untypedString(animation, "@animation2_name"))
+		timings, _ := untypedField(animation, "@timings").([]interface{})
+		for _, timing := range timings {
+			refs.add(AssetSE, untypedString(untypedField(timing, "@se"), "@name"))
+		}
+	}
+
+	mapIDs, err := project.listMapFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, mapID := range mapIDs {
+		m, err := project.Map(mapID)
+		if err != nil {
+			return nil, err
+		}
+		refs.add(AssetParallaxes, m.ParallaxName)
+		refs.add(AssetBattlebacks1, m.Battleback1_Name)
+		refs.add(AssetBattlebacks2, m.Battleback2_Name)
+		refs.add(AssetBGM, m.BGM.Name)
+		refs.add(AssetBGS, m.BGS.Name)
+		for _, event := range m.Events {
+			for _, page := range event.Pages {
+				refs.add(AssetCharacters, page.Graphic.CharacterName)
+				for _, item := range page.MoveRoute.List {
+					refs.addMoveRouteItem(item.Code, item.Parameters)
+				}
+				refs.addCommands(page.List)
+			}
+		}
+	}
+	return refs, nil
+}
+
+// loadUntypedDatabase decodes a database file without Go structs, objects
+// are decoded as map[string]interface{}
+func (project *Project) loadUntypedDatabase(assetName string) ([]interface{}, error) {
+	var entries []interface{}
+	if err := loadRMVXDataFile(project, assetName, &entries); err != nil {
+		if project.options.AllowMissing && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return entries, nil
+}
+
+// untypedField returns a field of an object decoded as map[string]interface{}
+func untypedField(obj interface{}, fieldName string) interface{} {
+	fields, _ := obj.(map[string]interface{})
+	return fields[fieldName]
+}
+
+func untypedString(obj interface{}, fieldName string) string {
+	value, _ := untypedField(obj, fieldName).(string)
+	return value
+}
+
+// assetRefs is a set of referenced assets, empty names aren't added
+type assetRefs map[Asset]bool
+
+func (refs assetRefs) add(kind AssetKind, name string) {
+	if name != "" {
+		refs[Asset{Kind: kind, Name: name}] = true
+	}
+}
+
+// Event command and move route codes that reference assets
+const (
+	commandChangeBattleBGM      = 132
+	commandChangeBattleEndME    = 133
+	commandChangeVehicleBGM     = 140
+	commandShowPicture          = 231
+	commandPlayBGM              = 241
+	commandPlayBGS              = 245
+	commandPlayME               = 249
+	commandPlaySE               = 250
+	commandPlayMovie            = 261
+	commandChangeBattleBack     = 283
+	commandChangeParallax       = 284
+	commandChangeActorGraphic   = 322
+	commandChangeVehicleGraphic = 323
+
+	moveRouteChangeGraphic = 41
+	moveRoutePlaySE        = 44
+)
+
+func (refs assetRefs) addCommands(list []EventCommand) {
+	for _, command := range list {
+		params := command.Parameters
+		switch command.Code {
+		case commandShowText:
+			refs.add(AssetFaces, paramString(params, 0))
+		case commandChangeBattleBGM, commandPlayBGM:
+			refs.add(AssetBGM, untypedString(param(params, 0), "@name"))
+		case commandChangeBattleEndME, commandPlayME:
+			refs.add(AssetME, untypedString(param(params, 0), "@name"))
+		case commandChangeVehicleBGM:
+			refs.add(AssetBGM, untypedString(param(params, 1), "@name"))
+		case commandPlayBGS:
+			refs.add(AssetBGS, untypedString(param(params, 0), "@name"))
+		case commandPlaySE:
+			refs.add(AssetSE, untypedString(param(params, 0), "@name"))
+		case commandShowPicture:
+			refs.add(AssetPictures, paramString(params, 1))
+		case commandPlayMovie:
+			refs.add(AssetMovies, paramString(params, 0))
+		case commandChangeBattleBack:
+			refs.add(AssetBattlebacks1, paramString(params, 0))
+			refs.add(AssetBattlebacks2, paramString(params, 1))
+		case commandChangeParallax:
+			refs.add(AssetParallaxes, paramString(params, 0))
+		case commandChangeActorGraphic:
+			refs.add(AssetCharacters, paramString(params, 1))
+			refs.add(AssetFaces, paramString(params, 3))
+		case commandChangeVehicleGraphic:
+			refs.add(AssetCharacters, paramString(params, 1))
+		case commandSetMoveRoute:
+			items, _ := untypedField(param(params, 1), "@list").([]interface{})
+			for _, item := range items {
+				code, _ := untypedField(item, "@code").(int)
+				itemParams, _ := untypedField(item, "@parameters").([]interface{})
+				refs.addMoveRouteItem(code, itemParams)
+			}
+		}
+	}
+}
+
+func (refs assetRefs) addMoveRouteItem(code int, params []interface{}) {
+	switch code {
+	case moveRouteChangeGraphic:
+		refs.add(AssetCharacters, paramString(params, 0))
+	case moveRoutePlaySE:
+		refs.add(AssetSE, untypedString(param(params, 0), "@name"))
+	}
+}
+
+// BundleWriter creates the files of a deployment, *zip.Writer implements it
+type BundleWriter interface {
+	Create(name string) (io.Writer, error)
+}
+
+// BundleOptions controls what WriteBundle writes
+type BundleOptions struct {
+	AssetOptions
+	// IncludeRTP copies the assets found in the RTP into the bundle so that
+	// the game runs without the RTP installed
+	IncludeRTP bool
+	// Archive writes the Data and Graphics directories into Game.rgss3a
+	// like "Compress Game Data" in the editor
+	Archive bool
+}
+
+// WriteBundle writes a copy of the project for release without the files in
+// Graphics, Audio and Movies that aren't used. The project file and save
+// files are left out too.
+//
+// It returns an error wrapping ErrAssetNotFound if a referenced asset is missing.
+func (project *Project) WriteBundle(w BundleWriter, options BundleOptions) error {
+	usage, err := project.AssetUsage(options.AssetOptions)
+	if err != nil {
+		return err
+	}
+	if len(usage.Missing) > 0 {
+		names := make([]string, len(usage.Missing))
+		for i, asset := range usage.Missing {
+			names[i] = asset.String()
+		}
+		return fmt.Errorf("%w: %s", ErrAssetNotFound, strings.Join(names, ", "))
+	}
+	unused := make(map[string]bool, len(usage.Unused))
+	for _, path := range usage.Unused {
+		unused[path] = true
+	}
+
+	var archive *RGSS3AWriter
+	if options.Archive {
+		archive = NewRGSS3AWriter()
+	}
+	// writerFor returns the archive for files Game.exe can load from it
+	writerFor := func(path string) BundleWriter {
+		if archive != nil && (strings.HasPrefix(path, dataDirectory+"/") || strings.HasPrefix(path, "Graphics/")) {
+			return archive
+		}
+		return w
+	}
+
+	err = fs.WalkDir(project.fs, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip hidden files and directories, ie. .git
+		if path != "." && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || unused[path] || isEditorFile(path) {
+			return nil
+		}
+		return copyBundleFile(writerFor(path), project.fs, path)
+	})
+	if err != nil {
+		return err
+	}
+	if options.IncludeRTP {
+		for _, resolved := range usage.Resolved {
+			if resolved.RTP == -1 {
+				continue
+			}
+			if err := copyBundleFile(writerFor(resolved.Path), resolved.FS, resolved.Path); err != nil {
+				return err
+			}
+		}
+	}
+	if archive == nil {
+		return nil
+	}
+	archiveFile, err := w.Create(RGSS3ArchiveName)
+	if err != nil {
+		return err
+	}
+	_, err = archive.WriteTo(archiveFile)
+	return err
+}
+
+// isEditorFile reports whether the file is only used by the editor or is a
+// save file from testing the game
+func isEditorFile(path string) bool {
+	if path == "Game.rvproj2" {
+		return true
+	}
+	if strings.Contains(path, "/") || !strings.HasPrefix(path, saveFilePrefix) || !strings.HasSuffix(path, dataFileExt) {
+		return false
+	}
+	return true
+}
+
+func copyBundleFile(w BundleWriter, fsys fs.FS, path string) error {
+	src, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := w.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// DirBundleWriter writes a bundle into a directory on disk
+type DirBundleWriter struct {
+	dir  string
+	file *os.File
+}
+
+// NewDirBundleWriter returns a writer that creates files in dir, which is
+// created if it doesn't exist
+func NewDirBundleWriter(dir string) *DirBundleWriter {
+	return &DirBundleWriter{dir: dir}
+}
+
+// Create closes the previous file and creates the file, name uses forward slashes
+func (writer *DirBundleWriter) Create(name string) (io.Writer, error) {
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(writer.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer.file = f
+	return f, nil
+}
+
+// Close closes the last file created
+func (writer *DirBundleWriter) Close() error {
+	if writer.file == nil {
+		return nil
+	}
+	err := writer.file.Close()
+	writer.file = nil
+	return err
+}
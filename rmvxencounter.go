@@ -0,0 +1,81 @@
+package rmvx
+
+// EncounterCandidate is a troop that can be encountered on a map tile
+type EncounterCandidate struct {
+	TroopID int
+	// Weight is the sum of the weights of every encounter on the tile with this troop
+	Weight int
+	// Probability is between 0 and 1 and is Weight divided by the total weight
+	// of every encounter on the tile
+	Probability float64
+}
+
+// appliesToRegion follows Game_Player#encounter_ok? where an encounter
+// with an empty region set applies everywhere.
+func (encounter *MapEncounter) appliesToRegion(regionID int) bool {
+	if len(encounter.RegionSet) == 0 {
+		return true
+	}
+	for _, id := range encounter.RegionSet {
+		if id == regionID {
+			return true
+		}
+	}
+	return false
+}
+
+// Encounters returns the troops that can be encountered on the tile and the
+// chance of each being picked when an encounter occurs.
+//
+// Troops are in the order they first appear in EncounterList.
+func (m *Map) Encounters(x, y int) []EncounterCandidate {
+	return m.encountersForRegion(m.RegionAt(x, y))
+}
+
+// EncountersByRegion returns the encounter table for every region ID used on the
+// map, including region 0 if any tiles have no region.
+func (m *Map) EncountersByRegion() map[int][]EncounterCandidate {
+	tables := make(map[int][]EncounterCandidate)
+	for y := 0; y < int(m.Data.Y); y++ {
+		for x := 0; x < int(m.Data.X); x++ {
+			regionID := m.RegionAt(x, y)
+			if _, ok := tables[regionID]; ok {
+				continue
+			}
+			tables[regionID] = m.encountersForRegion(regionID)
+		}
+	}
+	return tables
+}
+
+func (m *Map) encountersForRegion(regionID int) []EncounterCandidate {
+	var (
+		candidates  []EncounterCandidate
+		totalWeight int
+	)
+	for i := range m.EncounterList {
+		encounter := &m.EncounterList[i]
+		if encounter.Weight <= 0 || !encounter.appliesToRegion(regionID) {
+			continue
+		}
+		totalWeight += encounter.Weight
+		found := false
+		for j := range candidates {
+			if candidates[j].TroopID == encounter.TroopID {
+				candidates[j].Weight += encounter.Weight
+				found = true
+				break
+			}
+		}
+		if !found {
+			candidates = append(candidates, EncounterCandidate{
+				TroopID: encounter.TroopID,
+				Weight:  encounter.Weight,
+			})
+		}
+	}
+	for i := range candidates {
+		candidates[i].Probability = float64(candidates[i].Weight) / float64(totalWeight)
+	}
+	return candidates
+}
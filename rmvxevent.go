@@ -0,0 +1,114 @@
+package rmvx
+
+// GameState is the game state that event page conditions are checked against.
+//
+// *Save implements GameState using the state it was saved with.
+type GameState interface {
+	// MapID returns the ID of the map the player is on, ActivePage looks up
+	// self switches of events using this map ID
+	MapID() int
+	Switch(switchID int) bool
+	Variable(variableID int) int
+	// SelfSwitch returns the value of an event's self switch, name is "A", "B", "C" or "D"
+	SelfSwitch(mapID, eventID int, name string) bool
+	// ItemCount returns the number of an item held by the party
+	ItemCount(itemID int) int
+	// HasPartyMember reports whether the actor is in the party
+	HasPartyMember(actorID int) bool
+}
+
+// ActivePage returns the page the event uses in the given state or nil if the
+// conditions of every page are unmet, in which case Game.exe doesn't show the event.
+//
+// The event is taken to be on the map the player is on, use ActivePageOnMap
+// for events on other maps.
+func (event *MapEvent) ActivePage(state GameState) *MapEventPage {
+	return event.ActivePageOnMap(state.MapID(), state)
+}
+
+// ActivePageOnMap is ActivePage for an event on the given map, which is used
+// to look up the event's self switches
+func (event *MapEvent) ActivePageOnMap(mapID int, state GameState) *MapEventPage {
+	i := event.ActivePageIndexOnMap(mapID, state)
+	if i == -1 {
+		return nil
+	}
+	return &event.Pages[i]
+}
+
+// ActivePageIndex returns the index of the page the event uses in the given
+// state or -1 if the conditions of every page are unmet.
+//
+// This follows Game_Event#find_proper_page where the last page with its
+// conditions met is used.
+func (event *MapEvent) ActivePageIndex(state GameState) int {
+	return event.ActivePageIndexOnMap(state.MapID(), state)
+}
+
+// ActivePageIndexOnMap is ActivePageIndex for an event on the given map
+func (event *MapEvent) ActivePageIndexOnMap(mapID int, state GameState) int {
+	for i := len(event.Pages) - 1; i >= 0; i-- {
+		if event.conditionsMet(mapID, &event.Pages[i].Condition, state) {
+			return i
+		}
+	}
+	return -1
+}
+
+// conditionsMet follows Game_Event#conditions_met?
+func (event *MapEvent) conditionsMet(mapID int, condition *MapPageCondition, state GameState) bool {
+	if condition.Switch1Valid && !state.Switch(condition.Switch1ID) {
+		return false
+	}
+	if condition.Switch2Valid && !state.Switch(condition.Switch2ID) {
+		return false
+	}
+	if condition.VariableValid && state.Variable(condition.VariableID) < condition.VariableValue {
+		return false
+	}
+	if condition.SelfSwitchValid && !state.SelfSwitch(mapID, event.ID, condition.SelfSwitchCH) {
+		return false
+	}
+	if condition.ItemValid && state.ItemCount(condition.ItemID) <= 0 {
+		return false
+	}
+	if condition.ActorValid && !state.HasPartyMember(condition.ActorID) {
+		return false
+	}
+	return true
+}
+
+// MapID returns the ID of the map the player is on
+func (save *Save) MapID() int {
+	return save.Map.MapID
+}
+
+// Switch returns the value of the switch
+func (save *Save) Switch(switchID int) bool {
+	return save.Switches.Get(switchID)
+}
+
+// Variable returns the value of the variable or 0 if it's not an integer
+func (save *Save) Variable(variableID int) int {
+	return save.Variables.Get(variableID)
+}
+
+// SelfSwitch returns the value of an event's self switch, name is "A", "B", "C" or "D"
+func (save *Save) SelfSwitch(mapID, eventID int, name string) bool {
+	return save.SelfSwitches.Get(mapID, eventID, name)
+}
+
+// ItemCount returns the number of an item held by the party
+func (save *Save) ItemCount(itemID int) int {
+	return save.Party.Items[itemID]
+}
+
+// HasPartyMember reports whether the actor is in the party
+func (save *Save) HasPartyMember(actorID int) bool {
+	for _, id := range save.Party.Actors {
+		if id == actorID {
+			return true
+		}
+	}
+	return false
+}
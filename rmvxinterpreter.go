@@ -0,0 +1,580 @@
+package rmvx
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+var (
+	// ErrUnsupportedCommand is returned by Interpreter for commands that can't
+	// be run without the game, ie. a Conditional Branch on a script
+	ErrUnsupportedCommand = errors.New("unsupported event command")
+	// ErrStepLimit is returned by Interpreter when it runs more commands than
+	// Interpreter.MaxSteps, usually due to a Loop without a Break Loop
+	ErrStepLimit = errors.New("event step limit exceeded")
+	// ErrGameOver is returned by Interpreter after a Game Over command or when
+	// a battle that can't be lost is lost
+	ErrGameOver = errors.New("game over")
+)
+
+// Event command codes used by the default scripts
+const (
+	commandEnd                 = 0
+	commandShowText            = 101
+	commandShowChoices         = 102
+	commandInputNumber         = 103
+	commandSelectItem          = 104
+	commandShowScrollingText   = 105
+	commandComment             = 108
+	commandConditionalBranch   = 111
+	commandLoop                = 112
+	commandBreakLoop           = 113
+	commandExitEvent           = 115
+	commandCallCommonEvent     = 117
+	commandLabel               = 118
+	commandJumpToLabel         = 119
+	commandControlSwitches     = 121
+	commandControlVariables    = 122
+	commandControlSelfSwitch   = 123
+	commandChangeGold          = 125
+	commandChangeItems         = 126
+	commandChangeWeapons       = 127
+	commandChangeArmors        = 128
+	commandChangePartyMember   = 129
+	commandTransferPlayer      = 201
+	commandSetMoveRoute        = 205
+	commandBattleProcessing    = 301
+	commandGameOver            = 353
+	commandScript              = 355
+	commandTextLine            = 401
+	commandWhenChoice          = 402
+	commandWhenCancel          = 403
+	commandChoicesEnd          = 404
+	commandScrollingTextLine   = 405
+	commandCommentLine         = 408
+	commandElse                = 411
+	commandBranchEnd           = 412
+	commandRepeatAbove         = 413
+	commandIfWin               = 601
+	commandIfEscape            = 602
+	commandIfLose              = 603
+	commandBattleProcessingEnd = 604
+	commandScriptLine          = 655
+)
+
+// maxCommonEventDepth is the depth at which Game_Interpreter stops calling common events
+const maxCommonEventDepth = 100
+
+const defaultMaxSteps = 100000
+
+// Interpreter runs event commands against a game state without graphics,
+// following Game_Interpreter in the default scripts.
+//
+// Commands that only change what's shown, such as Show Text, and commands that
+// need the game running, such as Script, don't change the state and are
+// returned as trace events instead.
+type Interpreter struct {
+	State MutableGameState
+	// CommonEvents is the list of commands of each common event for Call Common Event
+	CommonEvents map[int][]EventCommand
+	// Choose returns the index of the choice picked for Show Choices or 4 to
+	// take the "When Cancel" branch. The first choice is picked if nil.
+	Choose func(choices []string, cancelType int) int
+	// Battle returns the result of Battle Processing, 0 to win, 1 to escape
+	// and 2 to lose. Battles are won if nil.
+	Battle func(troopID int) int
+	// Rand returns a number in [0, n) for the Random operand of Control
+	// Variables, rand.Intn is used if nil
+	Rand func(n int) int
+	// MaxSteps is the number of commands Run can run before returning
+	// ErrStepLimit, 100,000 if 0
+	MaxSteps int
+
+	steps int
+	trace []TraceEvent
+}
+
+// TraceEvent is a command that was run without changing the game state
+type TraceEvent struct {
+	// EventID is the ID of the map event that ran the command or 0 if it was
+	// run by a common event called from another map
+	EventID int
+	// CommonEventID is the common event the command is in or 0
+	CommonEventID int
+	Command       EventCommand
+	// Text is the lines of Show Text, Show Scrolling Text and Script or the
+	// choices of Show Choices
+	Text []string
+}
+
+// interpreterFrame is the position in a list of commands, Game_Interpreter
+// creates a child interpreter for each common event call
+type interpreterFrame struct {
+	list          []EventCommand
+	index         int
+	mapID         int
+	eventID       int
+	commonEventID int
+	depth         int
+	// branch is the result of the last branching command at each indent, 1
+	// is true for Conditional Branch
+	branch map[int]int
+}
+
+// NewInterpreter returns an interpreter that changes the given state
+func NewInterpreter(state MutableGameState) *Interpreter {
+	return &Interpreter{State: state}
+}
+
+// RunEvent runs the active page of the map event, see MapEvent.ActivePage.
+// Nothing is run if no page is active.
+//
+// The event is taken to be on the map the player is on, use RunEventOnMap
+// for events on other maps.
+func (interpreter *Interpreter) RunEvent(event *MapEvent) ([]TraceEvent, error) {
+	return interpreter.RunEventOnMap(interpreter.State.MapID(), event)
+}
+
+// RunEventOnMap is RunEvent for an event on the given map, which is used for
+// the event's self switches
+func (interpreter *Interpreter) RunEventOnMap(mapID int, event *MapEvent) ([]TraceEvent, error) {
+	page := event.ActivePageOnMap(mapID, interpreter.State)
+	if page == nil {
+		return nil, nil
+	}
+	return interpreter.runList(page.List, mapID, event.ID)
+}
+
+// Run runs the commands as the event with the given ID on the map the player
+// is on, eventID is 0 when running a common event.
+//
+// The state is changed as each command runs, so when an error is returned
+// the state has the changes made by the commands before it.
+func (interpreter *Interpreter) Run(list []EventCommand, eventID int) ([]TraceEvent, error) {
+	return interpreter.runList(list, interpreter.State.MapID(), eventID)
+}
+
+func (interpreter *Interpreter) runList(list []EventCommand, mapID, eventID int) ([]TraceEvent, error) {
+	interpreter.steps = 0
+	interpreter.trace = nil
+	err := interpreter.run(&interpreterFrame{
+		list:    list,
+		mapID:   mapID,
+		eventID: eventID,
+		branch:  make(map[int]int),
+	})
+	trace := interpreter.trace
+	interpreter.trace = nil
+	return trace, err
+}
+
+func (interpreter *Interpreter) run(frame *interpreterFrame) error {
+	maxSteps := interpreter.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxSteps
+	}
+	for frame.index < len(frame.list) {
+		interpreter.steps++
+		if interpreter.steps > maxSteps {
+			return fmt.Errorf("%w: %d commands", ErrStepLimit, maxSteps)
+		}
+		if err := interpreter.execute(frame); err != nil {
+			if frame.commonEventID != 0 {
+				return fmt.Errorf("common event %d, command %d: %w", frame.commonEventID, frame.index, err)
+			}
+			return fmt.Errorf("event %d, command %d: %w", frame.eventID, frame.index, err)
+		}
+		frame.index++
+	}
+	return nil
+}
+
+func (interpreter *Interpreter) execute(frame *interpreterFrame) error {
+	state := interpreter.State
+	command := frame.list[frame.index]
+	params := command.Parameters
+	indent := command.Indent
+	switch command.Code {
+	case commandEnd, commandComment, commandCommentLine, commandLabel, commandLoop,
+		commandChoicesEnd, commandBranchEnd, commandBattleProcessingEnd:
+		// nothing to do
+	case commandShowText:
+		interpreter.addTrace(frame, command, frame.readLines(commandTextLine))
+	case commandShowScrollingText:
+		interpreter.addTrace(frame, command, frame.readLines(commandScrollingTextLine))
+	case commandScript:
+		lines := append([]string{paramString(params, 0)}, frame.readLines(commandScriptLine)...)
+		interpreter.addTrace(frame, command, lines)
+	case commandShowChoices:
+		var choices []string
+		if values, ok := param(params, 0).([]interface{}); ok {
+			for _, value := range values {
+				choice, _ := value.(string)
+				choices = append(choices, choice)
+			}
+		}
+		interpreter.addTrace(frame, command, choices)
+		choice := 0
+		if interpreter.Choose != nil {
+			choice = interpreter.Choose(choices, paramInt(params, 1))
+		}
+		frame.branch[indent] = choice
+	case commandWhenChoice:
+		if frame.branch[indent] != paramInt(params, 0) {
+			frame.skip()
+		}
+	case commandWhenCancel:
+		if frame.branch[indent] != 4 {
+			frame.skip()
+		}
+	case commandConditionalBranch:
+		result, err := interpreter.condition(frame, params)
+		if err != nil {
+			return err
+		}
+		frame.branch[indent] = 0
+		if result {
+			frame.branch[indent] = 1
+		} else {
+			frame.skip()
+		}
+	case commandElse:
+		if frame.branch[indent] != 0 {
+			frame.skip()
+		}
+	case commandRepeatAbove:
+		// go back to the Loop command at the same indent
+		for frame.index > 0 {
+			frame.index--
+			if frame.list[frame.index].Indent == indent {
+				break
+			}
+		}
+	case commandBreakLoop:
+		for {
+			frame.index++
+			if frame.index >= len(frame.list)-1 {
+				return nil
+			}
+			next := frame.list[frame.index]
+			if next.Code == commandRepeatAbove && next.Indent < indent {
+				return nil
+			}
+		}
+	case commandExitEvent:
+		frame.index = len(frame.list)
+	case commandCallCommonEvent:
+		commonEventID := paramInt(params, 0)
+		list, ok := interpreter.CommonEvents[commonEventID]
+		if !ok {
+			interpreter.addTrace(frame, command, nil)
+			return nil
+		}
+		if frame.depth+1 >= maxCommonEventDepth {
+			return fmt.Errorf("common event call has exceeded maximum depth of %d", maxCommonEventDepth)
+		}
+		child := &interpreterFrame{
+			list:          list,
+			mapID:         state.MapID(),
+			commonEventID: commonEventID,
+			depth:         frame.depth + 1,
+			branch:        make(map[int]int),
+		}
+		if frame.mapID == state.MapID() {
+			child.eventID = frame.eventID
+		}
+		return interpreter.run(child)
+	case commandJumpToLabel:
+		name := paramString(params, 0)
+		for i, other := range frame.list {
+			if other.Code == commandLabel && paramString(other.Parameters, 0) == name {
+				frame.index = i
+				break
+			}
+		}
+	case commandControlSwitches:
+		for switchID := paramInt(params, 0); switchID <= paramInt(params, 1); switchID++ {
+			state.SetSwitch(switchID, paramInt(params, 2) == 0)
+		}
+	case commandControlVariables:
+		value, err := interpreter.variableOperand(params)
+		if err != nil {
+			return err
+		}
+		for variableID := paramInt(params, 0); variableID <= paramInt(params, 1); variableID++ {
+			state.SetVariable(variableID, operateVariable(state.Variable(variableID), paramInt(params, 2), value))
+		}
+	case commandControlSelfSwitch:
+		if frame.eventID > 0 {
+			state.SetSelfSwitch(frame.mapID, frame.eventID, paramString(params, 0), paramInt(params, 1) == 0)
+		}
+	case commandChangeGold:
+		state.SetGold(state.Gold() + interpreter.operateValue(params, 0))
+	case commandChangeItems:
+		itemID := paramInt(params, 0)
+		state.SetItemCount(itemID, state.ItemCount(itemID)+interpreter.operateValue(params, 1))
+	case commandChangeWeapons:
+		weaponID := paramInt(params, 0)
+		state.SetWeaponCount(weaponID, state.WeaponCount(weaponID)+interpreter.operateValue(params, 1))
+	case commandChangeArmors:
+		armorID := paramInt(params, 0)
+		state.SetArmorCount(armorID, state.ArmorCount(armorID)+interpreter.operateValue(params, 1))
+	case commandChangePartyMember:
+		actorID := paramInt(params, 0)
+		if paramInt(params, 1) == 0 {
+			if !state.HasPartyMember(actorID) {
+				state.SetPartyMembers(append(append([]int(nil), state.PartyMembers()...), actorID))
+			}
+			return nil
+		}
+		var members []int
+		for _, id := range state.PartyMembers() {
+			if id != actorID {
+				members = append(members, id)
+			}
+		}
+		state.SetPartyMembers(members)
+	case commandTransferPlayer:
+		mapID, x, y := paramInt(params, 1), paramInt(params, 2), paramInt(params, 3)
+		if paramInt(params, 0) != 0 {
+			mapID, x, y = state.Variable(mapID), state.Variable(x), state.Variable(y)
+		}
+		state.TransferPlayer(mapID, x, y, paramInt(params, 4))
+	case commandBattleProcessing:
+		troopID := 0
+		switch paramInt(params, 0) {
+		case 0:
+			troopID = paramInt(params, 1)
+		case 1:
+			troopID = state.Variable(paramInt(params, 1))
+		}
+		interpreter.addTrace(frame, command, nil)
+		result := 0
+		if interpreter.Battle != nil {
+			result = interpreter.Battle(troopID)
+		}
+		if result == 2 && !paramBool(params, 3) {
+			return ErrGameOver
+		}
+		frame.branch[indent] = result
+	case commandIfWin, commandIfEscape, commandIfLose:
+		if frame.branch[indent] != command.Code-commandIfWin {
+			frame.skip()
+		}
+	case commandGameOver:
+		return ErrGameOver
+	default:
+		interpreter.addTrace(frame, command, nil)
+	}
+	return nil
+}
+
+// condition follows Game_Interpreter#command_111
+func (interpreter *Interpreter) condition(frame *interpreterFrame, params []interface{}) (bool, error) {
+	state := interpreter.State
+	switch conditionType := paramInt(params, 0); conditionType {
+	case 0:
+		return state.Switch(paramInt(params, 1)) == (paramInt(params, 2) == 0), nil
+	case 1:
+		value1 := state.Variable(paramInt(params, 1))
+		value2 := paramInt(params, 3)
+		if paramInt(params, 2) != 0 {
+			value2 = state.Variable(value2)
+		}
+		switch paramInt(params, 4) {
+		case 0:
+			return value1 == value2, nil
+		case 1:
+			return value1 >= value2, nil
+		case 2:
+			return value1 <= value2, nil
+		case 3:
+			return value1 > value2, nil
+		case 4:
+			return value1 < value2, nil
+		case 5:
+			return value1 != value2, nil
+		}
+		return false, nil
+	case 2:
+		if frame.eventID <= 0 {
+			return false, nil
+		}
+		return state.SelfSwitch(frame.mapID, frame.eventID, paramString(params, 1)) == (paramInt(params, 2) == 0), nil
+	case 4:
+		// only "in the party" can be checked, the state doesn't have actors
+		if paramInt(params, 2) != 0 {
+			return false, fmt.Errorf("%w: conditional branch on actor %d", ErrUnsupportedCommand, paramInt(params, 2))
+		}
+		return state.HasPartyMember(paramInt(params, 1)), nil
+	case 7:
+		gold, amount := state.Gold(), paramInt(params, 1)
+		switch paramInt(params, 2) {
+		case 0:
+			return gold >= amount, nil
+		case 1:
+			return gold <= amount, nil
+		case 2:
+			return gold < amount, nil
+		}
+		return false, nil
+	case 8:
+		return state.ItemCount(paramInt(params, 1)) > 0, nil
+	case 9:
+		// equipped weapons and armors aren't counted, the state doesn't have equipment
+		return state.WeaponCount(paramInt(params, 1)) > 0, nil
+	case 10:
+		return state.ArmorCount(paramInt(params, 1)) > 0, nil
+	default:
+		return false, fmt.Errorf("%w: conditional branch %d", ErrUnsupportedCommand, conditionType)
+	}
+}
+
+// variableOperand follows the operand of Game_Interpreter#command_122
+func (interpreter *Interpreter) variableOperand(params []interface{}) (int, error) {
+	state := interpreter.State
+	switch operandType := paramInt(params, 3); operandType {
+	case 0:
+		return paramInt(params, 4), nil
+	case 1:
+		return state.Variable(paramInt(params, 4)), nil
+	case 2:
+		min, max := paramInt(params, 4), paramInt(params, 5)
+		if max < min {
+			return min, nil
+		}
+		intn := interpreter.Rand
+		if intn == nil {
+			intn = rand.Intn
+		}
+		return min + intn(max-min+1), nil
+	case 3:
+		// game data, only what's in the state is supported
+		param1 := paramInt(params, 5)
+		switch paramInt(params, 4) {
+		case 0:
+			return state.ItemCount(param1), nil
+		case 1:
+			return state.WeaponCount(param1), nil
+		case 2:
+			return state.ArmorCount(param1), nil
+		case 6:
+			members := state.PartyMembers()
+			if param1 < 0 || param1 >= len(members) {
+				return 0, nil
+			}
+			return members[param1], nil
+		case 7:
+			switch param1 {
+			case 0:
+				return state.MapID(), nil
+			case 1:
+				return len(state.PartyMembers()), nil
+			case 2:
+				return state.Gold(), nil
+			}
+		}
+		return 0, fmt.Errorf("%w: control variables game data %d, %d", ErrUnsupportedCommand, paramInt(params, 4), param1)
+	default:
+		return 0, fmt.Errorf("%w: control variables operand %d", ErrUnsupportedCommand, operandType)
+	}
+}
+
+// operateValue follows Game_Interpreter#operate_value where the operation,
+// operand type and operand are the parameters starting at index i
+func (interpreter *Interpreter) operateValue(params []interface{}, i int) int {
+	value := paramInt(params, i+2)
+	if paramInt(params, i+1) != 0 {
+		value = interpreter.State.Variable(value)
+	}
+	if paramInt(params, i) != 0 {
+		return -value
+	}
+	return value
+}
+
+// operateVariable follows Game_Interpreter#operate_variable, dividing by zero
+// sets the variable to 0 and division rounds down like Ruby
+func operateVariable(current int, operation int, value int) int {
+	switch operation {
+	case 0:
+		return value
+	case 1:
+		return current + value
+	case 2:
+		return current - value
+	case 3:
+		return current * value
+	case 4:
+		if value == 0 {
+			return 0
+		}
+		quotient := current / value
+		if (current%value != 0) && ((current < 0) != (value < 0)) {
+			quotient--
+		}
+		return quotient
+	case 5:
+		if value == 0 {
+			return 0
+		}
+		remainder := current % value
+		if remainder != 0 && ((remainder < 0) != (value < 0)) {
+			remainder += value
+		}
+		return remainder
+	}
+	return current
+}
+
+func (interpreter *Interpreter) addTrace(frame *interpreterFrame, command EventCommand, text []string) {
+	interpreter.trace = append(interpreter.trace, TraceEvent{
+		EventID:       frame.eventID,
+		CommonEventID: frame.commonEventID,
+		Command:       command,
+		Text:          text,
+	})
+}
+
+// readLines moves past the continuation lines after the current command and
+// returns their text
+func (frame *interpreterFrame) readLines(code int) []string {
+	var lines []string
+	for frame.index+1 < len(frame.list) && frame.list[frame.index+1].Code == code {
+		frame.index++
+		lines = append(lines, paramString(frame.list[frame.index].Parameters, 0))
+	}
+	return lines
+}
+
+// skip follows Game_Interpreter#command_skip, moving past the commands
+// indented under the current command
+func (frame *interpreterFrame) skip() {
+	indent := frame.list[frame.index].Indent
+	for frame.index+1 < len(frame.list) && frame.list[frame.index+1].Indent > indent {
+		frame.index++
+	}
+}
+
+func param(params []interface{}, i int) interface{} {
+	if i < 0 || i >= len(params) {
+		return nil
+	}
+	return params[i]
+}
+
+func paramInt(params []interface{}, i int) int {
+	value, _ := param(params, i).(int)
+	return value
+}
+
+func paramString(params []interface{}, i int) string {
+	value, _ := param(params, i).(string)
+	return value
+}
+
+func paramBool(params []interface{}, i int) bool {
+	value, _ := param(params, i).(bool)
+	return value
+}
@@ -0,0 +1,391 @@
+package rmvx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"runtime"
+	"sync"
+)
+
+// mapCacheEntry is a map that is loaded at most once, even when multiple
+// goroutines ask for it at the same time
+type mapCacheEntry struct {
+	once sync.Once
+	m    *Map
+	err  error
+}
+
+// Map returns the map with the given ID, loading it the first time it's requested.
+//
+// It's safe to call from multiple goroutines. The returned map is shared between
+// callers and must not be modified, use LoadMapByID if you need your own copy.
+func (project *Project) Map(mapID int) (*Map, error) {
+	if project.options.Maps == LoadSkip {
+		return nil, fmt.Errorf("%w: %s", ErrNotLoaded, mapAssetName(mapID))
+	}
+	entry := project.getMapCacheEntry(mapID)
+	entry.once.Do(func() {
+		entry.m, entry.err = project.LoadMapByID(mapID)
+	})
+	return entry.m, entry.err
+}
+
+func (project *Project) getMapCacheEntry(mapID int) *mapCacheEntry {
+	project.mapCacheMu.Lock()
+	defer project.mapCacheMu.Unlock()
+	if project.mapCache == nil {
+		project.mapCache = make(map[int]*mapCacheEntry)
+	}
+	entry, ok := project.mapCache[mapID]
+	if !ok {
+		entry = &mapCacheEntry{}
+		project.mapCache[mapID] = entry
+	}
+	return entry
+}
+
+// setMapCache stores an already loaded map so that Map() doesn't load it again
+func (project *Project) setMapCache(mapID int, m *Map) {
+	entry := project.getMapCacheEntry(mapID)
+	entry.once.Do(func() {
+		entry.m = m
+	})
+}
+
+// LoadMode controls when a database file is decoded
+type LoadMode int
+
+const (
+	// LoadDefault is LoadEager for databases and LoadLazy for maps
+	LoadDefault LoadMode = iota
+	// LoadEager decodes the file while loading the project
+	LoadEager
+	// LoadLazy decodes the file the first time it's accessed
+	LoadLazy
+	// LoadSkip never decodes the file, accessing it returns ErrNotLoaded
+	LoadSkip
+)
+
+// LoadOptions controls what LoadProjectWithOptions decodes and how
+type LoadOptions struct {
+	Tilesets LoadMode
+	MapInfos LoadMode
+	// System is accessed with Project.GetSystem if loaded lazily
+	System LoadMode
+	// Actors is accessed with Project.GetActors if loaded lazily
+	Actors LoadMode
+	// CommonEvents is LoadLazy by default and is accessed with Project.GetCommonEvents
+	CommonEvents LoadMode
+	// Troops is LoadLazy by default and is accessed with Project.GetTroops
+	Troops LoadMode
+	// Items, Weapons and Armors are LoadLazy by default and are accessed with
+	// Project.GetItems, Project.GetWeapons and Project.GetArmors
+	Items   LoadMode
+	Weapons LoadMode
+	Armors  LoadMode
+	// Maps is LoadLazy by default. LoadEager decodes every map file and
+	// stores them in the cache used by Project.Map.
+	Maps LoadMode
+	// AllowMissing will treat a missing database file as empty rather than
+	// failing to load
+	AllowMissing bool
+	// AllowUnknownFields will skip fields that aren't in the Go structs, such
+	// as ones added by scripts, rather than failing to decode
+	AllowUnknownFields bool
+	// AliasTableData makes Table.Data share memory with the bytes read from
+	// the file rather than copying them, which avoids an allocation per table
+	// but keeps the entire file in memory for as long as a table is used.
+	//
+	// Tables are still copied on big-endian machines.
+	AliasTableData bool
+	// PreciseTone decodes tones stored in interface{} values, such as event
+	// command parameters, as ToneFloat rather than Tone
+	PreciseTone bool
+	// Transcoder converts strings that aren't UTF-8, US-ASCII or binary
+	// to UTF-8, ie. Shift_JIS strings in projects made with the Japanese
+	// version of RPG Maker VX Ace.
+	//
+	// If not set, only ISO-8859-1 and Windows-1252 strings are converted
+	// and other encodings are left as the raw bytes.
+	Transcoder func(encoding string, data []byte) (string, error)
+	// Concurrency is the maximum number of files decoded at once when
+	// loading eagerly. Defaults to runtime.GOMAXPROCS(0).
+	Concurrency int
+}
+
+// lazyDatabase is a database file that is decoded at most once
+type lazyDatabase struct {
+	once sync.Once
+	err  error
+}
+
+func (project *Project) loadDatabase(db *lazyDatabase, mode LoadMode, assetName string, value interface{}) error {
+	if mode == LoadSkip {
+		return fmt.Errorf("%w: %s", ErrNotLoaded, assetName)
+	}
+	db.once.Do(func() {
+		err := loadRMVXDataFile(project, assetName, value)
+		if err != nil && project.options.AllowMissing && errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		db.err = err
+	})
+	return db.err
+}
+
+func (project *Project) loadTilesets() error {
+	return project.loadDatabase(&project.tilesetsDB, project.options.Tilesets, "Tilesets", &project.tilesets)
+}
+
+func (project *Project) loadMapInfos() error {
+	return project.loadDatabase(&project.mapInfosDB, project.options.MapInfos, "MapInfos", &project.mapInfos)
+}
+
+func (project *Project) loadSystem() error {
+	return project.loadDatabase(&project.systemDB, project.options.System, "System", &project.System)
+}
+
+func (project *Project) loadActors() error {
+	return project.loadDatabase(&project.actorsDB, project.options.Actors, "Actors", &project.Actors)
+}
+
+func (project *Project) loadCommonEvents() error {
+	return project.loadDatabase(&project.commonEventsDB, project.options.CommonEvents, "CommonEvents", &project.commonEvents)
+}
+
+func (project *Project) loadTroops() error {
+	return project.loadDatabase(&project.troopsDB, project.options.Troops, "Troops", &project.troops)
+}
+
+func (project *Project) loadItems() error {
+	return project.loadDatabase(&project.itemsDB, project.options.Items, "Items", &project.items)
+}
+
+func (project *Project) loadWeapons() error {
+	return project.loadDatabase(&project.weaponsDB, project.options.Weapons, "Weapons", &project.weapons)
+}
+
+func (project *Project) loadArmors() error {
+	return project.loadDatabase(&project.armorsDB, project.options.Armors, "Armors", &project.armors)
+}
+
+// GetSystem returns the System database, decoding it if it was loaded lazily
+func (project *Project) GetSystem() (*System, error) {
+	if err := project.loadSystem(); err != nil {
+		return nil, err
+	}
+	return &project.System, nil
+}
+
+// GetActors returns the Actors database, decoding it if it was loaded lazily
+func (project *Project) GetActors() ([]Actor, error) {
+	if err := project.loadActors(); err != nil {
+		return nil, err
+	}
+	return project.Actors, nil
+}
+
+// GetCommonEvents returns the CommonEvents database where the 0th entry is
+// empty, decoding it the first time it's accessed unless loaded eagerly
+func (project *Project) GetCommonEvents() ([]CommonEvent, error) {
+	if err := project.loadCommonEvents(); err != nil {
+		return nil, err
+	}
+	return project.commonEvents, nil
+}
+
+// GetTroops returns the Troops database where the 0th entry is empty,
+// decoding it the first time it's accessed unless loaded eagerly
+func (project *Project) GetTroops() ([]Troop, error) {
+	if err := project.loadTroops(); err != nil {
+		return nil, err
+	}
+	return project.troops, nil
+}
+
+// GetItems returns the Items database where the 0th entry is empty,
+// decoding it the first time it's accessed unless loaded eagerly
+func (project *Project) GetItems() ([]Item, error) {
+	if err := project.loadItems(); err != nil {
+		return nil, err
+	}
+	return project.items, nil
+}
+
+// GetWeapons returns the Weapons database where the 0th entry is empty,
+// decoding it the first time it's accessed unless loaded eagerly
+func (project *Project) GetWeapons() ([]Weapon, error) {
+	if err := project.loadWeapons(); err != nil {
+		return nil, err
+	}
+	return project.weapons, nil
+}
+
+// GetArmors returns the Armors database where the 0th entry is empty,
+// decoding it the first time it's accessed unless loaded eagerly
+func (project *Project) GetArmors() ([]Armor, error) {
+	if err := project.loadArmors(); err != nil {
+		return nil, err
+	}
+	return project.armors, nil
+}
+
+// LoadAll loads the project and decodes every database and map file in parallel.
+//
+// Maps are stored in the cache used by Project.Map. Entries in MapInfos
+// without a map file are skipped, use ScanMaps to find them.
+func LoadAll(fs fs.FS) (*Project, error) {
+	return LoadProjectWithOptions(context.Background(), fs, LoadOptions{
+		Tilesets:     LoadEager,
+		MapInfos:     LoadEager,
+		System:       LoadEager,
+		Actors:       LoadEager,
+		CommonEvents: LoadEager,
+		Troops:       LoadEager,
+		Items:        LoadEager,
+		Weapons:      LoadEager,
+		Armors:       LoadEager,
+		Maps:         LoadEager,
+	})
+}
+
+// LoadProjectWithOptions loads the project, decoding the eager databases
+// and maps in parallel.
+//
+// Cancelling the context stops any files that haven't started decoding yet from
+// being decoded. It does not affect lazy loading after this returns.
+func LoadProjectWithOptions(ctx context.Context, fs fs.FS, options LoadOptions) (*Project, error) {
+	// Load entrypoint file
+	if err := checkProjectFile(fs); err != nil {
+		return nil, err
+	}
+	project := &Project{}
+	project.fs = fs
+	project.options = options
+
+	var jobs []func() error
+	for _, db := range []struct {
+		mode LoadMode
+		load func() error
+	}{
+		{options.Tilesets, project.loadTilesets},
+		{options.MapInfos, project.loadMapInfos},
+		{options.System, project.loadSystem},
+		{options.Actors, project.loadActors},
+	} {
+		if db.mode == LoadDefault || db.mode == LoadEager {
+			jobs = append(jobs, db.load)
+		}
+	}
+	for _, db := range []struct {
+		mode LoadMode
+		load func() error
+	}{
+		{options.CommonEvents, project.loadCommonEvents},
+		{options.Troops, project.loadTroops},
+		{options.Items, project.loadItems},
+		{options.Weapons, project.loadWeapons},
+		{options.Armors, project.loadArmors},
+	} {
+		if db.mode == LoadEager {
+			jobs = append(jobs, db.load)
+		}
+	}
+	if options.Maps == LoadEager {
+		mapFiles, err := project.listMapFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, mapID := range mapFiles {
+			mapID := mapID
+			jobs = append(jobs, func() error {
+				m, err := project.LoadMapByID(mapID)
+				if err != nil {
+					return err
+				}
+				project.setMapCache(mapID, m)
+				return nil
+			})
+		}
+	}
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if err := runJobs(ctx, concurrency, jobs); err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// runJob returns the panic of the job as an error, jobs run in their own
+// goroutines so the caller couldn't recover it otherwise
+func runJob(job func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if recovered, ok := r.(error); ok {
+				err = fmt.Errorf("load: %w", recovered)
+			} else {
+				err = fmt.Errorf("load: %v", r)
+			}
+		}
+	}()
+	return job()
+}
+
+// runJobs runs each job with at most workerCount of them running at once.
+//
+// It stops starting new jobs after the first error or when the context is
+// cancelled and returns that error once the running jobs have finished.
+func runJobs(ctx context.Context, workerCount int, jobs []func() error) error {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	workers := make(chan struct{}, workerCount)
+	for _, job := range jobs {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			setErr(ctx.Err())
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			<-workers
+			setErr(err)
+			continue
+		}
+		wg.Add(1)
+		go func(job func() error) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			if err := runJob(job); err != nil {
+				setErr(err)
+			}
+		}(job)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,115 @@
+package rmvx
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	mapFilePrefix    = "Map"
+	dataFileExt      = ".rvdata2"
+	dataDirectory    = "Data"
+	mapFileMinDigits = 3
+)
+
+// mapAssetName returns the name of the map file without the directory and extension
+//
+// The ID is zero-padded to at least 3 digits like RPG Maker VX Ace does with
+// sprintf("Map%03d", id), so ID 7 is "Map007" and ID 1234 is "Map1234".
+func mapAssetName(mapID int) string {
+	idPart := strconv.Itoa(mapID)
+	if padding := mapFileMinDigits - len(idPart); padding > 0 {
+		idPart = strings.Repeat("0", padding) + idPart
+	}
+	return mapFilePrefix + idPart
+}
+
+// MapFilename returns the path of the map file relative to the project directory,
+// ie. "Data/Map001.rvdata2"
+func MapFilename(mapID int) string {
+	return dataDirectory + "/" + mapAssetName(mapID) + dataFileExt
+}
+
+// ParseMapFilename returns the map ID for a map file name, ie. "Map001.rvdata2"
+// or "Data/Map001.rvdata2".
+//
+// It returns false if the name is not exactly what MapFilename would produce,
+// which means files like "MapInfos.rvdata2" and "Map0001.rvdata2" are rejected.
+func ParseMapFilename(name string) (int, bool) {
+	base := path.Base(name)
+	if !strings.HasPrefix(base, mapFilePrefix) || !strings.HasSuffix(base, dataFileExt) {
+		return 0, false
+	}
+	idPart := base[len(mapFilePrefix) : len(base)-len(dataFileExt)]
+	for _, c := range idPart {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	mapID, err := strconv.Atoi(idPart)
+	if err != nil || mapID <= 0 || mapAssetName(mapID) != mapFilePrefix+idPart {
+		return 0, false
+	}
+	return mapID, true
+}
+
+// MapScan is the result of comparing the map files in the project
+// against MapInfos
+type MapScan struct {
+	// Files is the ID of every map file found in ascending order
+	Files []int
+	// Orphans is the ID of every map file that has no entry in MapInfos
+	Orphans []int
+	// Missing is the ID of every MapInfos entry that has no map file
+	Missing []int
+}
+
+// ScanMaps finds every Data/Map*.rvdata2 file in the project
+func (project *Project) ScanMaps() (*MapScan, error) {
+	if err := project.loadMapInfos(); err != nil {
+		return nil, err
+	}
+	mapFiles, err := project.listMapFiles()
+	if err != nil {
+		return nil, err
+	}
+	scan := &MapScan{
+		Files: mapFiles,
+	}
+	hasFile := make(map[int]bool)
+	for _, mapID := range mapFiles {
+		hasFile[mapID] = true
+		if _, ok := project.mapInfos[mapID]; !ok {
+			scan.Orphans = append(scan.Orphans, mapID)
+		}
+	}
+	for mapID := range project.mapInfos {
+		if !hasFile[mapID] {
+			scan.Missing = append(scan.Missing, mapID)
+		}
+	}
+	sort.Ints(scan.Missing)
+	return scan, nil
+}
+
+// listMapFiles returns the ID of every map file in ascending order
+func (project *Project) listMapFiles() ([]int, error) {
+	entries, err := fs.ReadDir(project.fs, dataDirectory)
+	if err != nil {
+		return nil, err
+	}
+	var mapFiles []int
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if mapID, ok := ParseMapFilename(entry.Name()); ok {
+			mapFiles = append(mapFiles, mapID)
+		}
+	}
+	sort.Ints(mapFiles)
+	return mapFiles, nil
+}
@@ -0,0 +1,191 @@
+package rmvx
+
+import (
+	"sort"
+)
+
+// MapTree is the map hierarchy shown in the RPG Maker VX Ace editor, built
+// from the ParentID and Order of each MapInfo
+type MapTree struct {
+	infos    map[int]MapInfo
+	roots    []int
+	children map[int][]int
+	parents  map[int]int
+}
+
+// NewMapTree builds a tree from map infos keyed by map ID.
+//
+// Maps with a ParentID that doesn't exist are treated as root maps. If the
+// parents of a corrupt MapInfos form a cycle, the map in the cycle that comes
+// first by Order is treated as a root map so every map is in the tree.
+func NewMapTree(mapInfos map[int]MapInfo) *MapTree {
+	tree := &MapTree{
+		infos:    mapInfos,
+		children: make(map[int][]int),
+		parents:  make(map[int]int),
+	}
+	for id, info := range mapInfos {
+		if _, ok := mapInfos[info.ParentID]; !ok || info.ParentID == id {
+			tree.roots = append(tree.roots, id)
+			continue
+		}
+		tree.parents[id] = info.ParentID
+		tree.children[info.ParentID] = append(tree.children[info.ParentID], id)
+	}
+	tree.breakCycles()
+	tree.sortByOrder(tree.roots)
+	for _, ids := range tree.children {
+		tree.sortByOrder(ids)
+	}
+	return tree
+}
+
+// breakCycles makes a root map out of a map in each cycle of parents, which
+// can't be reached from any other root map
+func (tree *MapTree) breakCycles() {
+	reachable := make(map[int]bool, len(tree.infos))
+	var visit func(id int)
+	visit = func(id int) {
+		if reachable[id] {
+			return
+		}
+		reachable[id] = true
+		for _, childID := range tree.children[id] {
+			visit(childID)
+		}
+	}
+	for _, id := range tree.roots {
+		visit(id)
+	}
+	for len(reachable) < len(tree.infos) {
+		var unreachable []int
+		for id := range tree.infos {
+			if !reachable[id] {
+				unreachable = append(unreachable, id)
+			}
+		}
+		tree.sortByOrder(unreachable)
+		id := unreachable[0]
+		parentID := tree.parents[id]
+		siblings := tree.children[parentID]
+		for i, siblingID := range siblings {
+			if siblingID == id {
+				tree.children[parentID] = append(siblings[:i:i], siblings[i+1:]...)
+				break
+			}
+		}
+		delete(tree.parents, id)
+		tree.roots = append(tree.roots, id)
+		visit(id)
+	}
+}
+
+func (tree *MapTree) sortByOrder(ids []int) {
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := tree.infos[ids[i]], tree.infos[ids[j]]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return ids[i] < ids[j]
+	})
+}
+
+// Info returns the map info for the given map ID
+func (tree *MapTree) Info(id int) (MapInfo, bool) {
+	info, ok := tree.infos[id]
+	return info, ok
+}
+
+// Roots returns the IDs of the top-level maps, sorted by Order
+func (tree *MapTree) Roots() []int {
+	return append([]int(nil), tree.roots...)
+}
+
+// Children returns the IDs of the maps directly under the given map, sorted by Order
+func (tree *MapTree) Children(id int) []int {
+	return append([]int(nil), tree.children[id]...)
+}
+
+// Parent returns the parent map ID or 0 if the map is a root map or doesn't exist
+func (tree *MapTree) Parent(id int) int {
+	return tree.parents[id]
+}
+
+// Path returns the names of the maps from the root map down to the given map,
+// ie. ["World", "Town", "Inn"]
+//
+// It returns nil if the map doesn't exist.
+func (tree *MapTree) Path(id int) []string {
+	var path []string
+	visited := make(map[int]bool)
+	for id != 0 && !visited[id] {
+		info, ok := tree.infos[id]
+		if !ok {
+			break
+		}
+		visited[id] = true
+		path = append(path, info.Name)
+		id = tree.Parent(id)
+	}
+	// reverse so that the root is first
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Walk calls fn for every map in the order they're displayed in the editor,
+// with depth being 0 for root maps.
+//
+// If fn returns false, the children of that map are skipped.
+func (tree *MapTree) Walk(fn func(id int, depth int) bool) {
+	visited := make(map[int]bool)
+	var walk func(ids []int, depth int)
+	walk = func(ids []int, depth int) {
+		for _, id := range ids {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			if fn(id, depth) {
+				walk(tree.children[id], depth+1)
+			}
+		}
+	}
+	walk(tree.roots, 0)
+}
+
+// IDs returns every map ID in the order they're displayed in the editor
+func (tree *MapTree) IDs() []int {
+	ids := make([]int, 0, len(tree.infos))
+	tree.Walk(func(id int, depth int) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return ids
+}
+
+// LookupName returns the ID of the first map with the given name, in the order
+// they're displayed in the editor
+func (tree *MapTree) LookupName(name string) (int, bool) {
+	foundID := 0
+	tree.Walk(func(id int, depth int) bool {
+		if foundID != 0 {
+			return false
+		}
+		if tree.infos[id].Name == name {
+			foundID = id
+			return false
+		}
+		return true
+	})
+	return foundID, foundID != 0
+}
+
+// MapTree returns the map hierarchy of the project
+func (project *Project) MapTree() (*MapTree, error) {
+	if err := project.loadMapInfos(); err != nil {
+		return nil, err
+	}
+	return NewMapTree(project.mapInfos), nil
+}
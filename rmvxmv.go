@@ -0,0 +1,1167 @@
+package rmvx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// mvDataDirectory is the directory RPG Maker MV and MZ load data files from
+const mvDataDirectory = "data"
+
+// mvScreenScale converts positions on VX Ace's 544x416 screen to MV's 816x624 screen
+const mvScreenScale = 1.5
+
+// Map.Data layers in VX Ace and MV. VX Ace has 2 layers for A tiles, 1 for
+// B to E tiles and 1 for shadows and regions. MV has 4 tile layers followed
+// by a shadow layer and a region layer.
+const (
+	mvMapLayerUpper  = 3
+	mvMapLayerShadow = 4
+	mvMapLayerRegion = 5
+	mvMapLayers      = 6
+)
+
+// Event commands that have different parameters in MV
+const (
+	commandGetLocationInfo = 285
+	commandChangeEquipment = 319
+)
+
+// Info types of Get Location Info that are numbered differently in MV
+const (
+	locationInfoUpperLayer   = 4
+	locationInfoRegion       = 5
+	mvLocationInfoUpperLayer = 5
+	mvLocationInfoRegion     = 6
+)
+
+// ExportMV writes the project as RPG Maker MV data files, ie. "data/Actors.json"
+// and "data/Map001.json", so a VX Ace project can be moved to MV or MZ.
+//
+// Fields are renamed and values are converted to MV's conventions: map tiles
+// are moved into MV's layers with shadows and regions split apart, equipment
+// types start from 1, troop positions are scaled to MV's screen size and event
+// command parameters are changed where MV differs, such as the cancel choice
+// of Show Choices and audio files having a pan.
+//
+// Classes, skills, enemies, states and animations don't have Go structs, so
+// they're converted field by field with their names changed to MV's. Databases
+// skipped with LoadSkip and database files that don't exist aren't written,
+// but System, Actors, Tilesets, MapInfos and the maps are always required.
+// Scripts in event commands are copied as-is as they are Ruby, and graphics
+// aren't converted to MV's 48x48 tile size.
+func (project *Project) ExportMV(w BundleWriter) error {
+	system, err := project.GetSystem()
+	if err != nil {
+		return err
+	}
+	if err := writeMVFile(w, "System", newMVSystem(system)); err != nil {
+		return err
+	}
+
+	actors, err := project.GetActors()
+	if err != nil {
+		return err
+	}
+	mvActors := make([]*mvActor, len(actors))
+	for i := range actors {
+		mvActors[i] = newMVActor(&actors[i])
+	}
+	if err := writeMVDatabase(w, "Actors", mvActors); err != nil {
+		return err
+	}
+
+	tilesets, err := project.Tilesets()
+	if err != nil {
+		return err
+	}
+	mvTilesets := make([]*mvTileset, len(tilesets))
+	for i := range tilesets {
+		mvTilesets[i] = newMVTileset(&tilesets[i])
+	}
+	if err := writeMVDatabase(w, "Tilesets", mvTilesets); err != nil {
+		return err
+	}
+
+	mapIDs, err := project.listMapFiles()
+	if err != nil {
+		return err
+	}
+	mapInfos, err := project.MapInfos()
+	if err != nil {
+		return err
+	}
+	var mvMapInfos []*mvMapInfo
+	for _, mapID := range mapIDs {
+		m, err := project.Map(mapID)
+		if err != nil {
+			return err
+		}
+		if err := writeMVFile(w, mapAssetName(mapID), newMVMap(m)); err != nil {
+			return err
+		}
+		mapInfo, ok := mapInfos[mapID]
+		if !ok {
+			continue
+		}
+		for len(mvMapInfos) <= mapID {
+			mvMapInfos = append(mvMapInfos, nil)
+		}
+		mvMapInfos[mapID] = &mvMapInfo{
+			ID:       mapID,
+			Expanded: mapInfo.Expanded,
+			Name:     mapInfo.Name,
+			Order:    mapInfo.Order,
+			ParentID: mapInfo.ParentID,
+			ScrollX:  mapInfo.ScrollX,
+			ScrollY:  mapInfo.ScrollY,
+		}
+	}
+	if err := writeMVDatabase(w, "MapInfos", mvMapInfos); err != nil {
+		return err
+	}
+
+	if commonEvents, err := project.GetCommonEvents(); err == nil {
+		mvCommonEvents := make([]*mvCommonEvent, len(commonEvents))
+		for i := range commonEvents {
+			commonEvent := &commonEvents[i]
+			mvCommonEvents[i] = &mvCommonEvent{
+				ID:       commonEvent.ID,
+				Name:     commonEvent.Name,
+				Trigger:  commonEvent.Trigger,
+				SwitchID: commonEvent.SwitchID,
+				List:     newMVEventCommands(commonEvent.List),
+			}
+		}
+		if err := writeMVDatabase(w, "CommonEvents", mvCommonEvents); err != nil {
+			return err
+		}
+	} else if !isMVDatabaseSkipped(err) {
+		return err
+	}
+	if troops, err := project.GetTroops(); err == nil {
+		mvTroops := make([]*mvTroop, len(troops))
+		for i := range troops {
+			mvTroops[i] = newMVTroop(&troops[i])
+		}
+		if err := writeMVDatabase(w, "Troops", mvTroops); err != nil {
+			return err
+		}
+	} else if !isMVDatabaseSkipped(err) {
+		return err
+	}
+	if items, err := project.GetItems(); err == nil {
+		mvItems := make([]*mvItem, len(items))
+		for i := range items {
+			mvItems[i] = newMVItem(&items[i])
+		}
+		if err := writeMVDatabase(w, "Items", mvItems); err != nil {
+			return err
+		}
+	} else if !isMVDatabaseSkipped(err) {
+		return err
+	}
+	if weapons, err := project.GetWeapons(); err == nil {
+		mvWeapons := make([]*mvWeapon, len(weapons))
+		for i := range weapons {
+			mvWeapons[i] = newMVWeapon(&weapons[i])
+		}
+		if err := writeMVDatabase(w, "Weapons", mvWeapons); err != nil {
+			return err
+		}
+	} else if !isMVDatabaseSkipped(err) {
+		return err
+	}
+	if armors, err := project.GetArmors(); err == nil {
+		mvArmors := make([]*mvArmor, len(armors))
+		for i := range armors {
+			mvArmors[i] = newMVArmor(&armors[i])
+		}
+		if err := writeMVDatabase(w, "Armors", mvArmors); err != nil {
+			return err
+		}
+	} else if !isMVDatabaseSkipped(err) {
+		return err
+	}
+	for _, name := range mvUntypedDatabases {
+		entries, err := project.loadUntypedDatabase(name)
+		if err != nil {
+			if isMVDatabaseSkipped(err) {
+				continue
+			}
+			return err
+		}
+		mvEntries := make([]interface{}, len(entries))
+		for i, entry := range entries {
+			mvEntries[i] = newMVUntypedEntry(name, entry)
+		}
+		if err := writeMVDatabase(w, name, mvEntries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mvUntypedDatabases are the databases exported without Go structs
+var mvUntypedDatabases = []string{"Classes", "Skills", "Enemies", "States", "Animations"}
+
+// isMVDatabaseSkipped reports whether the error is from a database that
+// ExportMV leaves out rather than failing
+func isMVDatabaseSkipped(err error) bool {
+	return errors.Is(err, ErrNotLoaded) || errors.Is(err, fs.ErrNotExist)
+}
+
+// newMVUntypedEntry converts an entry of a database decoded with
+// loadUntypedDatabase to MV's names, ie. features become traits
+func newMVUntypedEntry(databaseName string, entry interface{}) interface{} {
+	fields, ok := newMVValue(entry).(map[string]interface{})
+	if !ok {
+		// nil entries, ie. the 0th entry
+		return entry
+	}
+	if features, ok := fields["features"].([]interface{}); ok {
+		for _, feature := range features {
+			feature, _ := feature.(map[string]interface{})
+			if code, _ := feature["code"].(int); code == featureEquipFix || code == featureEquipSeal {
+				dataID, _ := feature["dataId"].(int)
+				feature["dataId"] = dataID + 1
+			}
+		}
+		fields["traits"] = features
+		delete(fields, "features")
+	}
+	switch databaseName {
+	case "States":
+		// MV's states can change the battler's motion and overlay
+		fields["motion"] = 0
+		fields["overlay"] = 0
+	case "Animations":
+		// MV's frames are the cell data without the number of cells
+		frames, _ := fields["frames"].([]interface{})
+		for i, frame := range frames {
+			frame, _ := frame.(map[string]interface{})
+			frames[i] = frame["cellData"]
+		}
+	}
+	return fields
+}
+
+func writeMVFile(w BundleWriter, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := w.Create(mvDataDirectory + "/" + name + ".json")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// writeMVDatabase writes one entry per line like the MV editor. The 0th
+// entry is always null as MV's databases start from 1 too.
+func writeMVDatabase(w BundleWriter, name string, entries interface{}) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) == 0 {
+		raw = append(raw, nil)
+	}
+	raw[0] = json.RawMessage("null")
+	var buf bytes.Buffer
+	buf.WriteString("[\n")
+	for i, entry := range raw {
+		if i > 0 {
+			buf.WriteString(",\n")
+		}
+		buf.Write(entry)
+	}
+	buf.WriteString("\n]")
+	f, err := w.Create(mvDataDirectory + "/" + name + ".json")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	return err
+}
+
+type mvAudio struct {
+	Name   string `json:"name"`
+	Pan    int    `json:"pan"`
+	Pitch  int    `json:"pitch"`
+	Volume int    `json:"volume"`
+}
+
+func newMVAudio(sound BackgroundSound) mvAudio {
+	return mvAudio{Name: sound.Name, Pitch: sound.Pitch, Volume: sound.Volume}
+}
+
+type mvVehicle struct {
+	BGM            mvAudio `json:"bgm"`
+	CharacterIndex int     `json:"characterIndex"`
+	CharacterName  string  `json:"characterName"`
+	StartMapID     int     `json:"startMapId"`
+	StartX         int     `json:"startX"`
+	StartY         int     `json:"startY"`
+}
+
+func newMVVehicle(vehicle *SystemVehicle) mvVehicle {
+	return mvVehicle{
+		BGM:            newMVAudio(vehicle.BGM),
+		CharacterIndex: vehicle.CharacterIndex,
+		CharacterName:  vehicle.CharacterName,
+		StartMapID:     vehicle.StartMapID,
+		StartX:         vehicle.StartX,
+		StartY:         vehicle.StartY,
+	}
+}
+
+type mvAttackMotion struct {
+	Type          int `json:"type"`
+	WeaponImageID int `json:"weaponImageId"`
+}
+
+type mvTerms struct {
+	Basic    []string          `json:"basic"`
+	Commands []interface{}     `json:"commands"`
+	Params   []string          `json:"params"`
+	Messages map[string]string `json:"messages"`
+}
+
+type mvSystem struct {
+	Airship         mvVehicle        `json:"airship"`
+	ArmorTypes      []string         `json:"armorTypes"`
+	AttackMotions   []mvAttackMotion `json:"attackMotions"`
+	BattleBGM       mvAudio          `json:"battleBgm"`
+	Battleback1Name string           `json:"battleback1Name"`
+	Battleback2Name string           `json:"battleback2Name"`
+	BattlerHue      int              `json:"battlerHue"`
+	BattlerName     string           `json:"battlerName"`
+	Boat            mvVehicle        `json:"boat"`
+	CurrencyUnit    string           `json:"currencyUnit"`
+	DefeatME        mvAudio          `json:"defeatMe"`
+	EditMapID       int              `json:"editMapId"`
+	Elements        []string         `json:"elements"`
+	EquipTypes      []string         `json:"equipTypes"`
+	GameTitle       string           `json:"gameTitle"`
+	GameoverME      mvAudio          `json:"gameoverMe"`
+	Locale          string           `json:"locale"`
+	MagicSkills     []int            `json:"magicSkills"`
+	MenuCommands    []bool           `json:"menuCommands"`
+	OptDisplayTP    bool             `json:"optDisplayTp"`
+	OptDrawTitle    bool             `json:"optDrawTitle"`
+	OptExtraExp     bool             `json:"optExtraExp"`
+	OptFloorDeath   bool             `json:"optFloorDeath"`
+	OptFollowers    bool             `json:"optFollowers"`
+	OptSideView     bool             `json:"optSideView"`
+	OptSlipDeath    bool             `json:"optSlipDeath"`
+	OptTransparent  bool             `json:"optTransparent"`
+	PartyMembers    []int            `json:"partyMembers"`
+	Ship            mvVehicle        `json:"ship"`
+	SkillTypes      []string         `json:"skillTypes"`
+	Sounds          []mvAudio        `json:"sounds"`
+	StartMapID      int              `json:"startMapId"`
+	StartX          int              `json:"startX"`
+	StartY          int              `json:"startY"`
+	Switches        []string         `json:"switches"`
+	Terms           mvTerms          `json:"terms"`
+	TestBattlers    []SystemBattler  `json:"testBattlers"`
+	TestTroopID     int              `json:"testTroopId"`
+	Title1Name      string           `json:"title1Name"`
+	Title2Name      string           `json:"title2Name"`
+	TitleBGM        mvAudio          `json:"titleBgm"`
+	Variables       []string         `json:"variables"`
+	VersionID       int              `json:"versionId"`
+	VictoryME       mvAudio          `json:"victoryMe"`
+	WeaponTypes     []string         `json:"weaponTypes"`
+	WindowTone      [4]int           `json:"windowTone"`
+}
+
+// mvMessages are MV's default battle and menu messages, VX Ace has these in
+// the Vocab module of the scripts rather than in System
+var mvMessages = map[string]string{
+	"actionFailure":   "There was no effect on %1!",
+	"actorDamage":     "%1 took %2 damage!",
+	"actorDrain":      "%1 was drained of %2 %3!",
+	"actorGain":       "%1 gained %2 %3!",
+	"actorLoss":       "%1 lost %2 %3!",
+	"actorNoDamage":   "%1 took no damage!",
+	"actorNoHit":      "Miss! %1 took no damage!",
+	"actorRecovery":   "%1 recovered %2 %3!",
+	"alwaysDash":      "Always Dash",
+	"bgmVolume":       "BGM Volume",
+	"bgsVolume":       "BGS Volume",
+	"buffAdd":         "%1's %2 went up!",
+	"buffRemove":      "%1's %2 returned to normal!",
+	"commandRemember": "Command Remember",
+	"counterAttack":   "%1 counterattacked!",
+	"criticalToActor": "A painful blow!!",
+	"criticalToEnemy": "An excellent hit!!",
+	"debuffAdd":       "%1's %2 went down!",
+	"defeat":          "%1 was defeated.",
+	"emerge":          "%1 emerged!",
+	"enemyDamage":     "%1 took %2 damage!",
+	"enemyDrain":      "%1 was drained of %2 %3!",
+	"enemyGain":       "%1 gained %2 %3!",
+	"enemyLoss":       "%1 lost %2 %3!",
+	"enemyNoDamage":   "%1 took no damage!",
+	"enemyNoHit":      "Miss! %1 took no damage!",
+	"enemyRecovery":   "%1 recovered %2 %3!",
+	"escapeFailure":   "However, it was unable to escape!",
+	"escapeStart":     "%1 has started to escape!",
+	"evasion":         "%1 evaded the attack!",
+	"expNext":         "To Next %1",
+	"expTotal":        "Current %1",
+	"file":            "File",
+	"levelUp":         "%1 is now %2 %3!",
+	"loadMessage":     "Load which file?",
+	"magicEvasion":    "%1 nullified the magic!",
+	"magicReflection": "%1 reflected the magic!",
+	"meVolume":        "ME Volume",
+	"obtainExp":       "%1 %2 received!",
+	"obtainGold":      "%1\\G found!",
+	"obtainItem":      "%1 found!",
+	"obtainSkill":     "%1 learned!",
+	"partyName":       "%1's Party",
+	"possession":      "Possession",
+	"preemptive":      "%1 got the upper hand!",
+	"saveMessage":     "Save to which file?",
+	"seVolume":        "SE Volume",
+	"substitute":      "%1 protected %2!",
+	"surprise":        "%1 was surprised!",
+	"useItem":         "%1 uses %2!",
+	"victory":         "%1 was victorious!",
+}
+
+// Indexes of terms.commands that differ between VX Ace and MV
+const (
+	termCommandOptions  = 11
+	termCommandShutdown = 20
+	termCommandsVXAce   = 23
+)
+
+func newMVSystem(system *System) *mvSystem {
+	mv := &mvSystem{
+		Airship:         newMVVehicle(&system.Airship),
+		ArmorTypes:      system.ArmorTypes,
+		BattleBGM:       newMVAudio(system.BattleBGM),
+		Battleback1Name: system.Battleback1Name,
+		Battleback2Name: system.Battleback2Name,
+		BattlerHue:      system.BattlerHue,
+		BattlerName:     system.BattlerName,
+		Boat:            newMVVehicle(&system.Boat),
+		CurrencyUnit:    system.CurrencyUnit,
+		EditMapID:       system.EditMapID,
+		Elements:        system.Elements,
+		// VX Ace's equipment types start from 0 for weapons, MV's start from 1
+		EquipTypes:     append([]string{""}, system.Terms.ETypes...),
+		GameTitle:      system.GameTitle,
+		GameoverME:     newMVAudio(system.GameoverMusic),
+		Locale:         "en_US",
+		MagicSkills:    []int{},
+		MenuCommands:   []bool{true, true, true, true, true, true},
+		OptDisplayTP:   system.DisplayTP,
+		OptDrawTitle:   system.DrawTitle,
+		OptExtraExp:    system.ExtraExp,
+		OptFloorDeath:  system.FloorDeath,
+		OptFollowers:   system.Followers,
+		OptSlipDeath:   system.SlipDeath,
+		OptTransparent: system.Transparent,
+		PartyMembers:   system.PartyMembers,
+		Ship:           newMVVehicle(&system.Ship),
+		SkillTypes:     system.SkillTypes,
+		StartMapID:     system.StartMapID,
+		StartX:         system.StartX,
+		StartY:         system.StartY,
+		Switches:       system.Switches,
+		TestBattlers:   system.TestBattlers,
+		TestTroopID:    system.TestTroopID,
+		Title1Name:     system.Title1Name,
+		Title2Name:     system.Title2Name,
+		TitleBGM:       newMVAudio(system.TitleBGM),
+		Variables:      system.Variables,
+		VersionID:      system.VersionID,
+		VictoryME:      newMVAudio(system.BattleEndMusic),
+		WeaponTypes:    system.WeaponTypes,
+		WindowTone: [4]int{
+			int(system.WindowTone.Red),
+			int(system.WindowTone.Green),
+			int(system.WindowTone.Blue),
+			int(system.WindowTone.Gray),
+		},
+	}
+	for i := range system.WeaponTypes {
+		// sideview motions, 0 is bare hands and the rest swing the weapon
+		motion := mvAttackMotion{}
+		if i > 0 {
+			motion.Type = 1
+		}
+		mv.AttackMotions = append(mv.AttackMotions, motion)
+	}
+	for _, sound := range system.Sounds {
+		mv.Sounds = append(mv.Sounds, newMVAudio(sound))
+	}
+
+	// MV adds EXP to the basic terms and Hit and Evasion to the params
+	mv.Terms.Basic = append(append([]string(nil), system.Terms.Basic...), "EXP", "EXP")
+	mv.Terms.Params = append(append([]string(nil), system.Terms.Params...), "Hit", "Evasion")
+	// VX Ace doesn't use the 11th command and has Shut Down as the 20th, MV
+	// has Options as the 11th, no Shut Down and adds Buy and Sell after the
+	// 23 commands VX Ace uses
+	for i := 0; i < termCommandsVXAce; i++ {
+		command := ""
+		if i < len(system.Terms.Commands) {
+			command = system.Terms.Commands[i]
+		}
+		switch {
+		case i == termCommandOptions && command == "":
+			mv.Terms.Commands = append(mv.Terms.Commands, "Options")
+		case i == termCommandShutdown:
+			mv.Terms.Commands = append(mv.Terms.Commands, nil)
+		default:
+			mv.Terms.Commands = append(mv.Terms.Commands, command)
+		}
+	}
+	mv.Terms.Commands = append(mv.Terms.Commands, nil, "Buy", "Sell")
+	mv.Terms.Messages = mvMessages
+	return mv
+}
+
+type mvTrait struct {
+	Code   int     `json:"code"`
+	DataID int     `json:"dataId"`
+	Value  float64 `json:"value"`
+}
+
+// Feature codes that refer to an equipment type
+const (
+	featureEquipFix  = 53
+	featureEquipSeal = 54
+)
+
+func newMVTraits(features []ActorFeature) []mvTrait {
+	traits := make([]mvTrait, len(features))
+	for i, feature := range features {
+		traits[i] = mvTrait{Code: feature.Code, DataID: feature.DataID, Value: feature.Value}
+		if feature.Code == featureEquipFix || feature.Code == featureEquipSeal {
+			traits[i].DataID++
+		}
+	}
+	return traits
+}
+
+type mvActor struct {
+	ID             int       `json:"id"`
+	BattlerName    string    `json:"battlerName"`
+	CharacterIndex int       `json:"characterIndex"`
+	CharacterName  string    `json:"characterName"`
+	ClassID        int       `json:"classId"`
+	Equips         []int     `json:"equips"`
+	FaceIndex      int       `json:"faceIndex"`
+	FaceName       string    `json:"faceName"`
+	Traits         []mvTrait `json:"traits"`
+	InitialLevel   int       `json:"initialLevel"`
+	MaxLevel       int       `json:"maxLevel"`
+	Name           string    `json:"name"`
+	Nickname       string    `json:"nickname"`
+	Note           string    `json:"note"`
+	Profile        string    `json:"profile"`
+}
+
+func newMVActor(actor *Actor) *mvActor {
+	return &mvActor{
+		ID:             actor.ID,
+		CharacterIndex: actor.CharacterIndex,
+		CharacterName:  actor.CharacterName,
+		ClassID:        actor.ClassID,
+		Equips:         actor.Equips,
+		FaceIndex:      actor.FaceIndex,
+		FaceName:       actor.FaceName,
+		Traits:         newMVTraits(actor.Features),
+		InitialLevel:   actor.InitialLevel,
+		MaxLevel:       actor.MaxLevel,
+		Name:           actor.Name,
+		Nickname:       actor.Nickname,
+		Note:           actor.Note,
+		Profile:        actor.Description,
+	}
+}
+
+type mvTileset struct {
+	ID           int      `json:"id"`
+	Flags        []int16  `json:"flags"`
+	Mode         int      `json:"mode"`
+	Name         string   `json:"name"`
+	Note         string   `json:"note"`
+	TilesetNames []string `json:"tilesetNames"`
+}
+
+// Tileset modes. VX Ace's field type is MV's world type and VX compatible
+// is drawn like area type.
+const (
+	tilesetModeField   = 0
+	mvTilesetModeWorld = 0
+	mvTilesetModeArea  = 1
+)
+
+func newMVTileset(tileset *Tileset) *mvTileset {
+	mode := mvTilesetModeArea
+	if tileset.Mode == tilesetModeField {
+		mode = mvTilesetModeWorld
+	}
+	// tile IDs and passage flags are the same in MV
+	return &mvTileset{
+		ID:           tileset.ID,
+		Flags:        tileset.Flags.Data,
+		Mode:         mode,
+		Name:         tileset.Name,
+		Note:         tileset.Note,
+		TilesetNames: tileset.TilesetNames,
+	}
+}
+
+type mvMapInfo struct {
+	ID       int    `json:"id"`
+	Expanded bool   `json:"expanded"`
+	Name     string `json:"name"`
+	Order    int    `json:"order"`
+	ParentID int    `json:"parentId"`
+	ScrollX  int    `json:"scrollX"`
+	ScrollY  int    `json:"scrollY"`
+}
+
+type mvEncounter struct {
+	RegionSet []int `json:"regionSet"`
+	TroopID   int   `json:"troopId"`
+	Weight    int   `json:"weight"`
+}
+
+type mvMap struct {
+	AutoplayBGM       bool          `json:"autoplayBgm"`
+	AutoplayBGS       bool          `json:"autoplayBgs"`
+	Battleback1Name   string        `json:"battleback1Name"`
+	Battleback2Name   string        `json:"battleback2Name"`
+	BGM               mvAudio       `json:"bgm"`
+	BGS               mvAudio       `json:"bgs"`
+	DisableDashing    bool          `json:"disableDashing"`
+	DisplayName       string        `json:"displayName"`
+	EncounterList     []mvEncounter `json:"encounterList"`
+	EncounterStep     int           `json:"encounterStep"`
+	Height            int           `json:"height"`
+	Note              string        `json:"note"`
+	ParallaxLoopX     bool          `json:"parallaxLoopX"`
+	ParallaxLoopY     bool          `json:"parallaxLoopY"`
+	ParallaxName      string        `json:"parallaxName"`
+	ParallaxShow      bool          `json:"parallaxShow"`
+	ParallaxSX        int           `json:"parallaxSx"`
+	ParallaxSY        int           `json:"parallaxSy"`
+	ScrollType        int           `json:"scrollType"`
+	SpecifyBattleback bool          `json:"specifyBattleback"`
+	TilesetID         int           `json:"tilesetId"`
+	Width             int           `json:"width"`
+	Data              []int         `json:"data"`
+	Events            []*mvEvent    `json:"events"`
+}
+
+func newMVMap(m *Map) *mvMap {
+	mv := &mvMap{
+		AutoplayBGM:       m.AutoplayBGM,
+		AutoplayBGS:       m.AutoplayBGS,
+		Battleback1Name:   m.Battleback1_Name,
+		Battleback2Name:   m.Battleback2_Name,
+		BGM:               newMVAudio(m.BGM),
+		BGS:               newMVAudio(m.BGS),
+		DisableDashing:    m.DisableDashing,
+		DisplayName:       m.DisplayName,
+		EncounterList:     []mvEncounter{},
+		EncounterStep:     m.EncounterStep,
+		Height:            m.Height,
+		Note:              m.Note,
+		ParallaxLoopX:     m.ParallaxLoopX,
+		ParallaxLoopY:     m.ParallaxLoopY,
+		ParallaxName:      m.ParallaxName,
+		ParallaxShow:      m.ParallaxShow,
+		ParallaxSX:        m.ParallaxSX,
+		ParallaxSY:        m.ParallaxSY,
+		ScrollType:        m.ScrollType,
+		SpecifyBattleback: m.SpecifyBattleback,
+		TilesetID:         m.TilesetID,
+		Width:             m.Width,
+		Data:              newMVMapData(m),
+		Events:            []*mvEvent{nil},
+	}
+	for _, encounter := range m.EncounterList {
+		regionSet := encounter.RegionSet
+		if regionSet == nil {
+			regionSet = []int{}
+		}
+		mv.EncounterList = append(mv.EncounterList, mvEncounter{
+			RegionSet: regionSet,
+			TroopID:   encounter.TroopID,
+			Weight:    encounter.Weight,
+		})
+	}
+	for eventID, event := range m.Events {
+		for len(mv.Events) <= eventID {
+			mv.Events = append(mv.Events, nil)
+		}
+		mv.Events[eventID] = newMVEvent(&event)
+	}
+	return mv
+}
+
+// newMVMapData moves the tiles of each layer into MV's layers. Tile IDs are
+// the same in MV, the upper layer goes on top of MV's 2 upper layers and the
+// shadow and region layer is split in 2.
+func newMVMapData(m *Map) []int {
+	width, height := int(m.Data.X), int(m.Data.Y)
+	data := make([]int, width*height*mvMapLayers)
+	at := func(x, y, z int) *int {
+		return &data[(z*height+y)*width+x]
+	}
+	tileAt := func(x, y, z int) int {
+		i := m.Data.index(x, y, z)
+		if i == -1 {
+			return 0
+		}
+		return int(uint16(m.Data.Data[i]))
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			*at(x, y, 0) = tileAt(x, y, 0)
+			*at(x, y, 1) = tileAt(x, y, 1)
+			*at(x, y, mvMapLayerUpper) = tileAt(x, y, 2)
+			*at(x, y, mvMapLayerShadow) = int(m.ShadowAt(x, y))
+			*at(x, y, mvMapLayerRegion) = m.RegionAt(x, y)
+		}
+	}
+	return data
+}
+
+type mvEvent struct {
+	ID    int           `json:"id"`
+	Name  string        `json:"name"`
+	Note  string        `json:"note"`
+	Pages []mvEventPage `json:"pages"`
+	X     int           `json:"x"`
+	Y     int           `json:"y"`
+}
+
+type mvPageConditions struct {
+	ActorID         int    `json:"actorId"`
+	ActorValid      bool   `json:"actorValid"`
+	ItemID          int    `json:"itemId"`
+	ItemValid       bool   `json:"itemValid"`
+	SelfSwitchCh    string `json:"selfSwitchCh"`
+	SelfSwitchValid bool   `json:"selfSwitchValid"`
+	Switch1ID       int    `json:"switch1Id"`
+	Switch1Valid    bool   `json:"switch1Valid"`
+	Switch2ID       int    `json:"switch2Id"`
+	Switch2Valid    bool   `json:"switch2Valid"`
+	VariableID      int    `json:"variableId"`
+	VariableValid   bool   `json:"variableValid"`
+	VariableValue   int    `json:"variableValue"`
+}
+
+type mvEventImage struct {
+	CharacterIndex int    `json:"characterIndex"`
+	CharacterName  string `json:"characterName"`
+	Direction      int    `json:"direction"`
+	Pattern        int    `json:"pattern"`
+	TileID         int    `json:"tileId"`
+}
+
+type mvEventCommand struct {
+	Code       int           `json:"code"`
+	Indent     int           `json:"indent"`
+	Parameters []interface{} `json:"parameters"`
+}
+
+type mvMoveCommand struct {
+	Code       int           `json:"code"`
+	Parameters []interface{} `json:"parameters"`
+}
+
+type mvMoveRoute struct {
+	List      []mvMoveCommand `json:"list"`
+	Repeat    bool            `json:"repeat"`
+	Skippable bool            `json:"skippable"`
+	Wait      bool            `json:"wait"`
+}
+
+type mvEventPage struct {
+	Conditions    mvPageConditions `json:"conditions"`
+	DirectionFix  bool             `json:"directionFix"`
+	Image         mvEventImage     `json:"image"`
+	List          []mvEventCommand `json:"list"`
+	MoveFrequency int              `json:"moveFrequency"`
+	MoveRoute     mvMoveRoute      `json:"moveRoute"`
+	MoveSpeed     int              `json:"moveSpeed"`
+	MoveType      int              `json:"moveType"`
+	PriorityType  int              `json:"priorityType"`
+	StepAnime     bool             `json:"stepAnime"`
+	Through       bool             `json:"through"`
+	Trigger       int              `json:"trigger"`
+	WalkAnime     bool             `json:"walkAnime"`
+}
+
+func newMVEvent(event *MapEvent) *mvEvent {
+	mv := &mvEvent{
+		ID:    event.ID,
+		Name:  event.Name,
+		Pages: make([]mvEventPage, len(event.Pages)),
+		X:     event.X,
+		Y:     event.Y,
+	}
+	for i := range event.Pages {
+		page := &event.Pages[i]
+		condition := &page.Condition
+		mv.Pages[i] = mvEventPage{
+			Conditions: mvPageConditions{
+				ActorID:         condition.ActorID,
+				ActorValid:      condition.ActorValid,
+				ItemID:          condition.ItemID,
+				ItemValid:       condition.ItemValid,
+				SelfSwitchCh:    condition.SelfSwitchCH,
+				SelfSwitchValid: condition.SelfSwitchValid,
+				Switch1ID:       condition.Switch1ID,
+				Switch1Valid:    condition.Switch1Valid,
+				Switch2ID:       condition.Switch2ID,
+				Switch2Valid:    condition.Switch2Valid,
+				VariableID:      condition.VariableID,
+				VariableValid:   condition.VariableValid,
+				VariableValue:   condition.VariableValue,
+			},
+			DirectionFix: page.DirectionFix,
+			Image: mvEventImage{
+				CharacterIndex: page.Graphic.CharacterIndex,
+				CharacterName:  page.Graphic.CharacterName,
+				Direction:      page.Graphic.Direction,
+				Pattern:        page.Graphic.Pattern,
+				TileID:         page.Graphic.Tile,
+			},
+			List:          newMVEventCommands(page.List),
+			MoveFrequency: page.MoveFrequency,
+			MoveRoute:     newMVMoveRoute(&page.MoveRoute),
+			MoveSpeed:     page.MoveSpeed,
+			MoveType:      page.MoveType,
+			PriorityType:  page.PriorityType,
+			StepAnime:     page.StepAnime,
+			Through:       page.Through,
+			Trigger:       page.Trigger,
+			WalkAnime:     page.WalkAnime,
+		}
+	}
+	return mv
+}
+
+func newMVMoveRoute(route *MoveRoute) mvMoveRoute {
+	mv := mvMoveRoute{
+		List:      make([]mvMoveCommand, len(route.List)),
+		Repeat:    route.Repeat,
+		Skippable: route.Skippable,
+		Wait:      route.Wait,
+	}
+	for i, item := range route.List {
+		mv.List[i] = mvMoveCommand{Code: item.Code, Parameters: newMVParameters(item.Parameters)}
+	}
+	return mv
+}
+
+// mvButtons are the names MV uses for the Input constants of VX Ace, which
+// Conditional Branch stores as numbers. X, Y and Z have no equivalent.
+var mvButtons = map[int]string{
+	2:  "down",
+	4:  "left",
+	6:  "right",
+	8:  "up",
+	11: "shift",
+	12: "cancel",
+	13: "ok",
+	17: "pageup",
+	18: "pagedown",
+}
+
+// Show Choices cancel types in MV, other values are the index of a choice
+const (
+	mvChoiceCancelBranch   = -2
+	mvChoiceCancelDisallow = -1
+)
+
+// newMVEventCommands converts the parameters of each command that differs in MV
+func newMVEventCommands(list []EventCommand) []mvEventCommand {
+	commands := make([]mvEventCommand, len(list))
+	for i, command := range list {
+		params := newMVParameters(command.Parameters)
+		switch command.Code {
+		case commandShowChoices:
+			// VX Ace's cancel type is 0 to disallow, 1 to n for a choice and
+			// n+1 for a separate branch
+			choices, _ := param(params, 0).([]interface{})
+			cancelType := paramInt(params, 1)
+			switch {
+			case cancelType == 0:
+				cancelType = mvChoiceCancelDisallow
+			case cancelType > len(choices):
+				cancelType = mvChoiceCancelBranch
+			default:
+				cancelType--
+			}
+			// default choice, position and background
+			params = []interface{}{param(params, 0), cancelType, 0, 2, 0}
+		case commandSelectItem:
+			// MV can select other item types, key items are what VX Ace selects
+			params = append(params, 2)
+		case commandConditionalBranch:
+			if paramInt(params, 0) == 11 {
+				if button, ok := mvButtons[paramInt(params, 1)]; ok {
+					params[1] = button
+				}
+			}
+		case commandChangeActorGraphic:
+			// MV adds the sideview battler
+			params = append(params, "")
+		case commandChangeEquipment:
+			// VX Ace's equipment types start from 0 for weapons, MV's start from 1
+			if len(params) > 1 {
+				params[1] = paramInt(params, 1) + 1
+			}
+		case commandGetLocationInfo:
+			// MV has 4 tile layers before the region, VX Ace's upper layer is
+			// exported as MV's 4th layer
+			switch paramInt(params, 1) {
+			case locationInfoUpperLayer:
+				params[1] = mvLocationInfoUpperLayer
+			case locationInfoRegion:
+				params[1] = mvLocationInfoRegion
+			}
+		}
+		commands[i] = mvEventCommand{Code: command.Code, Indent: command.Indent, Parameters: params}
+	}
+	return commands
+}
+
+func newMVParameters(params []interface{}) []interface{} {
+	mv := make([]interface{}, len(params))
+	for i, value := range params {
+		mv[i] = newMVValue(value)
+	}
+	return mv
+}
+
+// newMVValue converts a decoded parameter, objects such as RPG::AudioFile and
+// RPG::MoveRoute have their fields renamed and tones and colors become arrays
+// like in MV
+func newMVValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []interface{}:
+		return newMVParameters(value)
+	case map[string]interface{}:
+		fields := make(map[string]interface{}, len(value))
+		for name, field := range value {
+			fields[mvFieldName(name)] = newMVValue(field)
+		}
+		// audio files have a pan in MV
+		if _, ok := value["@volume"]; ok {
+			if _, ok := value["@pan"]; !ok {
+				fields["pan"] = 0
+			}
+		}
+		return fields
+	case *Tone:
+		return []float64{float64(value.Red), float64(value.Green), float64(value.Blue), float64(value.Gray)}
+	case *ToneFloat:
+		return []float64{value.Red, value.Green, value.Blue, value.Gray}
+	case *Color:
+		return []float64{value.Red, value.Green, value.Blue, value.Alpha}
+	case *Table:
+		return newMVTable(value)
+	}
+	return value
+}
+
+// newMVTable converts a table to nested arrays indexed by x then y, ie. the
+// parameters of a class by parameter then level and the cells of an
+// animation frame by cell then value
+func newMVTable(table *Table) interface{} {
+	if table.Y <= 1 || table.Z > 1 {
+		// tables that aren't 2D are left as they are stored
+		return table.Data
+	}
+	rows := make([][]int16, table.X)
+	for x := range rows {
+		rows[x] = make([]int16, table.Y)
+		for y := range rows[x] {
+			rows[x][y] = table.GetInt32(int32(x), int32(y), 0)
+		}
+	}
+	return rows
+}
+
+// mvFieldName converts a Ruby instance variable name to MV's camel case,
+// ie. "@character_name" to "characterName"
+func mvFieldName(name string) string {
+	parts := strings.Split(strings.TrimPrefix(name, "@"), "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+type mvCommonEvent struct {
+	ID       int              `json:"id"`
+	List     []mvEventCommand `json:"list"`
+	Name     string           `json:"name"`
+	SwitchID int              `json:"switchId"`
+	Trigger  int              `json:"trigger"`
+}
+
+type mvTroopMember struct {
+	EnemyID int  `json:"enemyId"`
+	X       int  `json:"x"`
+	Y       int  `json:"y"`
+	Hidden  bool `json:"hidden"`
+}
+
+type mvTroopConditions struct {
+	ActorHP     int  `json:"actorHp"`
+	ActorID     int  `json:"actorId"`
+	ActorValid  bool `json:"actorValid"`
+	EnemyHP     int  `json:"enemyHp"`
+	EnemyIndex  int  `json:"enemyIndex"`
+	EnemyValid  bool `json:"enemyValid"`
+	SwitchID    int  `json:"switchId"`
+	SwitchValid bool `json:"switchValid"`
+	TurnA       int  `json:"turnA"`
+	TurnB       int  `json:"turnB"`
+	TurnEnding  bool `json:"turnEnding"`
+	TurnValid   bool `json:"turnValid"`
+}
+
+type mvTroopPage struct {
+	Conditions mvTroopConditions `json:"conditions"`
+	List       []mvEventCommand  `json:"list"`
+	Span       int               `json:"span"`
+}
+
+type mvTroop struct {
+	ID      int             `json:"id"`
+	Members []mvTroopMember `json:"members"`
+	Name    string          `json:"name"`
+	Pages   []mvTroopPage   `json:"pages"`
+}
+
+func newMVTroop(troop *Troop) *mvTroop {
+	mv := &mvTroop{
+		ID:      troop.ID,
+		Members: make([]mvTroopMember, len(troop.Members)),
+		Name:    troop.Name,
+		Pages:   make([]mvTroopPage, len(troop.Pages)),
+	}
+	for i, member := range troop.Members {
+		mv.Members[i] = mvTroopMember{
+			EnemyID: member.EnemyID,
+			X:       int(float64(member.X) * mvScreenScale),
+			Y:       int(float64(member.Y) * mvScreenScale),
+			Hidden:  member.Hidden,
+		}
+	}
+	for i := range troop.Pages {
+		page := &troop.Pages[i]
+		condition := &page.Condition
+		mv.Pages[i] = mvTroopPage{
+			Conditions: mvTroopConditions{
+				ActorHP:     condition.ActorHP,
+				ActorID:     condition.ActorID,
+				ActorValid:  condition.ActorValid,
+				EnemyHP:     condition.EnemyHP,
+				EnemyIndex:  condition.EnemyIndex,
+				EnemyValid:  condition.EnemyValid,
+				SwitchID:    condition.SwitchID,
+				SwitchValid: condition.SwitchValid,
+				TurnA:       condition.TurnA,
+				TurnB:       condition.TurnB,
+				TurnEnding:  condition.TurnEnding,
+				TurnValid:   condition.TurnValid,
+			},
+			List: newMVEventCommands(page.List),
+			Span: page.Span,
+		}
+	}
+	return mv
+}
+
+// mvItem is Item without features as they aren't used on items and MV
+// doesn't have them. Item, ItemDamage and ItemEffect already use MV's names.
+type mvItem struct {
+	*Item
+	Features []ActorFeature `json:"features,omitempty"`
+}
+
+func newMVItem(item *Item) *mvItem {
+	return &mvItem{Item: item}
+}
+
+type mvEquipItem struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	IconIndex   int       `json:"iconIndex"`
+	Description string    `json:"description"`
+	Traits      []mvTrait `json:"traits"`
+	Note        string    `json:"note"`
+	Price       int       `json:"price"`
+	EquipTypeID int       `json:"etypeId"`
+	Params      []int     `json:"params"`
+}
+
+func newMVEquipItem(item *BaseItem, price, equipTypeID int, params []int) mvEquipItem {
+	return mvEquipItem{
+		ID:          item.ID,
+		Name:        item.Name,
+		IconIndex:   item.IconIndex,
+		Description: item.Description,
+		Traits:      newMVTraits(item.Features),
+		Note:        item.Note,
+		Price:       price,
+		// VX Ace's equipment types start from 0 for weapons, MV's start from 1
+		EquipTypeID: equipTypeID + 1,
+		Params:      params,
+	}
+}
+
+type mvWeapon struct {
+	mvEquipItem
+	WeaponTypeID int `json:"wtypeId"`
+	AnimationID  int `json:"animationId"`
+}
+
+func newMVWeapon(weapon *Weapon) *mvWeapon {
+	return &mvWeapon{
+		mvEquipItem:  newMVEquipItem(&weapon.BaseItem, weapon.Price, weapon.EquipTypeID, weapon.Params),
+		WeaponTypeID: weapon.WeaponTypeID,
+		AnimationID:  weapon.AnimationID,
+	}
+}
+
+type mvArmor struct {
+	mvEquipItem
+	ArmorTypeID int `json:"atypeId"`
+}
+
+func newMVArmor(armor *Armor) *mvArmor {
+	return &mvArmor{
+		mvEquipItem: newMVEquipItem(&armor.BaseItem, armor.Price, armor.EquipTypeID, armor.Params),
+		ArmorTypeID: armor.ArmorTypeID,
+	}
+}
@@ -0,0 +1,97 @@
+package rmvx
+
+// mapLayerShadowRegion is the z-index of the Map.Data layer that stores
+// shadows and regions.
+//
+// The low 4 bits are shadow bits and the high byte is the region ID.
+// (See Game_Map#region_id in RPG Maker VX Ace's default scripts)
+const mapLayerShadowRegion = 3
+
+const (
+	shadowMask  = 0x0F
+	regionShift = 8
+	regionMask  = 0xFF
+)
+
+// Shadow is the set of quarter-tile shadows drawn on a map tile
+type Shadow uint8
+
+const (
+	ShadowTopLeft Shadow = 1 << iota
+	ShadowTopRight
+	ShadowBottomLeft
+	ShadowBottomRight
+
+	ShadowNone Shadow = 0
+	ShadowAll  Shadow = ShadowTopLeft | ShadowTopRight | ShadowBottomLeft | ShadowBottomRight
+)
+
+// Has returns true if all the given quarters are shadowed
+func (shadow Shadow) Has(quarters Shadow) bool {
+	return shadow&quarters == quarters
+}
+
+// Point is a tile position on a map
+type Point struct {
+	X, Y int
+}
+
+// RegionAt returns the region ID of the tile or 0 if the position is out of bounds
+func (m *Map) RegionAt(x, y int) int {
+	i := m.Data.index(x, y, mapLayerShadowRegion)
+	if i == -1 {
+		return 0
+	}
+	return (int(m.Data.Data[i]) >> regionShift) & regionMask
+}
+
+// SetRegionAt sets the region ID (0-255) of the tile, preserving its shadow bits.
+//
+// It does nothing if the position is out of bounds.
+func (m *Map) SetRegionAt(x, y int, regionID int) {
+	i := m.Data.index(x, y, mapLayerShadowRegion)
+	if i == -1 {
+		return
+	}
+	value := uint16(m.Data.Data[i])&^(regionMask<<regionShift) | uint16(regionID&regionMask)<<regionShift
+	m.Data.Data[i] = int16(value)
+}
+
+// ShadowAt returns the shadowed quarters of the tile or ShadowNone if the position is out of bounds
+func (m *Map) ShadowAt(x, y int) Shadow {
+	i := m.Data.index(x, y, mapLayerShadowRegion)
+	if i == -1 {
+		return ShadowNone
+	}
+	return Shadow(m.Data.Data[i] & shadowMask)
+}
+
+// SetShadowAt sets the shadowed quarters of the tile, preserving its region ID.
+//
+// It does nothing if the position is out of bounds.
+func (m *Map) SetShadowAt(x, y int, shadow Shadow) {
+	i := m.Data.index(x, y, mapLayerShadowRegion)
+	if i == -1 {
+		return
+	}
+	value := uint16(m.Data.Data[i])&^shadowMask | uint16(shadow&ShadowAll)
+	m.Data.Data[i] = int16(value)
+}
+
+// RegionIndex returns every tile position grouped by region ID.
+//
+// Tiles without a region (region ID 0) are not included. Positions are
+// ordered row by row, from top-left to bottom-right.
+func (m *Map) RegionIndex() map[int][]Point {
+	index := make(map[int][]Point)
+	for y := 0; y < int(m.Data.Y); y++ {
+		for x := 0; x < int(m.Data.X); x++ {
+			regionID := m.RegionAt(x, y)
+			if regionID == 0 {
+				continue
+			}
+			index[regionID] = append(index[regionID], Point{X: x, Y: y})
+		}
+	}
+	return index
+}
@@ -0,0 +1,103 @@
+package rmvx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// RGSS3ArchiveName is the name of the archive Game.exe loads Data and Graphics from
+const RGSS3ArchiveName = "Game.rgss3a"
+
+// rgss3aHeader is "RGSSAD" followed by the version
+var rgss3aHeader = []byte("RGSSAD\x00\x03")
+
+// rgss3aKey is the key written in the header. Any key works, a fixed one
+// makes archives of the same files identical.
+const rgss3aKey = 0x5A17C3E1
+
+// RGSS3AWriter builds an encrypted archive like "Compress Game Data" in the
+// editor. Files are kept in memory until WriteTo is called.
+type RGSS3AWriter struct {
+	files []*rgss3aFile
+}
+
+type rgss3aFile struct {
+	name string
+	data bytes.Buffer
+}
+
+// NewRGSS3AWriter returns an empty archive
+func NewRGSS3AWriter() *RGSS3AWriter {
+	return &RGSS3AWriter{}
+}
+
+// Create adds a file to the archive, ie. "Data/Actors.rvdata2", and returns
+// a writer for its contents
+func (archive *RGSS3AWriter) Create(name string) (io.Writer, error) {
+	file := &rgss3aFile{name: name}
+	archive.files = append(archive.files, file)
+	return &file.data, nil
+}
+
+// WriteTo writes the archive with every file added so far
+func (archive *RGSS3AWriter) WriteTo(w io.Writer) (int64, error) {
+	key := uint32(rgss3aKey)
+	magic := key*9 + 3
+	var buf bytes.Buffer
+	buf.Write(rgss3aHeader)
+	writeUint32(&buf, key)
+
+	// the table of files is followed by an entry with an offset of 0
+	offset := len(rgss3aHeader) + 4 + 16
+	for _, file := range archive.files {
+		offset += 16 + len(file.name)
+	}
+	for i, file := range archive.files {
+		// names use Windows path separators
+		name := []byte(strings.ReplaceAll(file.name, "/", "\\"))
+		writeUint32(&buf, uint32(offset)^magic)
+		writeUint32(&buf, uint32(file.data.Len())^magic)
+		writeUint32(&buf, rgss3aFileMagic(magic, i)^magic)
+		writeUint32(&buf, uint32(len(name))^magic)
+		for i := range name {
+			name[i] ^= byte(magic >> (8 * uint(i%4)))
+		}
+		buf.Write(name)
+		offset += file.data.Len()
+	}
+	for i := 0; i < 4; i++ {
+		writeUint32(&buf, magic)
+	}
+	for i, file := range archive.files {
+		data := append([]byte(nil), file.data.Bytes()...)
+		xorRGSS3AData(data, rgss3aFileMagic(magic, i))
+		buf.Write(data)
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// rgss3aFileMagic is the starting key of the i-th file's contents, which is
+// stored in the table of files
+func rgss3aFileMagic(magic uint32, i int) uint32 {
+	return magic + uint32(i)*7
+}
+
+// xorRGSS3AData encrypts or decrypts the contents of a file in the archive,
+// the key changes after every 4 bytes
+func xorRGSS3AData(data []byte, magic uint32) {
+	for i := range data {
+		if i > 0 && i%4 == 0 {
+			magic = magic*7 + 3
+		}
+		data[i] ^= byte(magic >> (8 * uint(i%4)))
+	}
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
@@ -0,0 +1,456 @@
+package rmvx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"strconv"
+
+	"github.com/silbinarywolf/rmvx/internal/rubymarshal"
+)
+
+var (
+	ErrSaveNotFound = errors.New("save not found")
+	// ErrInvalidSave is returned when editing a save that doesn't have the
+	// objects written by RPG Maker VX Ace
+	ErrInvalidSave = errors.New("invalid save")
+)
+
+const (
+	saveFilePrefix    = "Save"
+	saveFileMinDigits = 2
+)
+
+// SaveFilename returns the path of the save file relative to the project directory,
+// ie. "Save01.rvdata2" for slot 1
+//
+// RPG Maker VX Ace names save files with sprintf("Save%02d.rvdata2", index + 1)
+// so the first slot on the save screen is slot 1.
+func SaveFilename(slot int) string {
+	slotPart := strconv.Itoa(slot)
+	if len(slotPart) < saveFileMinDigits {
+		slotPart = "0" + slotPart
+	}
+	return saveFilePrefix + slotPart + dataFileExt
+}
+
+// KeyValue is a key-value pair of a Ruby hash with keys that can't be used
+// as a Go map key, such as the arrays used by self switches
+type KeyValue = rubymarshal.KeyValue
+
+// Save is a save file written by DataManager.save_game
+type Save struct {
+	// Header is shown on the save and load screens
+	Header       SaveHeader
+	System       GameSystem       `ruby:"system"`
+	Timer        GameTimer        `ruby:"timer"`
+	Message      GameMessage      `ruby:"message"`
+	Switches     GameSwitches     `ruby:"switches"`
+	Variables    GameVariables    `ruby:"variables"`
+	SelfSwitches GameSelfSwitches `ruby:"self_switches"`
+	Actors       GameActors       `ruby:"actors"`
+	Party        GameParty        `ruby:"party"`
+	Troop        GameTroop        `ruby:"troop"`
+	Map          GameMap          `ruby:"map"`
+	Player       GamePlayer       `ruby:"player"`
+}
+
+// SaveHeader is the first Marshal document in a save file
+type SaveHeader struct {
+	// Characters are the graphics of the party members, used to draw the
+	// party on the save screen
+	Characters      []SaveCharacter
+	PlaytimeSeconds int
+}
+
+// SaveCharacter is the graphic of a party member in the save header
+type SaveCharacter struct {
+	CharacterName  string
+	CharacterIndex int
+}
+
+// saveHeaderData is the header as it's stored, the characters are
+// [character_name, character_index] arrays
+type saveHeaderData struct {
+	Characters      [][2]interface{} `ruby:"characters"`
+	PlaytimeSeconds int              `ruby:"playtime_s"`
+}
+
+// GameSystem is $game_system
+type GameSystem struct {
+	SaveDisabled      bool `ruby:"@save_disabled"`
+	MenuDisabled      bool `ruby:"@menu_disabled"`
+	EncounterDisabled bool `ruby:"@encounter_disabled"`
+	FormationDisabled bool `ruby:"@formation_disabled"`
+	BattleCount       int  `ruby:"@battle_count"`
+	SaveCount         int  `ruby:"@save_count"`
+	// VersionID is System.VersionID when the game was saved, it's used to
+	// refresh the map if the game data has changed since
+	VersionID int `ruby:"@version_id"`
+	// WindowTone is nil if it hasn't been changed from System.WindowTone
+	WindowTone *Tone `ruby:"@window_tone"`
+	// BattleBGM is nil if it hasn't been changed from System.BattleBGM
+	BattleBGM *BackgroundSound `ruby:"@battle_bgm"`
+	// BattleEndMusic is nil if it hasn't been changed from System.BattleEndMusic
+	BattleEndMusic *BackgroundSound `ruby:"@battle_end_me"`
+	// FramesOnSave is Graphics.frame_count when the game was saved
+	FramesOnSave int              `ruby:"@frames_on_save"`
+	BGMOnSave    *BackgroundSound `ruby:"@bgm_on_save"`
+	BGSOnSave    *BackgroundSound `ruby:"@bgs_on_save"`
+}
+
+// GameTimer is $game_timer, the count is in frames
+type GameTimer struct {
+	Count   int  `ruby:"@count"`
+	Working bool `ruby:"@working"`
+}
+
+// GameMessage is $game_message, it's usually empty as the game can only be
+// saved from the menu
+type GameMessage struct {
+	Texts                []string `ruby:"@texts"`
+	Choices              []string `ruby:"@choices"`
+	FaceName             string   `ruby:"@face_name"`
+	FaceIndex            int      `ruby:"@face_index"`
+	Background           int      `ruby:"@background"`
+	Position             int      `ruby:"@position"`
+	ChoiceCancelType     int      `ruby:"@choice_cancel_type"`
+	NumInputVariableID   int      `ruby:"@num_input_variable_id"`
+	NumInputDigitsMax    int      `ruby:"@num_input_digits_max"`
+	ItemChoiceVariableID int      `ruby:"@item_choice_variable_id"`
+	ScrollMode           bool     `ruby:"@scroll_mode"`
+	ScrollSpeed          int      `ruby:"@scroll_speed"`
+	ScrollNoFast         bool     `ruby:"@scroll_no_fast"`
+	Visible              bool     `ruby:"@visible"`
+}
+
+// GameSwitches is $game_switches
+type GameSwitches struct {
+	// Data is indexed by switch ID, switches that have never been set are false
+	Data []bool `ruby:"@data"`
+}
+
+// Get returns the value of the switch
+func (switches *GameSwitches) Get(switchID int) bool {
+	if switchID < 0 || switchID >= len(switches.Data) {
+		return false
+	}
+	return switches.Data[switchID]
+}
+
+// GameVariables is $game_variables
+type GameVariables struct {
+	// Data is indexed by variable ID. Variables are usually integers but
+	// scripts can store any value in them.
+	Data []interface{} `ruby:"@data"`
+}
+
+// Get returns the value of the variable or 0 if it's not an integer
+func (variables *GameVariables) Get(variableID int) int {
+	if variableID < 0 || variableID >= len(variables.Data) {
+		return 0
+	}
+	value, _ := variables.Data[variableID].(int)
+	return value
+}
+
+// GameSelfSwitches is $game_self_switches
+type GameSelfSwitches struct {
+	// Data is keyed by [map_id, event_id, "A"] arrays
+	Data []KeyValue `ruby:"@data"`
+}
+
+// Get returns the value of the self switch, name is "A", "B", "C" or "D"
+func (selfSwitches *GameSelfSwitches) Get(mapID, eventID int, name string) bool {
+	for _, pair := range selfSwitches.Data {
+		key, ok := pair.Key.([]interface{})
+		if !ok || len(key) != 3 {
+			continue
+		}
+		if key[0] == mapID && key[1] == eventID && key[2] == name {
+			value, _ := pair.Value.(bool)
+			return value
+		}
+	}
+	return false
+}
+
+// GameActors is $game_actors
+type GameActors struct {
+	// Data is indexed by actor ID, actors that haven't been used yet are nil
+	Data []*GameActor `ruby:"@data"`
+}
+
+// Get returns the actor with the given ID or nil if it hasn't been used yet
+func (actors *GameActors) Get(actorID int) *GameActor {
+	if actorID < 0 || actorID >= len(actors.Data) {
+		return nil
+	}
+	return actors.Data[actorID]
+}
+
+// GameActor is an actor in $game_actors
+type GameActor struct {
+	ActorID        int    `ruby:"@actor_id"`
+	Name           string `ruby:"@name"`
+	Nickname       string `ruby:"@nickname"`
+	CharacterName  string `ruby:"@character_name"`
+	CharacterIndex int    `ruby:"@character_index"`
+	FaceName       string `ruby:"@face_name"`
+	FaceIndex      int    `ruby:"@face_index"`
+	ClassID        int    `ruby:"@class_id"`
+	Level          int    `ruby:"@level"`
+	// Exp is the experience for each class the actor has been, keyed by class ID
+	Exp    map[int]int `ruby:"@exp"`
+	HP     int         `ruby:"@hp"`
+	MP     int         `ruby:"@mp"`
+	TP     float64     `ruby:"@tp"`
+	Hidden bool        `ruby:"@hidden"`
+	// ParamPlus is added to each of the 8 parameters, ie. by using items
+	ParamPlus []int `ruby:"@param_plus"`
+	// States is a list of state IDs
+	States []int `ruby:"@states"`
+	// Skills is a list of learned skill IDs
+	Skills []int `ruby:"@skills"`
+	// Equips has an entry for each equipment slot
+	Equips    []GameBaseItem `ruby:"@equips"`
+	LastSkill GameBaseItem   `ruby:"@last_skill"`
+}
+
+// GameBaseItem is a reference to a skill, item, weapon or armor
+type GameBaseItem struct {
+	// Class is "RPG::Skill", "RPG::Item", "RPG::Weapon", "RPG::Armor"
+	// or empty if nothing is referenced
+	Class  string `ruby:"@class"`
+	ItemID int    `ruby:"@item_id"`
+}
+
+// GameParty is $game_party
+type GameParty struct {
+	InBattle bool `ruby:"@in_battle"`
+	Gold     int  `ruby:"@gold"`
+	Steps    int  `ruby:"@steps"`
+	// Actors is a list of actor IDs in the order of the party
+	Actors []int `ruby:"@actors"`
+	// Items, Weapons and Armors are the number held keyed by ID
+	Items         map[int]int  `ruby:"@items"`
+	Weapons       map[int]int  `ruby:"@weapons"`
+	Armors        map[int]int  `ruby:"@armors"`
+	MenuActorID   int          `ruby:"@menu_actor_id"`
+	TargetActorID int          `ruby:"@target_actor_id"`
+	LastItem      GameBaseItem `ruby:"@last_item"`
+}
+
+// GameTroop is $game_troop, it's only in use if the game was saved during battle
+type GameTroop struct {
+	InBattle  bool `ruby:"@in_battle"`
+	TroopID   int  `ruby:"@troop_id"`
+	TurnCount int  `ruby:"@turn_count"`
+}
+
+// GameMap is $game_map
+type GameMap struct {
+	MapID     int `ruby:"@map_id"`
+	TilesetID int `ruby:"@tileset_id"`
+	// DisplayX and DisplayY are the top-left of the screen in tiles
+	DisplayX        float64            `ruby:"@display_x"`
+	DisplayY        float64            `ruby:"@display_y"`
+	ParallaxName    string             `ruby:"@parallax_name"`
+	Battleback1Name string             `ruby:"@battleback1_name"`
+	Battleback2Name string             `ruby:"@battleback2_name"`
+	NameDisplay     bool               `ruby:"@name_display"`
+	NeedRefresh     bool               `ruby:"@need_refresh"`
+	Events          map[int]*GameEvent `ruby:"@events"`
+	Interpreter     GameInterpreter    `ruby:"@interpreter"`
+	Screen          GameScreen         `ruby:"@screen"`
+}
+
+// GameScreen is the screen effects of a map or battle
+type GameScreen struct {
+	Brightness   int    `ruby:"@brightness"`
+	Tone         Tone   `ruby:"@tone"`
+	WeatherType  string `ruby:"@weather_type"`
+	WeatherPower int    `ruby:"@weather_power"`
+}
+
+// GameCharacter is the state of an event or the player on the map
+type GameCharacter struct {
+	X              int     `ruby:"@x"`
+	Y              int     `ruby:"@y"`
+	RealX          float64 `ruby:"@real_x"`
+	RealY          float64 `ruby:"@real_y"`
+	Direction      int     `ruby:"@direction"`
+	Pattern        int     `ruby:"@pattern"`
+	CharacterName  string  `ruby:"@character_name"`
+	CharacterIndex int     `ruby:"@character_index"`
+	TileID         int     `ruby:"@tile_id"`
+	MoveSpeed      int     `ruby:"@move_speed"`
+	MoveFrequency  int     `ruby:"@move_frequency"`
+	Opacity        int     `ruby:"@opacity"`
+	PriorityType   int     `ruby:"@priority_type"`
+	DirectionFix   bool    `ruby:"@direction_fix"`
+	Through        bool    `ruby:"@through"`
+	Transparent    bool    `ruby:"@transparent"`
+}
+
+// GameEvent is an event in $game_map
+type GameEvent struct {
+	GameCharacter
+	ID       int  `ruby:"@id"`
+	MapID    int  `ruby:"@map_id"`
+	Erased   bool `ruby:"@erased"`
+	Starting bool `ruby:"@starting"`
+	// Event is the event data from the map file
+	Event MapEvent `ruby:"@event"`
+	// Page is nil if no page conditions are met
+	Page *MapEventPage `ruby:"@page"`
+	// Interpreter is only set for parallel events that are running
+	Interpreter *GameInterpreter `ruby:"@interpreter"`
+}
+
+// GamePlayer is $game_player
+type GamePlayer struct {
+	GameCharacter
+	// VehicleType is "walk", "boat", "ship" or "airship"
+	VehicleType  string `ruby:"@vehicle_type"`
+	Transferring bool   `ruby:"@transferring"`
+	NewMapID     int    `ruby:"@new_map_id"`
+	NewX         int    `ruby:"@new_x"`
+	NewY         int    `ruby:"@new_y"`
+	NewDirection int    `ruby:"@new_direction"`
+	// EncounterCount is the number of steps until the next random encounter
+	EncounterCount int `ruby:"@encounter_count"`
+}
+
+// GameInterpreter is the state of a running event
+//
+// It's saved with marshal_dump as [depth, map_id, event_id, list, index + 1, branch].
+type GameInterpreter struct {
+	Depth   int
+	MapID   int
+	EventID int
+	// List is nil if the interpreter isn't running
+	List []EventCommand
+	// Index is the command to run when the game is loaded
+	Index int
+	// Branch is the result of conditional branches and choices keyed by indent
+	Branch map[int]interface{}
+}
+
+func loadGameInterpreter(data interface{}, val reflect.Value) {
+	fields, ok := data.([]interface{})
+	if !ok || len(fields) != 6 {
+		panic(errors.New("Game_Interpreter: bad file format"))
+	}
+	var value GameInterpreter
+	value.Depth, _ = fields[0].(int)
+	value.MapID, _ = fields[1].(int)
+	value.EventID, _ = fields[2].(int)
+	if list, ok := fields[3].([]interface{}); ok {
+		value.List = make([]EventCommand, len(list))
+		for i, item := range list {
+			command, _ := item.(map[string]interface{})
+			value.List[i].Code, _ = command["@code"].(int)
+			value.List[i].Indent, _ = command["@indent"].(int)
+			value.List[i].Parameters, _ = command["@parameters"].([]interface{})
+		}
+	}
+	value.Index, _ = fields[4].(int)
+	if branch, ok := fields[5].(map[string]interface{}); ok {
+		// integer hash keys are decoded as strings in interface{} values
+		value.Branch = make(map[int]interface{}, len(branch))
+		for key, result := range branch {
+			indent, err := strconv.Atoi(key)
+			if err != nil {
+				continue
+			}
+			value.Branch[indent] = result
+		}
+	}
+	setUserDefined("Game_Interpreter", reflect.ValueOf(value), val)
+}
+
+// LoadSave loads the save file in the given slot, where slot 1 is "Save01.rvdata2"
+//
+// Fields added to the save by scripts are skipped.
+func LoadSave(fsys fs.FS, slot int) (*Save, error) {
+	bytesData, err := readSaveFile(fsys, slot)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSave(bytesData)
+}
+
+func decodeSave(bytesData []byte) (*Save, error) {
+	d := newSaveDecoder(bytesData)
+	header, err := decodeSaveHeader(d)
+	if err != nil {
+		return nil, err
+	}
+	save := &Save{Header: header}
+	if err := d.Decode(save); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("save contents: %w", err)
+	}
+	return save, nil
+}
+
+// LoadSaveHeader loads only the header of the save file in the given slot,
+// which is enough to list saves like the load screen does
+func LoadSaveHeader(fsys fs.FS, slot int) (SaveHeader, error) {
+	bytesData, err := readSaveFile(fsys, slot)
+	if err != nil {
+		return SaveHeader{}, err
+	}
+	return decodeSaveHeader(newSaveDecoder(bytesData))
+}
+
+func readSaveFile(fsys fs.FS, slot int) ([]byte, error) {
+	if slot <= 0 {
+		return nil, fmt.Errorf("%w: invalid slot: %d", ErrSaveNotFound, slot)
+	}
+	f, err := fsys.Open(SaveFilename(slot))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %d: %s", ErrSaveNotFound, slot, err)
+		}
+		return nil, err
+	}
+	bytesData, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return bytesData, nil
+}
+
+// newSaveDecoder returns a decoder for the header and contents of a save,
+// which are written one after the other with Marshal.dump
+func newSaveDecoder(bytesData []byte) *rubymarshal.Decoder {
+	d := rubymarshal.NewDecoder(bytesData)
+	addUserDefinedLoads(d, &LoadOptions{})
+	d.AddUserMarshalLoad("Game_Interpreter", loadGameInterpreter)
+	d.AllowUnknownFields()
+	return d
+}
+
+func decodeSaveHeader(d *rubymarshal.Decoder) (SaveHeader, error) {
+	var data saveHeaderData
+	if err := d.Decode(&data); err != nil {
+		return SaveHeader{}, fmt.Errorf("save header: %w", err)
+	}
+	header := SaveHeader{PlaytimeSeconds: data.PlaytimeSeconds}
+	for _, character := range data.Characters {
+		characterName, _ := character[0].(string)
+		characterIndex, _ := character[1].(int)
+		header.Characters = append(header.Characters, SaveCharacter{
+			CharacterName:  characterName,
+			CharacterIndex: characterIndex,
+		})
+	}
+	return header, nil
+}
@@ -0,0 +1,323 @@
+package rmvx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/silbinarywolf/rmvx/internal/rubymarshal"
+)
+
+// Limits from Game_Party in the default scripts
+const (
+	maxGold       = 99999999
+	maxItemNumber = 99
+)
+
+// SaveEditor modifies a save file and writes it back without losing any data,
+// including objects and fields added by scripts that Save doesn't have.
+//
+// The setters change the save the same way the matching event commands would
+// in Game.exe, ie. changing a switch also refreshes the map when loaded.
+type SaveEditor struct {
+	header   *rubymarshal.Hash
+	contents *rubymarshal.Hash
+}
+
+// EditSave loads the save file in the given slot for editing, where slot 1
+// is "Save01.rvdata2"
+func EditSave(fsys fs.FS, slot int) (*SaveEditor, error) {
+	bytesData, err := readSaveFile(fsys, slot)
+	if err != nil {
+		return nil, err
+	}
+	return NewSaveEditor(bytesData)
+}
+
+// NewSaveEditor returns an editor for the contents of a save file
+func NewSaveEditor(bytesData []byte) (*SaveEditor, error) {
+	d := rubymarshal.NewDecoder(bytesData)
+	d.PreserveObjects()
+	var header, contents interface{}
+	if err := d.Decode(&header); err != nil {
+		return nil, fmt.Errorf("save header: %w", err)
+	}
+	if err := d.Decode(&contents); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("save contents: %w", err)
+	}
+	editor := &SaveEditor{}
+	var ok bool
+	if editor.header, ok = header.(*rubymarshal.Hash); !ok {
+		return nil, fmt.Errorf("%w: header is not a hash", ErrInvalidSave)
+	}
+	if editor.contents, ok = contents.(*rubymarshal.Hash); !ok {
+		return nil, fmt.Errorf("%w: contents is not a hash", ErrInvalidSave)
+	}
+	return editor, nil
+}
+
+// Bytes returns the save file with the changes made
+func (editor *SaveEditor) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	e := rubymarshal.NewEncoder(&buf)
+	if err := e.Encode(editor.header); err != nil {
+		return nil, err
+	}
+	if err := e.Encode(editor.contents); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo writes the save file with the changes made
+func (editor *SaveEditor) WriteTo(w io.Writer) (int64, error) {
+	bytesData, err := editor.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(bytesData)
+	return int64(n), err
+}
+
+// Save returns the save with the changes made
+func (editor *SaveEditor) Save() (*Save, error) {
+	bytesData, err := editor.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return decodeSave(bytesData)
+}
+
+// SetSwitch sets the value of a switch like the Control Switches command
+func (editor *SaveEditor) SetSwitch(switchID int, value bool) error {
+	if err := editor.setData("switches", switchID, value); err != nil {
+		return err
+	}
+	return editor.refreshMap()
+}
+
+// SetVariable sets the value of a variable like the Control Variables command
+func (editor *SaveEditor) SetVariable(variableID int, value int) error {
+	if err := editor.setData("variables", variableID, value); err != nil {
+		return err
+	}
+	return editor.refreshMap()
+}
+
+// SetSelfSwitch sets the value of an event's self switch, name is "A", "B", "C" or "D"
+func (editor *SaveEditor) SetSelfSwitch(mapID, eventID int, name string, value bool) error {
+	selfSwitches, err := editor.object("self_switches")
+	if err != nil {
+		return err
+	}
+	data, err := getField(selfSwitches, "@data")
+	if err != nil {
+		return err
+	}
+	hash, ok := data.(*rubymarshal.Hash)
+	if !ok {
+		return fmt.Errorf("%w: self_switches @data is not a hash", ErrInvalidSave)
+	}
+	key := &rubymarshal.Array{Elements: []interface{}{
+		mapID,
+		eventID,
+		&rubymarshal.RawString{Data: []byte(name), Encoding: rubymarshal.EncodingUTF8},
+	}}
+	hash.Set(key, value)
+	return editor.refreshMap()
+}
+
+// SetGold sets the party's gold, clamped between 0 and 99,999,999
+func (editor *SaveEditor) SetGold(gold int) error {
+	party, err := editor.object("party")
+	if err != nil {
+		return err
+	}
+	party.Set("@gold", clamp(gold, 0, maxGold))
+	return nil
+}
+
+// SetItemCount sets the number of an item held by the party, clamped between 0 and 99
+func (editor *SaveEditor) SetItemCount(itemID int, count int) error {
+	return editor.setItemCount("@items", itemID, count)
+}
+
+// SetWeaponCount sets the number of a weapon held by the party, clamped between 0 and 99
+func (editor *SaveEditor) SetWeaponCount(weaponID int, count int) error {
+	return editor.setItemCount("@weapons", weaponID, count)
+}
+
+// SetArmorCount sets the number of an armor held by the party, clamped between 0 and 99
+func (editor *SaveEditor) SetArmorCount(armorID int, count int) error {
+	return editor.setItemCount("@armors", armorID, count)
+}
+
+func (editor *SaveEditor) setItemCount(fieldName string, id int, count int) error {
+	if id <= 0 {
+		return fmt.Errorf("invalid id: %d", id)
+	}
+	party, err := editor.object("party")
+	if err != nil {
+		return err
+	}
+	container, err := getField(party, fieldName)
+	if err != nil {
+		return err
+	}
+	hash, ok := container.(*rubymarshal.Hash)
+	if !ok {
+		return fmt.Errorf("%w: party %s is not a hash", ErrInvalidSave, fieldName)
+	}
+	// Game_Party#gain_item deletes items the party no longer has
+	count = clamp(count, 0, maxItemNumber)
+	if count == 0 {
+		hash.Delete(id)
+		return nil
+	}
+	hash.Set(id, count)
+	return nil
+}
+
+// SetPartyMembers replaces the party with the given actor IDs, the first
+// actor is the leader.
+//
+// The player's graphic is changed to the leader's if the leader has been in
+// the party before, otherwise it's updated when Game.exe next refreshes the player.
+func (editor *SaveEditor) SetPartyMembers(actorIDs []int) error {
+	party, err := editor.object("party")
+	if err != nil {
+		return err
+	}
+	members := &rubymarshal.Array{Elements: make([]interface{}, 0, len(actorIDs))}
+	for _, actorID := range actorIDs {
+		if actorID <= 0 {
+			return fmt.Errorf("invalid actor id: %d", actorID)
+		}
+		members.Elements = append(members.Elements, actorID)
+	}
+	party.Set("@actors", members)
+	if len(actorIDs) > 0 {
+		if err := editor.refreshPlayer(actorIDs[0]); err != nil {
+			return err
+		}
+	}
+	return editor.refreshMap()
+}
+
+// refreshPlayer sets the player's graphic to the actor's like Game_Player#refresh
+func (editor *SaveEditor) refreshPlayer(actorID int) error {
+	actors, err := editor.object("actors")
+	if err != nil {
+		return err
+	}
+	data, err := getField(actors, "@data")
+	if err != nil {
+		return err
+	}
+	arr, ok := data.(*rubymarshal.Array)
+	if !ok || actorID >= len(arr.Elements) {
+		return nil
+	}
+	actor, ok := arr.Elements[actorID].(*rubymarshal.Object)
+	if !ok {
+		return nil
+	}
+	player, err := editor.object("player")
+	if err != nil {
+		return err
+	}
+	for _, fieldName := range []string{"@character_name", "@character_index"} {
+		if value, ok := actor.Get(fieldName); ok {
+			player.Set(fieldName, value)
+		}
+	}
+	return nil
+}
+
+// TransferPlayer moves the player like the Transfer Player command, the
+// transfer happens as soon as the save is loaded.
+//
+// Direction is 2 (down), 4 (left), 6 (right), 8 (up) or 0 to keep the
+// current direction.
+func (editor *SaveEditor) TransferPlayer(mapID, x, y, direction int) error {
+	if mapID <= 0 {
+		return fmt.Errorf("%w: invalid map id: %d", ErrMapNotFound, mapID)
+	}
+	player, err := editor.object("player")
+	if err != nil {
+		return err
+	}
+	player.Set("@transferring", true)
+	player.Set("@new_map_id", mapID)
+	player.Set("@new_x", x)
+	player.Set("@new_y", y)
+	player.Set("@new_direction", direction)
+	return nil
+}
+
+// refreshMap sets $game_map.need_refresh so that event pages are updated
+// after changing switches and variables
+func (editor *SaveEditor) refreshMap() error {
+	gameMap, err := editor.object("map")
+	if err != nil {
+		return err
+	}
+	gameMap.Set("@need_refresh", true)
+	return nil
+}
+
+// setData sets an entry in the @data array of Game_Switches or Game_Variables
+func (editor *SaveEditor) setData(key string, id int, value interface{}) error {
+	if id <= 0 {
+		return fmt.Errorf("invalid id: %d", id)
+	}
+	obj, err := editor.object(key)
+	if err != nil {
+		return err
+	}
+	data, err := getField(obj, "@data")
+	if err != nil {
+		return err
+	}
+	arr, ok := data.(*rubymarshal.Array)
+	if !ok {
+		return fmt.Errorf("%w: %s @data is not an array", ErrInvalidSave, key)
+	}
+	for len(arr.Elements) <= id {
+		arr.Elements = append(arr.Elements, nil)
+	}
+	arr.Elements[id] = value
+	return nil
+}
+
+// object returns an object from the save contents, ie. "party" for $game_party
+func (editor *SaveEditor) object(key string) (*rubymarshal.Object, error) {
+	value, _ := editor.contents.Get(rubymarshal.Symbol(key))
+	obj, ok := value.(*rubymarshal.Object)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not an object", ErrInvalidSave, key)
+	}
+	return obj, nil
+}
+
+func getField(obj *rubymarshal.Object, fieldName string) (interface{}, error) {
+	value, ok := obj.Get(fieldName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no %s", ErrInvalidSave, obj.Class, fieldName)
+	}
+	return value, nil
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,207 @@
+package rmvx
+
+// MutableGameState is a GameState that event commands can change, it's used
+// by Interpreter
+type MutableGameState interface {
+	GameState
+	SetSwitch(switchID int, value bool)
+	SetVariable(variableID int, value int)
+	SetSelfSwitch(mapID, eventID int, name string, value bool)
+	Gold() int
+	SetGold(gold int)
+	SetItemCount(itemID int, count int)
+	WeaponCount(weaponID int) int
+	SetWeaponCount(weaponID int, count int)
+	ArmorCount(armorID int) int
+	SetArmorCount(armorID int, count int)
+	// PartyMembers returns the actor IDs in the party, the first is the leader
+	PartyMembers() []int
+	SetPartyMembers(actorIDs []int)
+	// TransferPlayer moves the player to another map, direction is 0 to keep
+	// the current direction
+	TransferPlayer(mapID, x, y, direction int)
+}
+
+// MemoryState is a MutableGameState kept in memory
+type MemoryState struct {
+	mapID        int
+	x            int
+	y            int
+	direction    int
+	switches     map[int]bool
+	variables    map[int]int
+	selfSwitches map[selfSwitchKey]bool
+	gold         int
+	items        map[int]int
+	weapons      map[int]int
+	armors       map[int]int
+	party        []int
+}
+
+type selfSwitchKey struct {
+	mapID   int
+	eventID int
+	name    string
+}
+
+// NewMemoryState returns the state the save was made in or the state of a
+// new game if save is nil
+func NewMemoryState(save *Save) *MemoryState {
+	state := &MemoryState{
+		direction:    2,
+		switches:     make(map[int]bool),
+		variables:    make(map[int]int),
+		selfSwitches: make(map[selfSwitchKey]bool),
+		items:        make(map[int]int),
+		weapons:      make(map[int]int),
+		armors:       make(map[int]int),
+	}
+	if save == nil {
+		return state
+	}
+	state.mapID = save.Map.MapID
+	state.x = save.Player.X
+	state.y = save.Player.Y
+	state.direction = save.Player.Direction
+	for switchID, value := range save.Switches.Data {
+		if value {
+			state.switches[switchID] = true
+		}
+	}
+	for variableID := range save.Variables.Data {
+		if value := save.Variables.Get(variableID); value != 0 {
+			state.variables[variableID] = value
+		}
+	}
+	for _, pair := range save.SelfSwitches.Data {
+		key, ok := pair.Key.([]interface{})
+		if !ok || len(key) != 3 {
+			continue
+		}
+		mapID, _ := key[0].(int)
+		eventID, _ := key[1].(int)
+		name, _ := key[2].(string)
+		if value, _ := pair.Value.(bool); value {
+			state.selfSwitches[selfSwitchKey{mapID, eventID, name}] = true
+		}
+	}
+	state.gold = save.Party.Gold
+	for id, count := range save.Party.Items {
+		state.items[id] = count
+	}
+	for id, count := range save.Party.Weapons {
+		state.weapons[id] = count
+	}
+	for id, count := range save.Party.Armors {
+		state.armors[id] = count
+	}
+	state.party = append(state.party, save.Party.Actors...)
+	return state
+}
+
+// MapID returns the ID of the map the player is on
+func (state *MemoryState) MapID() int {
+	return state.mapID
+}
+
+// Position returns the player's position and direction on the map
+func (state *MemoryState) Position() (x, y, direction int) {
+	return state.x, state.y, state.direction
+}
+
+func (state *MemoryState) Switch(switchID int) bool {
+	return state.switches[switchID]
+}
+
+func (state *MemoryState) SetSwitch(switchID int, value bool) {
+	state.switches[switchID] = value
+}
+
+func (state *MemoryState) Variable(variableID int) int {
+	return state.variables[variableID]
+}
+
+func (state *MemoryState) SetVariable(variableID int, value int) {
+	state.variables[variableID] = value
+}
+
+func (state *MemoryState) SelfSwitch(mapID, eventID int, name string) bool {
+	return state.selfSwitches[selfSwitchKey{mapID, eventID, name}]
+}
+
+func (state *MemoryState) SetSelfSwitch(mapID, eventID int, name string, value bool) {
+	state.selfSwitches[selfSwitchKey{mapID, eventID, name}] = value
+}
+
+func (state *MemoryState) Gold() int {
+	return state.gold
+}
+
+// SetGold sets the party's gold, clamped between 0 and 99,999,999
+func (state *MemoryState) SetGold(gold int) {
+	state.gold = clamp(gold, 0, maxGold)
+}
+
+func (state *MemoryState) ItemCount(itemID int) int {
+	return state.items[itemID]
+}
+
+// SetItemCount sets the number of an item held by the party, clamped between 0 and 99
+func (state *MemoryState) SetItemCount(itemID int, count int) {
+	setItemCount(state.items, itemID, count)
+}
+
+func (state *MemoryState) WeaponCount(weaponID int) int {
+	return state.weapons[weaponID]
+}
+
+// SetWeaponCount sets the number of a weapon held by the party, clamped between 0 and 99
+func (state *MemoryState) SetWeaponCount(weaponID int, count int) {
+	setItemCount(state.weapons, weaponID, count)
+}
+
+func (state *MemoryState) ArmorCount(armorID int) int {
+	return state.armors[armorID]
+}
+
+// SetArmorCount sets the number of an armor held by the party, clamped between 0 and 99
+func (state *MemoryState) SetArmorCount(armorID int, count int) {
+	setItemCount(state.armors, armorID, count)
+}
+
+func (state *MemoryState) HasPartyMember(actorID int) bool {
+	for _, id := range state.party {
+		if id == actorID {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *MemoryState) PartyMembers() []int {
+	return state.party
+}
+
+func (state *MemoryState) SetPartyMembers(actorIDs []int) {
+	state.party = append([]int(nil), actorIDs...)
+}
+
+func (state *MemoryState) TransferPlayer(mapID, x, y, direction int) {
+	state.mapID = mapID
+	state.x = x
+	state.y = y
+	if direction != 0 {
+		state.direction = direction
+	}
+}
+
+// setItemCount follows Game_Party#gain_item, which deletes items the party
+// no longer has
+func setItemCount(container map[int]int, id int, count int) {
+	count = clamp(count, 0, maxItemNumber)
+	if count == 0 {
+		delete(container, id)
+		return
+	}
+	container[id] = count
+}
@@ -0,0 +1,254 @@
+package rmvx
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"unsafe"
+)
+
+// Table is a user-defined Ruby type for RPG Maker VX Ace
+type Table struct {
+	X    int32
+	Y    int32
+	Z    int32
+	Data []int16
+
+	// dimensions is the number of sizes given to Table.new in Ruby. It's
+	// only used so that tables are dumped exactly as they were loaded.
+	dimensions int32
+}
+
+// NewTable creates a table filled with zeros.
+//
+// Like Table.new in Ruby, negative sizes are treated as 0. Use 1 for
+// unused dimensions, ie. NewTable(10, 1, 1) for a 1-dimensional table.
+func NewTable(x, y, z int) Table {
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	if z < 0 {
+		z = 0
+	}
+	return Table{
+		X:    int32(x),
+		Y:    int32(y),
+		Z:    int32(z),
+		Data: make([]int16, x*y*z),
+	}
+}
+
+// NewTableFromData creates a table that uses the given data, which must be
+// x * y * z in length
+func NewTableFromData(x, y, z int, data []int16) (Table, error) {
+	if x < 0 || y < 0 || z < 0 || len(data) != x*y*z {
+		return Table{}, errors.New("Table: data length does not match size")
+	}
+	return Table{
+		X:    int32(x),
+		Y:    int32(y),
+		Z:    int32(z),
+		Data: data,
+	}, nil
+}
+
+// index returns the position of x, y, z in Data or -1 if it is out of bounds
+func (table *Table) index(x, y, z int) int {
+	if x < 0 || y < 0 || z < 0 ||
+		x >= int(table.X) || y >= int(table.Y) || z >= int(table.Z) {
+		return -1
+	}
+	return (int(table.X) * int(table.Y) * z) + (int(table.X) * y) + x
+}
+
+func (table *Table) Get(x, y, z int) int16 {
+	return table.Data[(table.X*table.Y*int32(z))+(table.X*int32(y))+int32(x)]
+}
+
+func (table *Table) GetInt32(x, y, z int32) int16 {
+	return table.Data[(table.X*table.Y*z)+(table.X*y)+x]
+}
+
+// GetWrappedInt32 wraps x and y around the edges of the table, ie. for a
+// table with X of 10, x of -1 and 19 will both get the value at x of 9.
+//
+// It returns 0 for a table with no cells.
+func (table *Table) GetWrappedInt32(x, y, z int32) int16 {
+	if table.X == 0 || table.Y == 0 {
+		return 0
+	}
+	xWrapped := x % table.X
+	if xWrapped < 0 {
+		xWrapped += table.X
+	}
+	yWrapped := y % table.Y
+	if yWrapped < 0 {
+		yWrapped += table.Y
+	}
+	return table.GetInt32(xWrapped, yWrapped, z)
+}
+
+// TryGet returns the value at x, y, z or false if it is out of bounds
+func (table *Table) TryGet(x, y, z int) (int16, bool) {
+	i := table.index(x, y, z)
+	if i == -1 {
+		return 0, false
+	}
+	return table.Data[i], true
+}
+
+// Set sets the value at x, y, z or returns false if it is out of bounds
+func (table *Table) Set(x, y, z int, value int16) bool {
+	i := table.index(x, y, z)
+	if i == -1 {
+		return false
+	}
+	table.Data[i] = value
+	return true
+}
+
+// Layer returns the values for the given z-index, ordered row by row.
+//
+// The returned slice shares memory with the table so modifying it modifies
+// the table. It returns nil if z is out of bounds.
+func (table *Table) Layer(z int) []int16 {
+	if z < 0 || z >= int(table.Z) {
+		return nil
+	}
+	layerSize := int(table.X) * int(table.Y)
+	start := layerSize * z
+	return table.Data[start : start+layerSize : start+layerSize]
+}
+
+// Resize changes the size of the table, keeping the values that are
+// still within bounds like Table#resize in Ruby. New values are zero.
+func (table *Table) Resize(x, y, z int) {
+	resized := NewTable(x, y, z)
+	copyX := minInt(int(table.X), int(resized.X))
+	copyY := minInt(int(table.Y), int(resized.Y))
+	copyZ := minInt(int(table.Z), int(resized.Z))
+	for k := 0; k < copyZ; k++ {
+		for j := 0; j < copyY; j++ {
+			src := table.index(0, j, k)
+			dst := resized.index(0, j, k)
+			copy(resized.Data[dst:dst+copyX], table.Data[src:src+copyX])
+		}
+	}
+	*table = resized
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// tableHeaderSize is the argument count, x, y, z and the total size stored
+// as int32s before the table data
+const tableHeaderSize = 5 * 4
+
+// maxTableSize is the largest table that decodeTable will load
+const maxTableSize = 1 << 27
+
+// isLittleEndian is true if the machine stores integers in the same byte
+// order as RPG Maker VX Ace files
+var isLittleEndian = func() bool {
+	v := uint16(1)
+	return *(*byte)(unsafe.Pointer(&v)) == 1
+}()
+
+// decodeTable reads the data written by Table#_dump
+//
+// If alias is true and the machine is little-endian, the table data shares
+// memory with the given data.
+func decodeTable(data []byte, alias bool) Table {
+	if len(data) < tableHeaderSize {
+		panic(errors.New("Table: bad file format"))
+	}
+	dimensions := int32(binary.LittleEndian.Uint32(data[0:]))
+	x := int32(binary.LittleEndian.Uint32(data[4:]))
+	y := int32(binary.LittleEndian.Uint32(data[8:]))
+	z := int32(binary.LittleEndian.Uint32(data[12:]))
+	size := int32(binary.LittleEndian.Uint32(data[16:]))
+	if x < 0 || y < 0 || z < 0 ||
+		int64(size) != int64(x)*int64(y)*int64(z) ||
+		size > maxTableSize ||
+		len(data)-tableHeaderSize < int(size)*2 {
+		panic(errors.New("Table: bad file format"))
+	}
+	cells := data[tableHeaderSize : tableHeaderSize+int(size)*2]
+
+	var tableData []int16
+	switch {
+	case size == 0:
+		tableData = make([]int16, 0)
+	case isLittleEndian && alias && uintptr(unsafe.Pointer(&cells[0]))%unsafe.Alignof(int16(0)) == 0:
+		tableData = bytesAsInt16s(cells)
+	case isLittleEndian:
+		// note(jae): 2021-06-09
+		// Original Ruby source code does a memcpy(), so do the same.
+		tableData = make([]int16, size)
+		copy(int16sAsBytes(tableData), cells)
+	default:
+		tableData = make([]int16, size)
+		for i := range tableData {
+			tableData[i] = int16(binary.LittleEndian.Uint16(cells[i*2:]))
+		}
+	}
+	return Table{
+		X:          x,
+		Y:          y,
+		Z:          z,
+		Data:       tableData,
+		dimensions: dimensions,
+	}
+}
+
+// dumpTable writes the same data as Table#_dump
+func dumpTable(val reflect.Value) []byte {
+	table := reflect.Indirect(val).Interface().(Table)
+	dimensions := table.dimensions
+	if dimensions == 0 {
+		switch {
+		case table.Z > 1:
+			dimensions = 3
+		case table.Y > 1:
+			dimensions = 2
+		default:
+			dimensions = 1
+		}
+	}
+	data := make([]byte, 0, tableHeaderSize+len(table.Data)*2)
+	data = appendInt32(data, dimensions)
+	data = appendInt32(data, table.X)
+	data = appendInt32(data, table.Y)
+	data = appendInt32(data, table.Z)
+	data = appendInt32(data, int32(len(table.Data)))
+	if isLittleEndian && len(table.Data) > 0 {
+		return append(data, int16sAsBytes(table.Data)...)
+	}
+	var buf [2]byte
+	for _, v := range table.Data {
+		binary.LittleEndian.PutUint16(buf[:], uint16(v))
+		data = append(data, buf[:]...)
+	}
+	return data
+}
+
+// bytesAsInt16s returns a slice that shares memory with b, which must be aligned
+// for int16 and no larger than maxTableSize*2
+func bytesAsInt16s(b []byte) []int16 {
+	n := len(b) / 2
+	return (*[maxTableSize]int16)(unsafe.Pointer(&b[0]))[:n:n]
+}
+
+// int16sAsBytes returns a slice that shares memory with v, which must be no
+// larger than maxTableSize
+func int16sAsBytes(v []int16) []byte {
+	n := len(v) * 2
+	return (*[maxTableSize * 2]byte)(unsafe.Pointer(&v[0]))[:n:n]
+}
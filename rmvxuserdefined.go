@@ -0,0 +1,138 @@
+package rmvx
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+
+	"github.com/silbinarywolf/rmvx/internal/rubymarshal"
+)
+
+// RGSS only implements _dump and _load for Table, Tone, Color and Rect.
+// Font, Viewport, Bitmap, Sprite, etc can't be marshalled (Ruby raises a
+// TypeError) so they never appear in data or save files. Scripts that
+// want to store font or viewport settings store plain values instead, which
+// decode like any other field.
+
+// userDefinedType is an RGSS class that is marshalled with _dump and _load
+type userDefinedType struct {
+	className string
+	// goTypes are the Go types that are dumped as this class
+	goTypes []reflect.Type
+	load    func(data []byte, v reflect.Value)
+	dump    func(v reflect.Value) []byte
+}
+
+var userDefinedTypes = []userDefinedType{
+	{"Table", []reflect.Type{reflect.TypeOf(Table{})}, loadTable, dumpTable},
+	{"Tone", []reflect.Type{reflect.TypeOf(Tone{}), toneFloatType}, loadTone, dumpTone},
+	{"Color", []reflect.Type{reflect.TypeOf(Color{})}, loadColor, dumpColor},
+	{"Rect", []reflect.Type{reflect.TypeOf(Rect{})}, loadRect, dumpRect},
+}
+
+// addUserDefinedLoads registers every RGSS user-defined type with the decoder
+func addUserDefinedLoads(d *rubymarshal.Decoder, options *LoadOptions) {
+	for _, userDefined := range userDefinedTypes {
+		load := userDefined.load
+		switch {
+		case userDefined.className == "Table" && options.AliasTableData:
+			load = loadTableAliased
+		case userDefined.className == "Tone" && options.PreciseTone:
+			load = loadPreciseTone
+		}
+		d.AddUserDefinedLoad(userDefined.className, load)
+	}
+}
+
+// setUserDefined stores the loaded value in val, allocating a pointer if
+// val is a pointer or interface
+func setUserDefined(className string, value reflect.Value, val reflect.Value) {
+	switch val.Elem().Kind() {
+	case reflect.Ptr, reflect.Interface:
+		ptr := reflect.New(value.Type())
+		ptr.Elem().Set(value)
+		val.Elem().Set(ptr)
+	case reflect.Struct:
+		val.Elem().Set(value)
+	default:
+		panic("load" + className + ": unhandled type: " + val.Elem().Kind().String())
+	}
+}
+
+// Color is a user-defined Ruby type for RPG Maker VX Ace
+//
+// Each value ranges between 0 and 255. It's used by flash effects in
+// animations and event commands.
+type Color struct {
+	Red, Green, Blue float64
+	Alpha            float64
+}
+
+func loadColor(data []byte, val reflect.Value) {
+	if len(data) != 4*8 {
+		panic(errors.New("Color: bad file format"))
+	}
+	value := Color{
+		Red:   readFloat64(data[0:]),
+		Green: readFloat64(data[8:]),
+		Blue:  readFloat64(data[16:]),
+		Alpha: readFloat64(data[24:]),
+	}
+	setUserDefined("Color", reflect.ValueOf(value), val)
+}
+
+func dumpColor(val reflect.Value) []byte {
+	value := reflect.Indirect(val).Interface().(Color)
+	data := make([]byte, 0, 4*8)
+	data = appendFloat64(data, value.Red)
+	data = appendFloat64(data, value.Green)
+	data = appendFloat64(data, value.Blue)
+	data = appendFloat64(data, value.Alpha)
+	return data
+}
+
+// Rect is a user-defined Ruby type for RPG Maker VX Ace
+type Rect struct {
+	X, Y          int32
+	Width, Height int32
+}
+
+func loadRect(data []byte, val reflect.Value) {
+	if len(data) != 4*4 {
+		panic(errors.New("Rect: bad file format"))
+	}
+	value := Rect{
+		X:      int32(binary.LittleEndian.Uint32(data[0:])),
+		Y:      int32(binary.LittleEndian.Uint32(data[4:])),
+		Width:  int32(binary.LittleEndian.Uint32(data[8:])),
+		Height: int32(binary.LittleEndian.Uint32(data[12:])),
+	}
+	setUserDefined("Rect", reflect.ValueOf(value), val)
+}
+
+func dumpRect(val reflect.Value) []byte {
+	value := reflect.Indirect(val).Interface().(Rect)
+	data := make([]byte, 0, 4*4)
+	data = appendInt32(data, value.X)
+	data = appendInt32(data, value.Y)
+	data = appendInt32(data, value.Width)
+	data = appendInt32(data, value.Height)
+	return data
+}
+
+func readFloat64(b []byte) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func appendFloat64(b []byte, v float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(v))
+	return append(b, buf[:]...)
+}
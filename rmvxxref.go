@@ -0,0 +1,308 @@
+package rmvx
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Move route command codes used by the default scripts
+const (
+	moveRouteSwitchOn  = 27
+	moveRouteSwitchOff = 28
+)
+
+// ReferenceKind is whether a switch or variable is read or written
+type ReferenceKind int
+
+const (
+	ReferenceRead ReferenceKind = iota
+	ReferenceWrite
+)
+
+func (kind ReferenceKind) String() string {
+	switch kind {
+	case ReferenceRead:
+		return "read"
+	case ReferenceWrite:
+		return "write"
+	}
+	return fmt.Sprintf("ReferenceKind(%d)", int(kind))
+}
+
+// MarshalText encodes the kind as "read" or "write" for JSON
+func (kind ReferenceKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+// Reference is a place in a map event, common event or troop that reads or
+// writes a switch, variable or self switch
+type Reference struct {
+	Kind ReferenceKind `json:"kind"`
+	// MapID and EventID are set for map events
+	MapID         int `json:"mapId,omitempty"`
+	EventID       int `json:"eventId,omitempty"`
+	CommonEventID int `json:"commonEventId,omitempty"`
+	TroopID       int `json:"troopId,omitempty"`
+	// Page is the index of the map event or troop page, starting from 0
+	Page int `json:"page"`
+	// Command is the index of the command in the page's list or -1 for page
+	// conditions, the trigger of a common event and the page's move route
+	Command int `json:"command"`
+	// Code is the code of the command or 0 if Command is -1
+	Code int `json:"code"`
+	// MoveRoute is true for switches turned on or off by a move route
+	MoveRoute bool `json:"moveRoute,omitempty"`
+}
+
+// References is a list of references in the order they appear in the project
+type References []Reference
+
+// Reads returns the references that read the value
+func (references References) Reads() References {
+	return references.filter(ReferenceRead)
+}
+
+// Writes returns the references that change the value
+func (references References) Writes() References {
+	return references.filter(ReferenceWrite)
+}
+
+func (references References) filter(kind ReferenceKind) References {
+	var filtered References
+	for _, reference := range references {
+		if reference.Kind == kind {
+			filtered = append(filtered, reference)
+		}
+	}
+	return filtered
+}
+
+// CrossReference is an index of where each switch, variable and self switch
+// is used, it can be encoded with encoding/json.
+//
+// Switches and variables are read by page conditions, the trigger of common
+// events and Conditional Branch. They're written by Control Switches, Control
+// Variables, Input Number, Select Key Item and move routes. Commands that take
+// a variable as an operand, such as Change Gold, read the variable too.
+type CrossReference struct {
+	Switches  map[int]References `json:"switches"`
+	Variables map[int]References `json:"variables"`
+	// SelfSwitches is keyed by "A", "B", "C" or "D", the map event in each
+	// reference is the event the self switch belongs to
+	SelfSwitches map[string]References `json:"selfSwitches"`
+}
+
+// NewCrossReference returns an empty index, use Project.CrossReference to
+// index an entire project
+func NewCrossReference() *CrossReference {
+	return &CrossReference{
+		Switches:     make(map[int]References),
+		Variables:    make(map[int]References),
+		SelfSwitches: make(map[string]References),
+	}
+}
+
+// CrossReference indexes every map, common event and troop in the project
+func (project *Project) CrossReference() (*CrossReference, error) {
+	index := NewCrossReference()
+	mapIDs, err := project.listMapFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, mapID := range mapIDs {
+		m, err := project.Map(mapID)
+		if err != nil {
+			return nil, err
+		}
+		index.AddMap(mapID, m)
+	}
+	commonEvents, err := project.GetCommonEvents()
+	if err != nil {
+		return nil, err
+	}
+	index.AddCommonEvents(commonEvents)
+	troops, err := project.GetTroops()
+	if err != nil {
+		return nil, err
+	}
+	index.AddTroops(troops)
+	return index, nil
+}
+
+// Switch returns where the switch is used
+func (index *CrossReference) Switch(switchID int) References {
+	return index.Switches[switchID]
+}
+
+// Variable returns where the variable is used
+func (index *CrossReference) Variable(variableID int) References {
+	return index.Variables[variableID]
+}
+
+// SelfSwitch returns where the self switch of a map event is used, including
+// common events as they change the self switches of the event that called them
+func (index *CrossReference) SelfSwitch(mapID, eventID int, name string) References {
+	var references References
+	for _, reference := range index.SelfSwitches[name] {
+		if (reference.MapID == mapID && reference.EventID == eventID) || reference.CommonEventID != 0 {
+			references = append(references, reference)
+		}
+	}
+	return references
+}
+
+// AddMap indexes the pages of every event on the map
+func (index *CrossReference) AddMap(mapID int, m *Map) {
+	eventIDs := make([]int, 0, len(m.Events))
+	for eventID := range m.Events {
+		eventIDs = append(eventIDs, eventID)
+	}
+	sort.Ints(eventIDs)
+	for _, eventID := range eventIDs {
+		event := m.Events[eventID]
+		for i := range event.Pages {
+			page := &event.Pages[i]
+			location := Reference{MapID: mapID, EventID: eventID, Page: i, Command: -1}
+			condition := &page.Condition
+			if condition.Switch1Valid {
+				index.addSwitch(condition.Switch1ID, ReferenceRead, location)
+			}
+			if condition.Switch2Valid {
+				index.addSwitch(condition.Switch2ID, ReferenceRead, location)
+			}
+			if condition.VariableValid {
+				index.addVariable(condition.VariableID, ReferenceRead, location)
+			}
+			if condition.SelfSwitchValid {
+				index.addSelfSwitch(condition.SelfSwitchCH, ReferenceRead, location)
+			}
+			// the custom move route is only used with the "Custom" autonomous movement type
+			if page.MoveType == 3 {
+				for _, item := range page.MoveRoute.List {
+					index.addMoveRouteItem(item.Code, item.Parameters, location)
+				}
+			}
+			index.addCommands(page.List, location)
+		}
+	}
+}
+
+// AddCommonEvents indexes the common events, the 0th entry is skipped
+func (index *CrossReference) AddCommonEvents(commonEvents []CommonEvent) {
+	for i := range commonEvents {
+		commonEvent := &commonEvents[i]
+		if commonEvent.ID == 0 {
+			continue
+		}
+		location := Reference{CommonEventID: commonEvent.ID, Command: -1}
+		if commonEvent.Trigger != 0 {
+			index.addSwitch(commonEvent.SwitchID, ReferenceRead, location)
+		}
+		index.addCommands(commonEvent.List, location)
+	}
+}
+
+// AddTroops indexes the battle event pages of the troops, the 0th entry is skipped
+func (index *CrossReference) AddTroops(troops []Troop) {
+	for i := range troops {
+		troop := &troops[i]
+		if troop.ID == 0 {
+			continue
+		}
+		for i := range troop.Pages {
+			page := &troop.Pages[i]
+			location := Reference{TroopID: troop.ID, Page: i, Command: -1}
+			if page.Condition.SwitchValid {
+				index.addSwitch(page.Condition.SwitchID, ReferenceRead, location)
+			}
+			index.addCommands(page.List, location)
+		}
+	}
+}
+
+func (index *CrossReference) addCommands(list []EventCommand, location Reference) {
+	for i, command := range list {
+		location.Command = i
+		location.Code = command.Code
+		params := command.Parameters
+		switch command.Code {
+		case commandConditionalBranch:
+			switch paramInt(params, 0) {
+			case 0:
+				index.addSwitch(paramInt(params, 1), ReferenceRead, location)
+			case 1:
+				index.addVariable(paramInt(params, 1), ReferenceRead, location)
+				if paramInt(params, 2) != 0 {
+					index.addVariable(paramInt(params, 3), ReferenceRead, location)
+				}
+			case 2:
+				index.addSelfSwitch(paramString(params, 1), ReferenceRead, location)
+			}
+		case commandControlSwitches:
+			for switchID := paramInt(params, 0); switchID <= paramInt(params, 1); switchID++ {
+				index.addSwitch(switchID, ReferenceWrite, location)
+			}
+		case commandControlVariables:
+			if paramInt(params, 3) == 1 {
+				index.addVariable(paramInt(params, 4), ReferenceRead, location)
+			}
+			for variableID := paramInt(params, 0); variableID <= paramInt(params, 1); variableID++ {
+				index.addVariable(variableID, ReferenceWrite, location)
+			}
+		case commandControlSelfSwitch:
+			// troop events have no event for the self switch to belong to
+			if location.TroopID == 0 {
+				index.addSelfSwitch(paramString(params, 0), ReferenceWrite, location)
+			}
+		case commandInputNumber, commandSelectItem:
+			index.addVariable(paramInt(params, 0), ReferenceWrite, location)
+		case commandChangeGold:
+			if paramInt(params, 1) != 0 {
+				index.addVariable(paramInt(params, 2), ReferenceRead, location)
+			}
+		case commandChangeItems, commandChangeWeapons, commandChangeArmors:
+			if paramInt(params, 2) != 0 {
+				index.addVariable(paramInt(params, 3), ReferenceRead, location)
+			}
+		case commandTransferPlayer:
+			if paramInt(params, 0) != 0 {
+				for i := 1; i <= 3; i++ {
+					index.addVariable(paramInt(params, i), ReferenceRead, location)
+				}
+			}
+		case commandSetMoveRoute:
+			// move routes in parameters are decoded as map[string]interface{}
+			route, _ := param(params, 1).(map[string]interface{})
+			items, _ := route["@list"].([]interface{})
+			for _, item := range items {
+				item, _ := item.(map[string]interface{})
+				code, _ := item["@code"].(int)
+				itemParams, _ := item["@parameters"].([]interface{})
+				index.addMoveRouteItem(code, itemParams, location)
+			}
+		}
+	}
+}
+
+func (index *CrossReference) addMoveRouteItem(code int, params []interface{}, location Reference) {
+	if code != moveRouteSwitchOn && code != moveRouteSwitchOff {
+		return
+	}
+	location.MoveRoute = true
+	index.addSwitch(paramInt(params, 0), ReferenceWrite, location)
+}
+
+func (index *CrossReference) addSwitch(switchID int, kind ReferenceKind, location Reference) {
+	location.Kind = kind
+	index.Switches[switchID] = append(index.Switches[switchID], location)
+}
+
+func (index *CrossReference) addVariable(variableID int, kind ReferenceKind, location Reference) {
+	location.Kind = kind
+	index.Variables[variableID] = append(index.Variables[variableID], location)
+}
+
+func (index *CrossReference) addSelfSwitch(name string, kind ReferenceKind, location Reference) {
+	location.Kind = kind
+	index.SelfSwitches[name] = append(index.SelfSwitches[name], location)
+}